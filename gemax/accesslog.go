@@ -0,0 +1,230 @@
+package gemax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// AccessLogFormat is an access log record format.
+type AccessLogFormat int
+
+const (
+	// CommonLogFormat is a gemini flavoured Common Log Format:
+	//
+	//	host - cert_hash [time] "url" status bytes "meta" duration_seconds
+	//
+	// Missing client certificate hash is written as "-".
+	CommonLogFormat AccessLogFormat = iota
+	// JSONLogFormat writes a JSON object per line.
+	JSONLogFormat
+)
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLog writes a record for each served request.
+// It's safe for concurrent use.
+type AccessLog struct {
+	// Output of access log records. See RotatingFile.
+	Writer io.Writer
+	// Record format. Default is CommonLogFormat.
+	Format AccessLogFormat
+
+	mu sync.Mutex
+}
+
+// AccessRecord describes a served request.
+type AccessRecord struct {
+	Time       time.Time     `json:"time"`
+	RemoteAddr string        `json:"remote_addr"`
+	URL        string        `json:"url"`
+	Status     status.Code   `json:"status"`
+	Meta       string        `json:"meta"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"duration_ns"`
+	CertHash   string        `json:"cert_hash,omitempty"`
+}
+
+// LogAccess wraps handler and writes an access log record after each request.
+// If the handler panics before writing the response status, then the request is logged
+// as status.TemporaryFailure and the panic is passed on to the server.
+//
+// Example:
+//
+//	LogAccess(&AccessLog{Writer: os.Stdout}, fileSystem.Serve)
+func LogAccess(accessLog *AccessLog, handler Handler) Handler {
+	return func(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
+		var start = time.Now()
+		var recorder = WrapResponseWriter(rw)
+		var isPanicked = true
+		defer func() {
+			var record = AccessRecord{
+				Time:       start,
+				RemoteAddr: req.RemoteAddr(),
+				URL:        req.URL().String(),
+				Status:     recorder.Status(),
+				Meta:       recorder.Meta(),
+				Bytes:      recorder.BytesWritten(),
+				Duration:   time.Since(start),
+			}
+			if certs := req.Certificates(); len(certs) > 0 {
+				record.CertHash = CertificateFingerprint(certs[0])
+			}
+			switch {
+			case recorder.Written():
+				// pass
+			case isPanicked:
+				// server closes the connection without a response
+				record.Status = status.TemporaryFailure
+			default:
+				// status is written by the server after the handler returns
+				record.Status, record.Meta = status.Success, MIMEGemtext
+			}
+			_ = accessLog.Write(&record)
+		}()
+		handler(ctx, recorder, req)
+		isPanicked = false
+	}
+}
+
+// Write writes a single record.
+func (accessLog *AccessLog) Write(record *AccessRecord) error {
+	var line []byte
+	switch accessLog.Format {
+	case JSONLogFormat:
+		var errMarshal error
+		line, errMarshal = json.Marshal(record)
+		if errMarshal != nil {
+			return errMarshal
+		}
+	default:
+		line = appendCommonLog(nil, record)
+	}
+	line = append(line, '\n')
+
+	accessLog.mu.Lock()
+	defer accessLog.mu.Unlock()
+	var _, errWrite = accessLog.Writer.Write(line)
+	return errWrite
+}
+
+func appendCommonLog(buf []byte, record *AccessRecord) []byte {
+	var host, _, errSplit = net.SplitHostPort(record.RemoteAddr)
+	if errSplit != nil {
+		host = record.RemoteAddr
+	}
+	var certHash = record.CertHash
+	if certHash == "" {
+		certHash = "-"
+	}
+	buf = append(buf, host...)
+	buf = append(buf, " - "...)
+	buf = append(buf, certHash...)
+	buf = append(buf, " ["...)
+	buf = record.Time.AppendFormat(buf, clfTimeLayout)
+	buf = append(buf, "] "...)
+	buf = strconv.AppendQuote(buf, record.URL)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(record.Status), 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, record.Bytes, 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendQuote(buf, record.Meta)
+	buf = append(buf, ' ')
+	return strconv.AppendFloat(buf, record.Duration.Seconds(), 'f', 3, 64)
+}
+
+// RotatingFile is a file writer, which rotates the file when it exceeds the size limit.
+// Rotated files are renamed to "<path>.1", "<path>.2", etc, the oldest ones are removed.
+// It's safe for concurrent use.
+type RotatingFile struct {
+	// Path to the file. Missing file is created.
+	Path string
+	// Maximum file size in bytes.
+	//	<=0 - no rotation
+	MaxSize int64
+	// Maximum number of rotated files to keep.
+	//	0 - 1 file
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+var _ io.WriteCloser = new(RotatingFile)
+
+// Write appends data to the file. The file is rotated before the write,
+// if the write would exceed MaxSize.
+func (file *RotatingFile) Write(data []byte) (int, error) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.file == nil {
+		if err := file.open(); err != nil {
+			return 0, err
+		}
+	}
+	if file.MaxSize > 0 && file.size > 0 && file.size+int64(len(data)) > file.MaxSize {
+		if err := file.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	var n, errWrite = file.file.Write(data)
+	file.size += int64(n)
+	return n, errWrite
+}
+
+// Close closes the file.
+func (file *RotatingFile) Close() error {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.file == nil {
+		return nil
+	}
+	var errClose = file.file.Close()
+	file.file = nil
+	return errClose
+}
+
+func (file *RotatingFile) open() error {
+	//nolint:gosec // log path is provided by the server owner
+	var f, errOpen = os.OpenFile(file.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if errOpen != nil {
+		return fmt.Errorf("opening log file: %w", errOpen)
+	}
+	var info, errStat = f.Stat()
+	if errStat != nil {
+		_ = f.Close()
+		return fmt.Errorf("opening log file: %w", errStat)
+	}
+	file.file, file.size = f, info.Size()
+	return nil
+}
+
+func (file *RotatingFile) rotate() error {
+	if err := file.file.Close(); err != nil {
+		return fmt.Errorf("rotating log file: %w", err)
+	}
+	file.file = nil
+	var backups = max(file.MaxBackups, 1)
+	_ = os.Remove(file.backupName(backups))
+	for i := backups - 1; i >= 1; i-- {
+		_ = os.Rename(file.backupName(i), file.backupName(i+1))
+	}
+	if err := os.Rename(file.Path, file.backupName(1)); err != nil {
+		return fmt.Errorf("rotating log file: %w", err)
+	}
+	return file.open()
+}
+
+func (file *RotatingFile) backupName(i int) string {
+	return file.Path + "." + strconv.Itoa(i)
+}
@@ -0,0 +1,118 @@
+package gemax_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+func TestLogAccess(test *testing.T) {
+	var cert, _ = x509.ParseCertificate(testCert("alice").Certificate[0])
+	var serve = func(format gemax.AccessLogFormat, handler gemax.Handler, certs ...*x509.Certificate) string {
+		var out = &bytes.Buffer{}
+		var accessLog = &gemax.AccessLog{Writer: out, Format: format}
+		gemax.LogAccess(accessLog, handler)(context.Background(), &responseRecorder{}, &request{
+			remoteAddr: "192.0.2.1:4000",
+			url:        "gemini://example.com/page",
+			certs:      certs,
+		})
+		return out.String()
+	}
+
+	test.Run("common log format", func(test *testing.T) {
+		var line = serve(gemax.CommonLogFormat, gemax.ServeContent(gemax.MIMEGemtext, []byte("hello")))
+		var expected = regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "gemini://example.com/page" 20 5 "text/gemini" \d+\.\d{3}\n$`)
+		if !expected.MatchString(line) {
+			test.Fatalf("unexpected record %q", line)
+		}
+	})
+
+	test.Run("certificate hash", func(test *testing.T) {
+		var line = serve(gemax.CommonLogFormat, func(_ context.Context, rw gemax.ResponseWriter, req gemax.IncomingRequest) {
+			gemax.NotFound(rw, req)
+		}, cert)
+		var hash = gemax.CertificateFingerprint(cert)
+		if !strings.HasPrefix(line, "192.0.2.1 - "+hash+" [") || !strings.Contains(line, `" 51 0 "`) {
+			test.Fatalf("unexpected record %q", line)
+		}
+	})
+
+	test.Run("json lines", func(test *testing.T) {
+		var line = serve(gemax.JSONLogFormat, func(_ context.Context, rw gemax.ResponseWriter, _ gemax.IncomingRequest) {
+			rw.WriteStatus(status.Redirect, "/other")
+		}, cert)
+		var record gemax.AccessRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			test.Fatalf("parsing record %q: %v", line, err)
+		}
+		if record.Status != status.Redirect || record.Meta != "/other" ||
+			record.URL != "gemini://example.com/page" || record.RemoteAddr != "192.0.2.1:4000" ||
+			record.CertHash != gemax.CertificateFingerprint(cert) {
+			test.Fatalf("unexpected record %+v", record)
+		}
+	})
+
+	test.Run("implicit status", func(test *testing.T) {
+		var line = serve(gemax.CommonLogFormat, func(context.Context, gemax.ResponseWriter, gemax.IncomingRequest) {})
+		if !strings.Contains(line, `" 20 0 "text/gemini" `) {
+			test.Fatalf("unexpected record %q", line)
+		}
+	})
+
+	test.Run("panic", func(test *testing.T) {
+		var out = &bytes.Buffer{}
+		var handler = gemax.LogAccess(&gemax.AccessLog{Writer: out}, func(context.Context, gemax.ResponseWriter, gemax.IncomingRequest) {
+			panic("boom")
+		})
+		var recovered = func() (recovered any) {
+			defer func() { recovered = recover() }()
+			handler(context.Background(), &responseRecorder{}, &request{url: "gemini://example.com/page"})
+			return nil
+		}()
+		if recovered != "boom" {
+			test.Fatalf("expected panic to be passed on, got %v", recovered)
+		}
+		if !strings.Contains(out.String(), `" 40 0 "" `) {
+			test.Fatalf("unexpected record %q", out.String())
+		}
+	})
+}
+
+func TestRotatingFile(test *testing.T) {
+	var path = filepath.Join(test.TempDir(), "access.log")
+	var file = &gemax.RotatingFile{Path: path, MaxSize: 10, MaxBackups: 2}
+	test.Cleanup(func() { _ = file.Close() })
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	var expected = map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for name, content := range expected {
+		var data, errRead = os.ReadFile(name)
+		if errRead != nil {
+			test.Fatal(errRead)
+		}
+		if string(data) != content {
+			test.Errorf("%s: expected %q, got %q", name, content, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		test.Errorf("expected only 2 backups, got %v", err)
+	}
+}
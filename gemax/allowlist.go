@@ -0,0 +1,144 @@
+package gemax
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	urlpkg "net/url"
+	"slices"
+	"strings"
+)
+
+// AnyPublicHost is a HostAllowlist entry, which allows any host with a public address.
+const AnyPublicHost = "*"
+
+// ErrHostNotAllowed means that the upstream host is not allowed by HostAllowlist.
+var ErrHostNotAllowed = errors.New("host is not allowed")
+
+// HostAllowlist is a list of upstream hosts, which proxies are allowed to connect to.
+// Both hostnames and host:port pairs are accepted. An empty list allows nothing.
+//
+// Addresses from special-purpose ranges (loopback, private, shared, link-local, documentation,
+// multicast, etc.), including their IPv4-mapped, NAT64 and 6to4 forms, are allowed only
+// if they are listed explicitly, AnyPublicHost doesn't match them. Use Dial to apply the restriction
+// to resolved addresses as well.
+type HostAllowlist []string
+
+// Allowed reports whether the URL host is allowed. Host names are not resolved.
+func (hosts HostAllowlist) Allowed(u *urlpkg.URL) bool {
+	switch {
+	case u.Host == "":
+		return false
+	case hosts.listed(u.Host, u.Hostname()):
+		return true
+	case !slices.Contains(hosts, AnyPublicHost):
+		return false
+	}
+	var hostname = strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if hostname == "localhost" || strings.HasSuffix(hostname, ".localhost") {
+		return false
+	}
+	if addr, errAddr := netip.ParseAddr(hostname); errAddr == nil {
+		return publicAddr(addr)
+	}
+	return true
+}
+
+// Dial can be used as Client.Dial. Hosts, which are not listed explicitly,
+// are resolved and dialed only if they have public addresses, so DNS records
+// can't point the client to internal services.
+func (hosts HostAllowlist) Dial(ctx context.Context, host string, cfg *tls.Config) (net.Conn, error) {
+	var hostname, port, errSplit = net.SplitHostPort(host)
+	if errSplit != nil {
+		return nil, errSplit
+	}
+	var dialer = &tls.Dialer{Config: cfg}
+	if hosts.listed(host, hostname) {
+		return dialer.DialContext(ctx, "tcp", host)
+	}
+	if !slices.Contains(hosts, AnyPublicHost) {
+		return nil, fmt.Errorf("%w: %s", ErrHostNotAllowed, host)
+	}
+
+	var addrs, errLookup = net.DefaultResolver.LookupNetIP(ctx, "ip", hostname)
+	if errLookup != nil {
+		return nil, errLookup
+	}
+	var public = slices.IndexFunc(addrs, publicAddr)
+	if public < 0 {
+		return nil, fmt.Errorf("%w: %s doesn't resolve to a public address", ErrHostNotAllowed, host)
+	}
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = hostname
+		dialer.Config = cfg
+	}
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort(addrs[public].String(), port))
+}
+
+func (hosts HostAllowlist) listed(host, hostname string) bool {
+	return slices.Contains(hosts, host) || slices.Contains(hosts, hostname)
+}
+
+// specialNetworks are the IANA IPv4 and IPv6 special-purpose address ranges,
+// which are not reachable in the public internet or must not be proxied to.
+var specialNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this" network
+	netip.MustParsePrefix("10.0.0.0/8"),      // private use
+	netip.MustParsePrefix("100.64.0.0/10"),   // shared address space (CGNAT)
+	netip.MustParsePrefix("127.0.0.0/8"),     // loopback
+	netip.MustParsePrefix("169.254.0.0/16"),  // link local
+	netip.MustParsePrefix("172.16.0.0/12"),   // private use
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation (TEST-NET-1)
+	netip.MustParsePrefix("192.88.99.0/24"),  // deprecated 6to4 relay anycast
+	netip.MustParsePrefix("192.168.0.0/16"),  // private use
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation (TEST-NET-2)
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation (TEST-NET-3)
+	netip.MustParsePrefix("224.0.0.0/4"),     // multicast
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, limited broadcast
+	netip.MustParsePrefix("::/128"),          // unspecified
+	netip.MustParsePrefix("::1/128"),         // loopback
+	netip.MustParsePrefix("::/96"),           // deprecated IPv4-compatible
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use IPv4/IPv6 translation
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("3fff::/20"),       // documentation
+	netip.MustParsePrefix("5f00::/16"),       // segment routing SIDs
+	netip.MustParsePrefix("fc00::/7"),        // unique local
+	netip.MustParsePrefix("fe80::/10"),       // link local
+	netip.MustParsePrefix("fec0::/10"),       // deprecated site local
+	netip.MustParsePrefix("ff00::/8"),        // multicast
+}
+
+var (
+	nat64Network = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour    = netip.MustParsePrefix("2002::/16")
+)
+
+// publicAddr reports whether the address doesn't belong to special-purpose ranges.
+// IPv4 addresses embedded into IPv4-mapped, NAT64 and 6to4 addresses are checked as well.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	var bytes = addr.As16()
+	switch {
+	case nat64Network.Contains(addr):
+		addr = netip.AddrFrom4([4]byte(bytes[12:16]))
+	case sixToFour.Contains(addr):
+		addr = netip.AddrFrom4([4]byte(bytes[2:6]))
+	}
+	if !addr.IsValid() {
+		return false
+	}
+	for _, network := range specialNetworks {
+		if network.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,212 @@
+package gemax
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// DefaultCGITimeout is the default time limit of a single CGI script execution.
+const DefaultCGITimeout = 10 * time.Second
+
+// DefaultCGIPath is the PATH variable of CGI scripts, so "#!/usr/bin/env" interpreters can be found.
+const DefaultCGIPath = "/usr/local/bin:/usr/bin:/bin"
+
+// CGI runs an executable for each request following the de-facto Gemini CGI conventions.
+// The script gets request data via environment variables (GEMINI_URL, PATH_INFO,
+// QUERY_STRING, REMOTE_ADDR, TLS_CLIENT_HASH, etc.) and writes a complete gemini response,
+// header included, to the stdout. Titan upload bodies are passed to the stdin.
+//
+// Crashed scripts, timeouts and malformed response headers are reported as status.CGIError.
+type CGI struct {
+	// Path to the executable.
+	Path string
+	// Optional command line arguments.
+	Args []string
+	// Working directory of the script. If empty, then the server working directory is used.
+	Dir string
+	// Additional environment variables in form of "KEY=value".
+	// The server environment is not inherited, PATH is set to DefaultCGIPath unless it's provided here.
+	Env []string
+	// URL path of the script. It's used to compute SCRIPT_NAME and PATH_INFO.
+	// If empty, then the whole request path is passed as PATH_INFO.
+	ScriptName string
+	// Time limit of script execution, including response streaming.
+	//	0 - DefaultCGITimeout
+	//	<0 - no limitation
+	Timeout time.Duration
+	// Optional text logger. Script stderr is logged as well.
+	Logf func(format string, args ...any)
+}
+
+var _ Handler = new(CGI).Serve
+
+// Serve executes the script and streams its response to the client.
+func (cgi *CGI) Serve(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
+	ctx, cancel := backendContext(ctx, cgi.Timeout)
+	defer cancel()
+
+	//nolint:gosec // script path is provided by the server owner
+	var cmd = exec.CommandContext(ctx, cgi.Path, cgi.Args...)
+	cmd.Dir = cgi.Dir
+	cmd.Env = append(cgiEnv(req, cgi.ScriptName), "PATH="+DefaultCGIPath)
+	cmd.Env = append(cmd.Env, cgi.Env...)
+	cmd.WaitDelay = time.Second
+	if body := RequestBody(req); body != nil {
+		cmd.Stdin = body
+	}
+	if cgi.Logf != nil {
+		cmd.Stderr = &cgiStderr{name: cgi.Path, logf: cgi.Logf}
+	}
+	var stdout, errPipe = cmd.StdoutPipe()
+	if errPipe != nil {
+		cgi.logf("ERROR: cgi %s: %v", cgi.Path, errPipe)
+		rw.WriteStatus(status.CGIError, status.CGIError.String())
+		return
+	}
+	if errStart := cmd.Start(); errStart != nil {
+		cgi.logf("ERROR: cgi %s: starting script: %v", cgi.Path, errStart)
+		rw.WriteStatus(status.CGIError, status.CGIError.String())
+		return
+	}
+
+	var errServe = serveCGIResponse(rw, bufio.NewReader(stdout))
+	if errServe != nil {
+		_ = cmd.Process.Kill()
+	}
+	var errWait = cmd.Wait()
+	switch {
+	case errServe != nil:
+		cgi.logf("ERROR: cgi %s: %v (%v)", cgi.Path, errServe, errWait)
+	case errWait != nil:
+		cgi.logf("ERROR: cgi %s: %v", cgi.Path, errWait)
+	}
+}
+
+func (cgi *CGI) logf(format string, args ...any) {
+	if cgi.Logf != nil {
+		cgi.Logf(format, args...)
+	}
+}
+
+// ErrInvalidCGIResponse means that a CGI process produced a malformed response.
+var ErrInvalidCGIResponse = errors.New("invalid CGI response")
+
+// serveCGIResponse parses a gemini response produced by a CGI-like process and streams it to rw.
+// If the response header is malformed, then status.CGIError is written.
+func serveCGIResponse(rw ResponseWriter, re *bufio.Reader) error {
+	var code, meta, errHeader = ParseResponseHeader(re)
+	if errHeader == nil && !validStatus(code) {
+		errHeader = fmt.Errorf("unexpected status code %d", code)
+	}
+	if errHeader != nil {
+		rw.WriteStatus(status.CGIError, status.CGIError.String())
+		return fmt.Errorf("%w: %w", ErrInvalidCGIResponse, errHeader)
+	}
+	rw.WriteStatus(code, meta)
+	if code != status.Success {
+		_, _ = io.Copy(io.Discard, re)
+		return nil
+	}
+	if _, errCopy := io.Copy(rw, re); errCopy != nil {
+		return fmt.Errorf("streaming response: %w", errCopy)
+	}
+	return nil
+}
+
+func validStatus(code status.Code) bool {
+	return code >= 10 && code <= 69
+}
+
+// cgiEnv returns CGI meta-variables for the request in form of "KEY=value".
+// It's shared by CGI, SCGI and FastCGI handlers.
+func cgiEnv(req IncomingRequest, scriptName string) []string {
+	var u = req.URL()
+	var port = u.Port()
+	if port == "" {
+		port = "1965"
+	}
+	scriptName = strings.TrimSuffix(scriptName, "/")
+	// script name must match whole path segments: "/cgi" is not a prefix of "/cgi-bin/x"
+	var pathInfo = u.Path
+	if rest, ok := strings.CutPrefix(u.Path, scriptName); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+		pathInfo = rest
+	}
+	var remoteHost, remotePort, errSplit = net.SplitHostPort(req.RemoteAddr())
+	if errSplit != nil {
+		remoteHost, remotePort = req.RemoteAddr(), ""
+	}
+
+	var env = []string{
+		"GATEWAY_INTERFACE=CGI/1.1",
+		"SERVER_PROTOCOL=GEMINI",
+		"SERVER_SOFTWARE=gemax",
+		"GEMINI_URL=" + u.String(),
+		"GEMINI_URL_PATH=" + u.Path,
+		"SCRIPT_NAME=" + scriptName,
+		"PATH_INFO=" + pathInfo,
+		"QUERY_STRING=" + u.RawQuery,
+		"SERVER_NAME=" + u.Hostname(),
+		"SERVER_PORT=" + port,
+		"REMOTE_ADDR=" + remoteHost,
+		"REMOTE_HOST=" + remoteHost,
+		"REMOTE_PORT=" + remotePort,
+	}
+	if titan, ok := req.(TitanRequest); ok {
+		var params = titan.TitanParams()
+		env = append(env,
+			"CONTENT_TYPE="+params.MIME,
+			"CONTENT_LENGTH="+strconv.FormatInt(params.Size, 10),
+			"TITAN_TOKEN="+params.Token,
+		)
+	}
+	if certs := req.Certificates(); len(certs) > 0 {
+		var cert = certs[0]
+		env = append(env,
+			"AUTH_TYPE=Certificate",
+			"REMOTE_USER="+cert.Subject.CommonName,
+			"TLS_CLIENT_HASH=SHA256:"+CertificateFingerprint(cert),
+			"TLS_CLIENT_SUBJECT="+cert.Subject.String(),
+			"TLS_CLIENT_NOT_BEFORE="+cert.NotBefore.UTC().Format(time.RFC3339),
+			"TLS_CLIENT_NOT_AFTER="+cert.NotAfter.UTC().Format(time.RFC3339),
+		)
+	}
+	return env
+}
+
+// CertificateFingerprint returns hex encoded SHA-256 hash of the certificate.
+func CertificateFingerprint(cert *x509.Certificate) string {
+	var sum = sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// cgiStderr logs script stderr output.
+type cgiStderr struct {
+	name string
+	logf func(format string, args ...any)
+}
+
+func (stderr *cgiStderr) Write(data []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		stderr.logf("WARN: cgi %s: stderr: %s", stderr.name, line)
+	}
+	return len(data), nil
+}
+
+// isExecutable reports whether the file is a regular file executable by someone.
+func isExecutable(info os.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0
+}
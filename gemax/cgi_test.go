@@ -0,0 +1,174 @@
+package gemax_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/spartan"
+	"github.com/ninedraft/gemax/gemax/status"
+
+	"github.com/ninedraft/gemax/vend/tailscale.com/net/memnet"
+)
+
+func TestCGI(test *testing.T) {
+	if runtime.GOOS == "windows" {
+		test.Skip("CGI tests require a POSIX shell")
+	}
+	var dir = test.TempDir()
+	var tc = func(name, script string, timeout time.Duration, expected status.Code, expectedBody string) {
+		test.Run(name, func(test *testing.T) {
+			var cgi = &gemax.CGI{
+				Path:       writeScript(test, dir, name, script),
+				ScriptName: "/cgi-bin/script",
+				Timeout:    timeout,
+				Logf:       test.Logf,
+			}
+			var rw = &responseRecorder{}
+			var req = &request{
+				remoteAddr: "192.0.2.1:4000",
+				url:        "gemini://example.com/cgi-bin/script/extra/path?query",
+			}
+
+			cgi.Serve(context.Background(), rw, req)
+
+			if rw.status != expected {
+				test.Fatalf("expected %s, got %s %q", expected, rw.status, rw.meta)
+			}
+			if rw.String() != expectedBody {
+				test.Fatalf("expected body %q, got %q", expectedBody, rw.String())
+			}
+		})
+	}
+
+	tc("env", `printf '20 text/plain\r\n%s|%s|%s|%s|%s' "$SERVER_PROTOCOL" "$GEMINI_URL" "$PATH_INFO" "$QUERY_STRING" "$REMOTE_ADDR"`,
+		0, status.Success,
+		"GEMINI|gemini://example.com/cgi-bin/script/extra/path?query|/extra/path|query|192.0.2.1")
+	tc("path", `printf '20 text/plain\r\n%s' "$PATH"`, 0, status.Success, gemax.DefaultCGIPath)
+	tc("input", `printf '10 name?\r\n'`, 0, status.Input, "")
+	tc("malformed header", `echo hello`, 0, status.CGIError, "")
+	tc("crash", `exit 1`, 0, status.CGIError, "")
+	tc("timeout", `sleep 10`, 100*time.Millisecond, status.CGIError, "")
+}
+
+func TestCGI_PathInfo(test *testing.T) {
+	if runtime.GOOS == "windows" {
+		test.Skip("CGI tests require a POSIX shell")
+	}
+	var script = writeScript(test, test.TempDir(), "script", `printf '20 text/plain\r\n%s|%s' "$SCRIPT_NAME" "$PATH_INFO"`)
+	var tc = func(scriptName, path, expected string) {
+		test.Run(scriptName+" "+path, func(test *testing.T) {
+			var cgi = &gemax.CGI{Path: script, ScriptName: scriptName, Logf: test.Logf}
+			var rw = &responseRecorder{}
+			cgi.Serve(context.Background(), rw, &request{url: "gemini://example.com" + path})
+			if rw.String() != expected {
+				test.Fatalf("expected %q, got %q", expected, rw.String())
+			}
+		})
+	}
+	tc("", "/a/b", "|/a/b")
+	tc("/cgi", "/cgi", "/cgi|")
+	tc("/cgi", "/cgi/x/y", "/cgi|/x/y")
+	tc("/cgi/", "/cgi/x", "/cgi|/x")
+	tc("/cgi", "/cgi-bin/x", "/cgi|/cgi-bin/x")
+}
+
+func TestFS_CGI(test *testing.T) {
+	if runtime.GOOS == "windows" {
+		test.Skip("CGI tests require a POSIX shell")
+	}
+	var root = test.TempDir()
+	var dir = filepath.Join(root, "cgi")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		test.Fatal(err)
+	}
+	writeScript(test, dir, "hello", `printf '20 text/gemini\r\n%s %s' "$SCRIPT_NAME" "$PATH_INFO"`)
+	writeScript(test, root, "evil", `printf '20 text/gemini\r\npwned'`)
+	var fileSystem = &gemax.FileSystem{
+		FS:        os.DirFS(test.TempDir()),
+		CGIPrefix: "cgi-bin",
+		CGIDir:    dir,
+		Logf:      test.Logf,
+	}
+
+	var tc = func(path string, expected status.Code, expectedBody string) {
+		test.Run(path, func(test *testing.T) {
+			var rw = &responseRecorder{}
+			var req = &request{
+				remoteAddr: test.Name(),
+				url:        "gemini://example.com" + path,
+			}
+
+			fileSystem.Serve(context.Background(), rw, req)
+
+			if rw.status != expected {
+				test.Fatalf("expected %s, got %s %q", expected, rw.status, rw.meta)
+			}
+			if rw.String() != expectedBody {
+				test.Fatalf("expected body %q, got %q", expectedBody, rw.String())
+			}
+		})
+	}
+
+	tc("/cgi-bin/hello", status.Success, "/cgi-bin/hello ")
+	tc("/cgi-bin/hello/a/b", status.Success, "/cgi-bin/hello /a/b")
+	tc("/cgi-bin/missing", status.NotFound, "")
+	tc("/cgi-bin", status.NotFound, "")
+	tc("/cgi-bin/../evil", status.NotFound, "")
+	tc("/cgi-bin/./../evil", status.NotFound, "")
+	tc("/cgi-bin/..%5Cevil", status.NotFound, "")
+}
+
+func TestFS_CGI_Traversal(test *testing.T) {
+	if runtime.GOOS == "windows" {
+		test.Skip("CGI tests require a POSIX shell")
+	}
+	var root = test.TempDir()
+	var dir = filepath.Join(root, "cgi")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		test.Fatal(err)
+	}
+	writeScript(test, root, "evil.sh", `printf '20 text/gemini\r\npwned'`)
+	var fileSystem = &gemax.FileSystem{
+		FS:        os.DirFS(dir),
+		CGIPrefix: "cgi-bin",
+		CGIDir:    dir,
+		Logf:      test.Logf,
+	}
+
+	// spartan front end doesn't share gemini request validation
+	var server = &spartan.Server{Handler: fileSystem.Serve, Logf: test.Logf}
+	var listener = memnet.Listen(test.Name())
+	var ctx = test.Context()
+	runTask(test, func() {
+		_ = server.Serve(ctx, listener)
+	})
+	test.Cleanup(func() { _ = listener.Close() })
+
+	var conn, errDial = listener.Dial(ctx, "tcp", test.Name())
+	if errDial != nil {
+		test.Fatal(errDial)
+	}
+	defer func() { _ = conn.Close() }()
+	_, _ = io.WriteString(conn, "example.com /cgi-bin/../evil.sh 0\r\n")
+	var resp, _ = io.ReadAll(conn)
+	if strings.Contains(string(resp), "pwned") || !strings.HasPrefix(string(resp), "4 ") {
+		test.Fatalf("expected rejected request, got %q", resp)
+	}
+}
+
+func writeScript(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	var p = filepath.Join(dir, strings.ReplaceAll(name, " ", "_"))
+	var errWrite = os.WriteFile(p, []byte("#!/bin/sh\n"+script+"\n"), 0o755)
+	if errWrite != nil {
+		t.Fatal(errWrite)
+	}
+	return p
+}
@@ -11,6 +11,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ninedraft/gemax/gemax/internal/bufreader"
 	"github.com/ninedraft/gemax/gemax/status"
@@ -25,18 +26,51 @@ type Client struct {
 	// If CheckRedirect is not nil, the client calls it before
 	// following an Gemini redirect. The arguments req and via are
 	// the upcoming request and the requests made already, oldest
-	// first. If CheckRedirect returns an error, the Client's Fetch
-	// method returns both the previous Response (with its Body
-	// closed) and CheckRedirect's error.
+	// first. Redirect targets are resolved against the previous request URL,
+	// so req is always an absolute URL.
+	// CheckRedirect is also called for the initial request with empty via.
+	// If CheckRedirect returns an error, the Client's Fetch
+	// method closes the previous Response and returns CheckRedirect's error
 	// instead of issuing the Request req.
 	// As a special case, if CheckRedirect returns ErrUseLastResponse,
 	// then the most recent response is returned with its body
-	// unclosed, along with a nil error.
+	// unclosed, along with a nil error. For the initial request there is
+	// no response yet, so ErrUseLastResponse is ignored and the request is sent.
+	//
+	// Non-gemini URLs, including the initial one, are rejected with *UnsupportedSchemeError
+	// before CheckRedirect is called, unless they are routed through Proxy.
 	//
 	// If CheckRedirect is nil, the Client uses its default policy,
 	// which is to stop after 10 consecutive requests.
+	// See SameHostRedirect for a stricter policy.
 	CheckRedirect func(ctx context.Context, verification *urlpkg.URL, via []RedirectedRequest) error
-	once          sync.Once
+	// SlowDown specifies the policy for handling status.SlowDown responses.
+	// If SlowDown is not nil, then all following requests to the same host are postponed
+	// for the delay requested by the server, and the request is retried
+	// as long as the policy allows it.
+	// If SlowDown is nil, then SLOW DOWN responses are returned as is.
+	SlowDown *SlowDownPolicy
+	// HostInterval is the minimum interval between requests to the same host.
+	// It's shared by all goroutines using the Client.
+	//	0 - no limitation
+	HostInterval time.Duration
+	// MaxHostConnections is the maximum number of simultaneous requests to the same host.
+	// Connection slot is held until the response is closed.
+	//	<=0 - no limitation
+	MaxHostConnections int
+	// Proxy specifies a function to return a gemini proxy server for a given request URL.
+	// If the function returns a non-nil URL, then the client connects to the proxy host
+	// and sends the full original URL as the request line.
+	// It allows to fetch non-gemini URLs (gopher, http, etc.) through gateway capsules.
+	// Per-host limits are applied to the proxy host.
+	// If Proxy is nil or returns a nil URL, then no proxy is used.
+	// See ProxyURL for a fixed proxy.
+	Proxy func(*urlpkg.URL) (*urlpkg.URL, error)
+
+	once       sync.Once
+	hostsMu    sync.Mutex
+	hosts      map[string]*hostLimiter
+	hostsSweep int // number of host limiters, which triggers removal of idle ones
 }
 
 var (
@@ -62,59 +96,137 @@ func defaultRedirect(_ context.Context, _ *urlpkg.URL, via []RedirectedRequest)
 }
 
 // RedirectedRequest  contains executed gemini request data
-// and corresponding response with closed body.
+// and corresponding response. Response body is closed
+// after the redirect policy is consulted.
 type RedirectedRequest struct {
 	Req      *urlpkg.URL
 	Response *Response
+	// Permanent is true for status.RedirectPermanent responses
+	// and false for temporary ones.
+	Permanent bool
 }
 
 const readerBufSize = 16 << 10
 
 // Fetch gemini resource.
+// Non-gemini URLs can be fetched only through Proxy,
+// otherwise Fetch returns *UnsupportedSchemeError without sending any request.
+// Relative redirect targets are resolved against the previous request URL.
 func (client *Client) Fetch(ctx context.Context, url string) (*Response, error) {
 	client.init()
+	var u, errParseURL = urlpkg.Parse(url)
+	if errParseURL != nil {
+		return nil, fmt.Errorf("parsing URL: %w", errParseURL)
+	}
 	//nolint:prealloc // unable to preallocate, we don't know number of redirects
 	var redirects []RedirectedRequest
 	for {
-		var u, errParseURL = urlpkg.Parse(url)
-		if errParseURL != nil {
-			return nil, fmt.Errorf("parsing URL: %w", errParseURL)
+		var server, last, errPrepare = client.prepareRequest(ctx, u, redirects)
+		if last != nil || errPrepare != nil {
+			return last, errPrepare
 		}
-		if err := client.checkRedirect(ctx, u, redirects); err != nil {
-			return nil, fmt.Errorf("redirect: %w", err)
-		}
-		resp, errFetch := client.fetch(ctx, url, u)
+		resp, errFetch := client.fetchPolitely(ctx, url, server)
 		if errFetch != nil {
 			return resp, errFetch
 		}
 		if !isRedirect(resp.Status) {
 			return resp, nil
 		}
-		_ = resp.Close()
+		var next, errNext = resolveRedirect(u, resp.Meta)
+		if errNext != nil {
+			_ = resp.Close()
+			return nil, fmt.Errorf("redirect: %w", errNext)
+		}
 		redirects = append(redirects, RedirectedRequest{
-			Req:      u,
-			Response: resp,
+			Req:       u,
+			Response:  resp,
+			Permanent: resp.Status == status.RedirectPermanent,
 		})
-		url = resp.Meta
+		u, url = next, next.String()
 	}
 }
 
+// prepareRequest routes the request and consults the redirect policy.
+// It returns either the server to send the request to, or the last response to return.
+// Response of the previous redirect is closed, unless it's returned as the last response.
+func (client *Client) prepareRequest(ctx context.Context, u *urlpkg.URL, redirects []RedirectedRequest) (*urlpkg.URL, *Response, error) {
+	var server, errRoute = client.route(u)
+	if errRoute != nil {
+		if len(redirects) == 0 {
+			return nil, nil, errRoute
+		}
+		_ = redirects[len(redirects)-1].Response.Close()
+		return nil, nil, fmt.Errorf("redirect: %w", errRoute)
+	}
+	var errCheck = client.checkRedirect(ctx, u, redirects)
+	if len(redirects) > 0 {
+		var prev = redirects[len(redirects)-1].Response
+		if errors.Is(errCheck, ErrUseLastResponse) {
+			return nil, prev, nil
+		}
+		_ = prev.Close()
+	}
+	if errCheck != nil && !errors.Is(errCheck, ErrUseLastResponse) {
+		return nil, nil, fmt.Errorf("redirect: %w", errCheck)
+	}
+	return server, nil, nil
+}
+
 func isRedirect(code status.Code) bool {
 	return code == status.Redirect || code == status.RedirectPermanent
 }
 
-func (client *Client) fetch(ctx context.Context, origURL string, u *urlpkg.URL) (*Response, error) {
+// fetchPolitely executes request respecting the per-host limits and SLOW DOWN policy.
+func (client *Client) fetchPolitely(ctx context.Context, origURL string, server *urlpkg.URL) (*Response, error) {
+	if !client.politeness() {
+		return client.fetch(ctx, origURL, server)
+	}
+	var host = hostWithPort(server)
+	for attempt := 0; ; attempt++ {
+		var limiter, errAcquire = client.acquireHost(ctx, host)
+		if errAcquire != nil {
+			return nil, fmt.Errorf("waiting for host %q: %w", host, errAcquire)
+		}
+		var resp, errFetch = client.fetch(ctx, origURL, server)
+		if errFetch != nil {
+			client.releaseHost(host, limiter, true)
+			return resp, errFetch
+		}
+		resp.reader = &releaseReader{
+			reader:  resp.reader,
+			release: func() { client.releaseHost(host, limiter, true) },
+		}
+		if resp.Status != status.SlowDown || client.SlowDown == nil {
+			return resp, nil
+		}
+		// following requests to the host are postponed, even if this one is not retried
+		var delay = client.SlowDown.delay(resp.Meta)
+		limiter.backoff(delay)
+		if !client.SlowDown.retry(attempt, delay) {
+			return resp, nil
+		}
+		_ = resp.Close()
+	}
+}
+
+func hostWithPort(u *urlpkg.URL) string {
 	var host = u.Host
 	if strings.LastIndexByte(host, ':') < 0 {
 		host += ":1965"
 	}
+	return host
+}
+
+// fetch sends request origURL to the server and parses response header.
+func (client *Client) fetch(ctx context.Context, origURL string, server *urlpkg.URL) (*Response, error) {
+	var host = hostWithPort(server)
 	var domain, _, _ = net.SplitHostPort(host)
 	var conn, errConn = client.dial(ctx, host, &tls.Config{
 		MinVersion: tls.VersionTLS12,
 		//nolint:gosec // we skipping certificate verification because gemini servers usually don't use CAs
 		InsecureSkipVerify: true,
 		VerifyConnection: func(cs tls.ConnectionState) error {
-			return tlsVerifyDomain(&cs, domain)
+			return VerifyDomain(&cs, domain)
 		},
 	})
 	if errConn != nil {
@@ -128,13 +240,19 @@ func (client *Client) fetch(ctx context.Context, origURL string, u *urlpkg.URL)
 	}()
 	ctxConnDeadline(ctx, conn)
 
+	var trace = ContextClientTrace(ctx)
 	var _, errWrite = io.WriteString(conn, origURL+"\r\n")
+	trace.wroteRequest(errWrite)
 	if errWrite != nil {
 		return nil, fmt.Errorf("sending request: %w", errWrite)
 	}
 
+	if trace != nil && trace.GotFirstResponseByte != nil {
+		conn = &firstByteReader{Conn: conn, trace: trace}
+	}
 	var re = bufreader.New(conn, readerBufSize)
 	var code, meta, errHeader = ParseResponseHeader(re)
+	trace.gotHeader(code, meta, errHeader)
 	if errHeader != nil {
 		return nil, errHeader
 	}
@@ -151,18 +269,68 @@ func (client *Client) fetch(ctx context.Context, origURL string, u *urlpkg.URL)
 }
 
 func (client *Client) dial(ctx context.Context, host string, cfg *tls.Config) (net.Conn, error) {
+	var trace = ContextClientTrace(ctx)
 	if client.Dial != nil {
-		return client.Dial(ctx, host, cfg)
+		trace.connectStart("tcp", host)
+		var conn, errDial = client.Dial(ctx, host, cfg)
+		trace.connectDone("tcp", host, errDial)
+		if tlsConn, ok := conn.(*tls.Conn); ok && errDial == nil {
+			trace.tlsHandshakeDone(tlsConn.ConnectionState(), nil)
+		}
+		return conn, errDial
 	}
-	var tlsDialer = &tls.Dialer{
-		NetDialer: &net.Dialer{},
-		Config:    cfg,
+
+	var hostname, _, errSplit = net.SplitHostPort(host)
+	if errSplit != nil {
+		return nil, errSplit
 	}
-	return tlsDialer.DialContext(ctx, "tcp", host)
+	var conn, errDial = dialTCP(ctx, trace, hostname, host)
+	if errDial != nil {
+		return nil, errDial
+	}
+	// the same as tls.Dialer does, but with trace hooks
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = hostname
+	}
+	var tlsConn = tls.Client(conn, cfg)
+	trace.tlsHandshakeStart()
+	var errHandshake = tlsConn.HandshakeContext(ctx)
+	trace.tlsHandshakeDone(tlsConn.ConnectionState(), errHandshake)
+	if errHandshake != nil {
+		_ = conn.Close()
+		return nil, errHandshake
+	}
+	return tlsConn, nil
+}
+
+// dialTCP connects to the host, DNS and connect trace hooks are called by the dialer.
+func dialTCP(ctx context.Context, trace *ClientTrace, hostname, host string) (net.Conn, error) {
+	var dt = &dialTrace{trace: trace, resolve: net.ParseIP(hostname) == nil}
+	var dialer = &net.Dialer{}
+	if trace != nil {
+		dialer.ControlContext = dt.control
+	}
+	if dt.resolve {
+		trace.dnsStart(hostname)
+	}
+	var conn, errDial = dialer.DialContext(ctx, "tcp", host)
+	if errDial != nil {
+		var errDNS *net.DNSError
+		if errors.As(errDial, &errDNS) {
+			dt.resolved(errDial)
+		}
+		trace.connectDone("tcp", host, errDial)
+		return nil, errDial
+	}
+	trace.connectDone("tcp", conn.RemoteAddr().String(), nil)
+	return conn, nil
 }
 
 func (client *Client) init() {
-	client.once.Do(func() {})
+	client.once.Do(func() {
+		client.hosts = map[string]*hostLimiter{}
+	})
 }
 
 // Response contains parsed server response.
@@ -0,0 +1,32 @@
+package gemax
+
+import (
+	"fmt"
+	urlpkg "net/url"
+)
+
+// ProxyURL returns a proxy function (for use in Client.Proxy),
+// which always returns the same URL.
+func ProxyURL(fixedURL *urlpkg.URL) func(*urlpkg.URL) (*urlpkg.URL, error) {
+	return func(*urlpkg.URL) (*urlpkg.URL, error) {
+		return fixedURL, nil
+	}
+}
+
+// route returns URL of the server, which must be dialed to fetch provided URL.
+// It's either the URL itself or a proxy server URL.
+func (client *Client) route(u *urlpkg.URL) (*urlpkg.URL, error) {
+	if client.Proxy != nil {
+		var proxy, errProxy = client.Proxy(u)
+		if errProxy != nil {
+			return nil, fmt.Errorf("proxy: %w", errProxy)
+		}
+		if proxy != nil {
+			return proxy, nil
+		}
+	}
+	if u.Scheme != "gemini" {
+		return nil, &UnsupportedSchemeError{URL: u}
+	}
+	return u, nil
+}
@@ -0,0 +1,54 @@
+package gemax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	urlpkg "net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUseLastResponse can be returned by Client.CheckRedirect hooks to control how redirects are processed.
+	// If returned, the next request is not sent and the most recent response is returned with its body unclosed.
+	ErrUseLastResponse = errors.New("use last response")
+
+	// ErrCrossHostRedirect means that server tried to redirect client to another host.
+	// It's returned by SameHostRedirect policy.
+	ErrCrossHostRedirect = errors.New("cross-host redirect")
+)
+
+// UnsupportedSchemeError means that client can't fetch URL with provided scheme.
+// By default Client doesn't follow redirects to non-gemini URLs.
+type UnsupportedSchemeError struct {
+	URL *urlpkg.URL
+}
+
+func (err *UnsupportedSchemeError) Error() string {
+	return "unsupported scheme " + strconv.Quote(err.URL.Scheme) + ": " + err.URL.String()
+}
+
+// SameHostRedirect is a redirect policy, which forbids redirects to hosts
+// other than the host of the original request.
+// It also limits the number of redirects in the same way as the default policy.
+// Can be used as Client.CheckRedirect.
+func SameHostRedirect(ctx context.Context, req *urlpkg.URL, via []RedirectedRequest) error {
+	if len(via) > 0 && hostWithPort(req) != hostWithPort(via[0].Req) {
+		return fmt.Errorf("%w: %q -> %q", ErrCrossHostRedirect, via[0].Req.Host, req.Host)
+	}
+	return defaultRedirect(ctx, req, via)
+}
+
+// resolveRedirect resolves redirect target from response meta against the request URL.
+func resolveRedirect(base *urlpkg.URL, meta string) (*urlpkg.URL, error) {
+	meta = strings.TrimSpace(meta)
+	if meta == "" {
+		return nil, fmt.Errorf("%w: empty redirect target", ErrInvalidResponse)
+	}
+	var target, errParse = urlpkg.Parse(meta)
+	if errParse != nil {
+		return nil, fmt.Errorf("%w: parsing redirect target: %w", ErrInvalidResponse, errParse)
+	}
+	return base.ResolveReference(target), nil
+}
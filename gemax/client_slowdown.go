@@ -0,0 +1,192 @@
+package gemax
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSlowDownDelay is used if SLOW DOWN response meta can't be parsed.
+const DefaultSlowDownDelay = time.Second
+
+// SlowDownPolicy describes how Client handles status.SlowDown responses.
+type SlowDownPolicy struct {
+	// MaxRetries is the maximum number of retries of a single request.
+	// If the limit is exceeded, then the last SLOW DOWN response is returned.
+	MaxRetries int
+	// MaxDelay limits the wait time requested by the server.
+	// If the server asks to wait longer, then the SLOW DOWN response is returned.
+	//	0 - no limitation
+	MaxDelay time.Duration
+	// DefaultDelay is used if the response meta is not a valid number of seconds.
+	//	0 - DefaultSlowDownDelay
+	DefaultDelay time.Duration
+}
+
+func (policy *SlowDownPolicy) delay(meta string) time.Duration {
+	var delay, errDelay = SlowDownDelay(meta)
+	switch {
+	case errDelay == nil:
+		return delay
+	case policy.DefaultDelay > 0:
+		return policy.DefaultDelay
+	default:
+		return DefaultSlowDownDelay
+	}
+}
+
+func (policy *SlowDownPolicy) retry(attempt int, delay time.Duration) bool {
+	if attempt >= policy.MaxRetries {
+		return false
+	}
+	return policy.MaxDelay <= 0 || delay <= policy.MaxDelay
+}
+
+// SlowDownDelay parses the wait time from status.SlowDown response meta.
+// Meta is expected to be a non-negative integer number of seconds.
+func SlowDownDelay(meta string) (time.Duration, error) {
+	var seconds, errParse = strconv.Atoi(strings.TrimSpace(meta))
+	if errParse != nil {
+		return 0, fmt.Errorf("%w: parsing slow down delay: %w", ErrInvalidResponse, errParse)
+	}
+	if seconds < 0 {
+		return 0, fmt.Errorf("%w: negative slow down delay %d", ErrInvalidResponse, seconds)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// minHostsSweep is the minimal number of host limiters, which triggers removal of idle ones.
+const minHostsSweep = 16
+
+// hostLimiter enforces per-host politeness rules shared by all requests of a Client.
+type hostLimiter struct {
+	refs  int
+	slots chan struct{}
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (client *Client) politeness() bool {
+	return client.HostInterval > 0 || client.MaxHostConnections > 0 || client.SlowDown != nil
+}
+
+func (client *Client) acquireHost(ctx context.Context, host string) (*hostLimiter, error) {
+	client.hostsMu.Lock()
+	var limiter = client.hosts[host]
+	if limiter == nil {
+		client.sweepHosts()
+		limiter = &hostLimiter{}
+		if client.MaxHostConnections > 0 {
+			limiter.slots = make(chan struct{}, client.MaxHostConnections)
+		}
+		client.hosts[host] = limiter
+	}
+	limiter.refs++
+	client.hostsMu.Unlock()
+
+	if err := limiter.acquire(ctx, client.HostInterval); err != nil {
+		client.releaseHost(host, limiter, false)
+		return nil, err
+	}
+	return limiter, nil
+}
+
+func (client *Client) releaseHost(host string, limiter *hostLimiter, acquired bool) {
+	if acquired && limiter.slots != nil {
+		<-limiter.slots
+	}
+	client.hostsMu.Lock()
+	defer client.hostsMu.Unlock()
+	limiter.refs--
+	if limiter.refs == 0 && !limiter.waiting() {
+		delete(client.hosts, host)
+	}
+}
+
+// sweepHosts removes limiters of idle hosts, which were kept after release to enforce a delay.
+// The sweep runs once the number of limiters doubles, so its cost is amortized over acquisitions.
+// It must be called with hostsMu held.
+func (client *Client) sweepHosts() {
+	if len(client.hosts) < client.hostsSweep {
+		return
+	}
+	for host, limiter := range client.hosts {
+		if limiter.refs == 0 && !limiter.waiting() {
+			delete(client.hosts, host)
+		}
+	}
+	client.hostsSweep = max(2*len(client.hosts), minHostsSweep)
+}
+
+func (limiter *hostLimiter) acquire(ctx context.Context, interval time.Duration) error {
+	if limiter.slots != nil {
+		select {
+		case limiter.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	limiter.mu.Lock()
+	var at = time.Now()
+	if limiter.next.After(at) {
+		at = limiter.next
+	}
+	limiter.next = at.Add(interval)
+	limiter.mu.Unlock()
+
+	if err := sleepContext(ctx, time.Until(at)); err != nil {
+		if limiter.slots != nil {
+			<-limiter.slots
+		}
+		return err
+	}
+	return nil
+}
+
+// backoff postpones all following requests to the host.
+func (limiter *hostLimiter) backoff(delay time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	var at = time.Now().Add(delay)
+	if at.After(limiter.next) {
+		limiter.next = at
+	}
+}
+
+func (limiter *hostLimiter) waiting() bool {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return limiter.next.After(time.Now())
+}
+
+// releaseReader releases the host slot after the response is closed.
+type releaseReader struct {
+	reader
+	once    sync.Once
+	release func()
+}
+
+func (re *releaseReader) Close() error {
+	var errClose = re.reader.Close()
+	re.once.Do(re.release)
+	return errClose
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	var timer = time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -5,9 +5,14 @@ import (
 	"crypto/tls"
 	"embed"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	urlpkg "net/url"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -132,6 +137,7 @@ type recordingConn struct {
 	reader     io.Reader
 	writeErr   error
 	closeCalls int
+	onWrite    func(data []byte)
 }
 
 func (conn *recordingConn) Read(data []byte) (int, error) {
@@ -145,6 +151,9 @@ func (conn *recordingConn) Write(data []byte) (int, error) {
 	if conn.writeErr != nil {
 		return 0, conn.writeErr
 	}
+	if conn.onWrite != nil {
+		conn.onWrite(data)
+	}
 	return len(data), nil
 }
 
@@ -172,3 +181,426 @@ func (conn *recordingConn) SetReadDeadline(time.Time) error {
 func (conn *recordingConn) SetWriteDeadline(time.Time) error {
 	return nil
 }
+
+func TestClient_SlowDown_Retry(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{
+			"44 0\r\n",
+			"44 0\r\n",
+			"20 text/gemini\r\nok",
+		},
+	}
+	var client = &gemax.Client{
+		Dial:     dialer.Dial,
+		SlowDown: &gemax.SlowDownPolicy{MaxRetries: 2},
+	}
+	var resp, errFetch = client.Fetch(context.Background(), "gemini://example.com")
+	if errFetch != nil {
+		test.Fatalf("unexpected fetch error: %v", errFetch)
+	}
+	defer func() { _ = resp.Close() }()
+	if resp.Status != status.Success {
+		test.Fatalf("unexpected status code %v", resp.Status)
+	}
+	if dialer.calls.Load() != 3 {
+		test.Fatalf("expected 3 requests, got %d", dialer.calls.Load())
+	}
+}
+
+func TestClient_SlowDown_RetriesExceeded(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{
+			"44 0\r\n",
+			"44 0\r\n",
+			"20 text/gemini\r\nok",
+		},
+	}
+	var client = &gemax.Client{
+		Dial:     dialer.Dial,
+		SlowDown: &gemax.SlowDownPolicy{MaxRetries: 1},
+	}
+	var resp, errFetch = client.Fetch(context.Background(), "gemini://example.com")
+	if errFetch != nil {
+		test.Fatalf("unexpected fetch error: %v", errFetch)
+	}
+	defer func() { _ = resp.Close() }()
+	if resp.Status != status.SlowDown {
+		test.Fatalf("expected %s, got %s", status.SlowDown, resp.Status)
+	}
+}
+
+func TestClient_SlowDown_RespectsContext(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{"44 60\r\n"},
+	}
+	var client = &gemax.Client{
+		Dial:     dialer.Dial,
+		SlowDown: &gemax.SlowDownPolicy{MaxRetries: 1},
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var _, errFetch = client.Fetch(ctx, "gemini://example.com")
+	if !errors.Is(errFetch, context.DeadlineExceeded) {
+		test.Fatalf("expected %v, got %v", context.DeadlineExceeded, errFetch)
+	}
+}
+
+func TestClient_SlowDown_PostponesWithoutRetries(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{
+			"44 60\r\n",
+			"20 text/gemini\r\nok",
+		},
+	}
+	var client = &gemax.Client{
+		Dial:     dialer.Dial,
+		SlowDown: &gemax.SlowDownPolicy{},
+	}
+	var resp, errFetch = client.Fetch(context.Background(), "gemini://example.com")
+	if errFetch != nil {
+		test.Fatalf("unexpected fetch error: %v", errFetch)
+	}
+	_ = resp.Close()
+	if resp.Status != status.SlowDown {
+		test.Fatalf("expected %s, got %s", status.SlowDown, resp.Status)
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, errFetch = client.Fetch(ctx, "gemini://example.com/other")
+	if !errors.Is(errFetch, context.DeadlineExceeded) {
+		test.Fatalf("expected the next request to be postponed, got %v", errFetch)
+	}
+	if dialer.calls.Load() != 1 {
+		test.Fatalf("expected 1 request, got %d", dialer.calls.Load())
+	}
+}
+
+func TestClient_HostInterval(test *testing.T) {
+	test.Parallel()
+	const interval = 50 * time.Millisecond
+	var dialer = &scriptedDialer{
+		responses: []string{
+			"20 text/gemini\r\n",
+			"20 text/gemini\r\n",
+		},
+	}
+	var client = &gemax.Client{
+		Dial:         dialer.Dial,
+		HostInterval: interval,
+	}
+	var ctx = context.Background()
+	var start = time.Now()
+	for range 2 {
+		var resp, errFetch = client.Fetch(ctx, "gemini://example.com")
+		if errFetch != nil {
+			test.Fatalf("unexpected fetch error: %v", errFetch)
+		}
+		_ = resp.Close()
+	}
+	if elapsed := time.Since(start); elapsed < interval {
+		test.Fatalf("requests must be separated by %s, got %s", interval, elapsed)
+	}
+}
+
+func TestClient_HostInterval_ForgetsIdleHosts(test *testing.T) {
+	test.Parallel()
+	const interval = 50 * time.Millisecond
+	var dialer = &scriptedDialer{}
+	for range 17 {
+		dialer.responses = append(dialer.responses, "20 text/gemini\r\n")
+	}
+	var client = &gemax.Client{
+		Dial:         dialer.Dial,
+		HostInterval: interval,
+	}
+	var fetch = func(host string) {
+		var resp, errFetch = client.Fetch(context.Background(), "gemini://"+host)
+		if errFetch != nil {
+			test.Fatalf("unexpected fetch error: %v", errFetch)
+		}
+		_ = resp.Close()
+	}
+	for i := range 16 {
+		fetch(fmt.Sprintf("host%d.com", i))
+	}
+	if n := client.HostLimiters(); n != 16 {
+		test.Fatalf("expected 16 host limiters, got %d", n)
+	}
+
+	time.Sleep(2 * interval)
+	fetch("other.com")
+	if n := client.HostLimiters(); n != 1 {
+		test.Fatalf("idle host limiters must be removed, got %d", n)
+	}
+}
+
+func TestClient_MaxHostConnections(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{
+			"20 text/gemini\r\n",
+			"20 text/gemini\r\n",
+		},
+	}
+	var client = &gemax.Client{
+		Dial:               dialer.Dial,
+		MaxHostConnections: 1,
+	}
+	var ctx = context.Background()
+	var resp, errFetch = client.Fetch(ctx, "gemini://example.com")
+	if errFetch != nil {
+		test.Fatalf("unexpected fetch error: %v", errFetch)
+	}
+
+	var ctxBlocked, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	var _, errBlocked = client.Fetch(ctxBlocked, "gemini://example.com")
+	if !errors.Is(errBlocked, context.DeadlineExceeded) {
+		test.Fatalf("second request must wait for the first one, got %v", errBlocked)
+	}
+
+	_ = resp.Close()
+	var next, errNext = client.Fetch(ctx, "gemini://example.com")
+	if errNext != nil {
+		test.Fatalf("unexpected fetch error: %v", errNext)
+	}
+	_ = next.Close()
+}
+
+func TestSlowDownDelay(test *testing.T) {
+	var delay, errDelay = gemax.SlowDownDelay("15")
+	if errDelay != nil {
+		test.Fatalf("unexpected error: %v", errDelay)
+	}
+	if delay != 15*time.Second {
+		test.Fatalf("expected 15s, got %s", delay)
+	}
+	if _, err := gemax.SlowDownDelay("soon"); !errors.Is(err, gemax.ErrInvalidResponse) {
+		test.Fatalf("expected %v, got %v", gemax.ErrInvalidResponse, err)
+	}
+}
+
+// scriptedDialer serves responses in order, one per connection.
+type scriptedDialer struct {
+	mu        sync.Mutex
+	responses []string
+	calls     atomic.Int64
+	requests  []string
+	hosts     []string
+}
+
+func (dialer *scriptedDialer) Dial(_ context.Context, host string, _ *tls.Config) (net.Conn, error) {
+	dialer.mu.Lock()
+	defer dialer.mu.Unlock()
+	dialer.hosts = append(dialer.hosts, host)
+	var i = int(dialer.calls.Add(1)) - 1
+	if i >= len(dialer.responses) {
+		return nil, errors.New("no more scripted responses")
+	}
+	return &recordingConn{
+		reader: strings.NewReader(dialer.responses[i]),
+		onWrite: func(data []byte) {
+			dialer.mu.Lock()
+			defer dialer.mu.Unlock()
+			dialer.requests = append(dialer.requests, string(data))
+		},
+	}, nil
+}
+
+func TestClient_Redirect_Relative(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{
+			"30 ../other\r\n",
+			"31 /root?q=1\r\n",
+			"20 text/gemini\r\nok",
+		},
+	}
+	var via []gemax.RedirectedRequest
+	var client = &gemax.Client{
+		Dial: dialer.Dial,
+		CheckRedirect: func(_ context.Context, _ *urlpkg.URL, redirects []gemax.RedirectedRequest) error {
+			via = redirects
+			return nil
+		},
+	}
+	var resp, errFetch = client.Fetch(context.Background(), "gemini://example.com/a/b/page")
+	if errFetch != nil {
+		test.Fatalf("unexpected fetch error: %v", errFetch)
+	}
+	defer func() { _ = resp.Close() }()
+
+	var expected = []string{
+		"gemini://example.com/a/b/page\r\n",
+		"gemini://example.com/a/other\r\n",
+		"gemini://example.com/root?q=1\r\n",
+	}
+	if !slices.Equal(dialer.requests, expected) {
+		test.Fatalf("expected requests %q, got %q", expected, dialer.requests)
+	}
+	if len(via) != 2 {
+		test.Fatalf("expected 2 redirects, got %d", len(via))
+	}
+	if via[0].Permanent || !via[1].Permanent {
+		test.Fatalf("unexpected redirect kinds: %v, %v", via[0].Permanent, via[1].Permanent)
+	}
+}
+
+func TestClient_Redirect_InitialRequest(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{
+			"30 /other\r\n",
+			"20 text/gemini\r\nok",
+		},
+	}
+	var checked []string
+	var client = &gemax.Client{
+		Dial: dialer.Dial,
+		CheckRedirect: func(_ context.Context, req *urlpkg.URL, via []gemax.RedirectedRequest) error {
+			checked = append(checked, fmt.Sprintf("%s %d", req, len(via)))
+			return nil
+		},
+	}
+	var resp, errFetch = client.Fetch(context.Background(), "gemini://example.com/page")
+	if errFetch != nil {
+		test.Fatalf("unexpected fetch error: %v", errFetch)
+	}
+	defer func() { _ = resp.Close() }()
+
+	var expected = []string{
+		"gemini://example.com/page 0",
+		"gemini://example.com/other 1",
+	}
+	if !slices.Equal(checked, expected) {
+		test.Fatalf("expected checks %q, got %q", expected, checked)
+	}
+
+	var errForbidden = errors.New("forbidden")
+	client = &gemax.Client{
+		Dial: dialer.Dial,
+		CheckRedirect: func(context.Context, *urlpkg.URL, []gemax.RedirectedRequest) error {
+			return errForbidden
+		},
+	}
+	var calls = dialer.calls.Load()
+	if _, errFetch = client.Fetch(context.Background(), "gemini://example.com/page"); !errors.Is(errFetch, errForbidden) {
+		test.Fatalf("expected %v, got %v", errForbidden, errFetch)
+	}
+	if dialer.calls.Load() != calls {
+		test.Fatalf("client must not dial after the initial request is rejected")
+	}
+}
+
+func TestClient_Redirect_UnsupportedScheme(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{"30 https://example.com/\r\n"},
+	}
+	var client = &gemax.Client{
+		Dial: dialer.Dial,
+	}
+	var _, errFetch = client.Fetch(context.Background(), "gemini://example.com/")
+	var errScheme *gemax.UnsupportedSchemeError
+	if !errors.As(errFetch, &errScheme) {
+		test.Fatalf("expected unsupported scheme error, got %v", errFetch)
+	}
+	if errScheme.URL.Scheme != "https" {
+		test.Fatalf("unexpected scheme %q", errScheme.URL.Scheme)
+	}
+}
+
+func TestClient_Redirect_SameHost(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{
+			"30 /local\r\n",
+			"30 gemini://other.com/\r\n",
+		},
+	}
+	var client = &gemax.Client{
+		Dial:          dialer.Dial,
+		CheckRedirect: gemax.SameHostRedirect,
+	}
+	var _, errFetch = client.Fetch(context.Background(), "gemini://example.com/")
+	if !errors.Is(errFetch, gemax.ErrCrossHostRedirect) {
+		test.Fatalf("expected %v, got %v", gemax.ErrCrossHostRedirect, errFetch)
+	}
+	if dialer.calls.Load() != 2 {
+		test.Fatalf("expected 2 requests, got %d", dialer.calls.Load())
+	}
+}
+
+func TestClient_Redirect_UseLastResponse(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{"31 /moved\r\n"},
+	}
+	var client = &gemax.Client{
+		Dial: dialer.Dial,
+		CheckRedirect: func(context.Context, *urlpkg.URL, []gemax.RedirectedRequest) error {
+			return gemax.ErrUseLastResponse
+		},
+	}
+	var resp, errFetch = client.Fetch(context.Background(), "gemini://example.com/")
+	if errFetch != nil {
+		test.Fatalf("unexpected fetch error: %v", errFetch)
+	}
+	defer func() { _ = resp.Close() }()
+	if resp.Status != status.RedirectPermanent || resp.Meta != "/moved" {
+		test.Fatalf("unexpected response %s %q", resp.Status, resp.Meta)
+	}
+}
+
+func TestClient_Proxy(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{"20 text/plain\r\nhello"},
+	}
+	var proxyURL, _ = urlpkg.Parse("gemini://proxy.example.com:1966")
+	var client = &gemax.Client{
+		Dial:  dialer.Dial,
+		Proxy: gemax.ProxyURL(proxyURL),
+	}
+	var resp, errFetch = client.Fetch(context.Background(), "gopher://example.com/1/")
+	if errFetch != nil {
+		test.Fatalf("unexpected fetch error: %v", errFetch)
+	}
+	defer func() { _ = resp.Close() }()
+	expectResponse(test, resp, "hello")
+	if !slices.Equal(dialer.hosts, []string{"proxy.example.com:1966"}) {
+		test.Fatalf("unexpected dialed hosts %q", dialer.hosts)
+	}
+	if !slices.Equal(dialer.requests, []string{"gopher://example.com/1/\r\n"}) {
+		test.Fatalf("unexpected requests %q", dialer.requests)
+	}
+}
+
+func TestClient_UnsupportedScheme(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{}
+	var checked = 0
+	var client = &gemax.Client{
+		Dial: dialer.Dial,
+		CheckRedirect: func(context.Context, *urlpkg.URL, []gemax.RedirectedRequest) error {
+			checked++
+			return nil
+		},
+	}
+	var _, errFetch = client.Fetch(context.Background(), "https://example.com/")
+	var errScheme *gemax.UnsupportedSchemeError
+	if !errors.As(errFetch, &errScheme) {
+		test.Fatalf("expected unsupported scheme error, got %v", errFetch)
+	}
+	if dialer.calls.Load() != 0 {
+		test.Fatalf("client must not dial, got %d calls", dialer.calls.Load())
+	}
+	if checked != 0 {
+		test.Fatalf("CheckRedirect must not be called for unsupported URLs, got %d calls", checked)
+	}
+}
@@ -8,7 +8,11 @@ import (
 // ErrInvalidServerName means that the server certificate doesn't match the server domain.
 var ErrInvalidServerName = errors.New("server domain and server TLS domain name don't match")
 
-func tlsVerifyDomain(cs *tls.ConnectionState, domain string) (err error) {
+// VerifyDomain checks that the server certificate of the connection is issued for the domain.
+// Self-signed certificates, which rely on the legacy common name field, are accepted.
+// It's used by Client to verify server certificates and can be used by other
+// gemini-style protocol clients in tls.Config.VerifyConnection.
+func VerifyDomain(cs *tls.ConnectionState, domain string) (err error) {
 	for _, cert := range cs.PeerCertificates {
 		// Workaround for "x509: certificate relies on legacy Common Name field, use SANs"
 		//
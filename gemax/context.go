@@ -0,0 +1,101 @@
+package gemax
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/ninedraft/gemax/gemax/proxyproto"
+)
+
+// contextKey is a key of values, which Server stores in handler contexts.
+type contextKey struct {
+	name string
+}
+
+func (key *contextKey) String() string {
+	return "gemax context value " + key.name
+}
+
+var (
+	// ServerContextKey is a context key. The associated value is the *Server,
+	// which serves the connection. It's available in Server.ConnContext as well.
+	ServerContextKey = &contextKey{"server"}
+	// ConnContextKey is a context key. The associated value is the net.Conn
+	// of the served connection, *tls.Conn for TLS servers.
+	// It's available in Server.ConnContext as well.
+	ConnContextKey = &contextKey{"conn"}
+	// TLSStateContextKey is a context key. The associated value is the *tls.ConnectionState
+	// of the served connection. It's set only for TLS connections.
+	TLSStateContextKey = &contextKey{"tls-state"}
+	// RequestContextKey is a context key. The associated value is the IncomingRequest
+	// served by the handler.
+	RequestContextKey = &contextKey{"request"}
+	// ProxyHeaderContextKey is a context key. The associated value is the *proxyproto.Header
+	// of the served connection. It's set only for connections from trusted proxies,
+	// see Server.ProxyProtocol.
+	ProxyHeaderContextKey = &contextKey{"proxy-header"}
+)
+
+// ServerFromContext returns the server, which serves the handler context.
+func ServerFromContext(ctx context.Context) (*Server, bool) {
+	var server, ok = ctx.Value(ServerContextKey).(*Server)
+	return server, ok
+}
+
+// ConnFromContext returns the connection of the handler context.
+// Writes to the connection bypass the response writer, so use it with care.
+func ConnFromContext(ctx context.Context) (net.Conn, bool) {
+	var conn, ok = ctx.Value(ConnContextKey).(net.Conn)
+	return conn, ok
+}
+
+// TLSStateFromContext returns the TLS connection state of the handler context:
+// negotiated version, cipher suite, SNI server name, ALPN protocol and client certificates.
+// Returns false for non-TLS connections.
+func TLSStateFromContext(ctx context.Context) (*tls.ConnectionState, bool) {
+	var state, ok = ctx.Value(TLSStateContextKey).(*tls.ConnectionState)
+	return state, ok
+}
+
+// RequestFromContext returns the request served by the handler.
+// Returns false, if ctx is not a handler context.
+func RequestFromContext(ctx context.Context) (IncomingRequest, bool) {
+	var req, ok = ctx.Value(RequestContextKey).(IncomingRequest)
+	return req, ok
+}
+
+// ProxyHeaderFromContext returns the PROXY header of the handler context connection.
+// Returns false, if the connection is not proxied.
+func ProxyHeaderFromContext(ctx context.Context) (*proxyproto.Header, bool) {
+	var header, ok = ctx.Value(ProxyHeaderContextKey).(*proxyproto.Header)
+	return header, ok
+}
+
+// ForwardedCertFingerprint returns the client certificate fingerprint, which was forwarded
+// by a trusted ReverseProxy as proxyproto.TLVCertFingerprint extension of the PROXY header.
+// The fingerprint has the same format as CertificateFingerprint.
+func ForwardedCertFingerprint(ctx context.Context) (string, bool) {
+	var header, ok = ProxyHeaderFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	var fingerprint, found = header.TLV(proxyproto.TLVCertFingerprint)
+	return string(fingerprint), found
+}
+
+// proxyHeader returns the PROXY header of connections accepted by proxyproto.Listener.
+func proxyHeader(conn net.Conn) *proxyproto.Header {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	var proxied, ok = conn.(*proxyproto.Conn)
+	if !ok {
+		return nil
+	}
+	var header, errHeader = proxied.ProxyHeader()
+	if errHeader != nil || header.Local {
+		return nil
+	}
+	return header
+}
@@ -0,0 +1,45 @@
+package gemax_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/internal/testaddr"
+)
+
+func TestServer_ContextValues(test *testing.T) {
+	test.Parallel()
+	var server = &gemax.Server{
+		Addr: testaddr.Addr(),
+		Logf: test.Logf,
+	}
+	server.Handler = func(ctx context.Context, rw gemax.ResponseWriter, req gemax.IncomingRequest) {
+		var srv, srvOK = gemax.ServerFromContext(ctx)
+		var conn, connOK = gemax.ConnFromContext(ctx)
+		var state, stateOK = gemax.TLSStateFromContext(ctx)
+		var ctxReq, reqOK = gemax.RequestFromContext(ctx)
+		if !srvOK || srv != server || !connOK || !stateOK || !reqOK || ctxReq != req {
+			test.Errorf("unexpected context values: %v %v %v %v", srv, conn, state, ctxReq)
+			return
+		}
+		if _, isTLS := conn.(*tls.Conn); !isTLS {
+			test.Errorf("expected TLS connection, got %T", conn)
+		}
+		_, _ = fmt.Fprintf(rw, "sni=%s host=%s version=%s", gemax.RequestTLS(req).ServerName, req.URL().Hostname(),
+			tls.VersionName(state.Version))
+	}
+	var conn = startTLSServer(test, server)
+
+	//nolint:gosec // test server uses self-signed certificate
+	var tlsConn = tls.Client(conn, &tls.Config{
+		MinVersion:         tls.VersionTLS13,
+		ServerName:         "localhost",
+		InsecureSkipVerify: true,
+	})
+	_, _ = io.WriteString(tlsConn, "gemini://example.com/\r\n")
+	expectResponse(test, tlsConn, "20 text/gemini\r\nsni=localhost host=example.com version=TLS 1.3")
+}
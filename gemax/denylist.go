@@ -0,0 +1,144 @@
+package gemax
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DenyList is a dynamic list of denied networks.
+// It's safe for concurrent use and can be modified while the server is running,
+// e.g. from a fail2ban-style hook. Empty value is ready to use.
+type DenyList struct {
+	mu      sync.RWMutex
+	entries map[netip.Prefix]time.Time
+}
+
+// Add denies addresses from prefix for ttl.
+// Non-positive ttl means no expiration.
+// IPv4-mapped IPv6 prefixes such as ::ffff:10.0.0.0/104 are stored as IPv4 prefixes.
+// Adding an existing prefix updates its expiration time.
+func (list *DenyList) Add(prefix netip.Prefix, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	if list.entries == nil {
+		list.entries = map[netip.Prefix]time.Time{}
+	}
+	list.entries[unmapPrefix(prefix)] = expires
+}
+
+// AddCIDR denies a network in CIDR notation or a single IP address for ttl.
+// Non-positive ttl means no expiration.
+//
+// Examples:
+//
+//	list.AddCIDR("192.0.2.0/24", time.Hour)
+//	list.AddCIDR("2001:db8::1", 0)
+func (list *DenyList) AddCIDR(cidr string, ttl time.Duration) error {
+	var prefix, errPrefix = parsePrefix(cidr)
+	if errPrefix != nil {
+		return errPrefix
+	}
+	list.Add(prefix, ttl)
+	return nil
+}
+
+// Remove removes prefix from the list.
+func (list *DenyList) Remove(prefix netip.Prefix) {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	delete(list.entries, unmapPrefix(prefix))
+}
+
+// RemoveCIDR removes a network in CIDR notation or a single IP address from the list.
+func (list *DenyList) RemoveCIDR(cidr string) error {
+	var prefix, errPrefix = parsePrefix(cidr)
+	if errPrefix != nil {
+		return errPrefix
+	}
+	list.Remove(prefix)
+	return nil
+}
+
+// Denied reports whether the address belongs to a denied network.
+// Expired entries are removed.
+func (list *DenyList) Denied(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	// IPv6 prefixes shorter than /96 may still cover the IPv4-mapped range
+	var mapped = netip.AddrFrom16(addr.As16())
+	var now = time.Now()
+	var expired []netip.Prefix
+	var denied bool
+	list.mu.RLock()
+	for prefix, expires := range list.entries {
+		switch {
+		case !expires.IsZero() && now.After(expires):
+			expired = append(expired, prefix)
+		case prefix.Contains(addr), prefix.Addr().Is6() && prefix.Contains(mapped):
+			denied = true
+		}
+	}
+	list.mu.RUnlock()
+
+	if len(expired) > 0 {
+		list.removeExpired(expired, now)
+	}
+	return denied
+}
+
+// removeExpired removes the prefixes, unless they were updated concurrently.
+func (list *DenyList) removeExpired(expired []netip.Prefix, now time.Time) {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	for _, prefix := range expired {
+		if expires, ok := list.entries[prefix]; ok && !expires.IsZero() && now.After(expires) {
+			delete(list.entries, prefix)
+		}
+	}
+}
+
+func parsePrefix(cidr string) (netip.Prefix, error) {
+	if !strings.Contains(cidr, "/") {
+		var addr, errAddr = netip.ParseAddr(cidr)
+		if errAddr != nil {
+			return netip.Prefix{}, fmt.Errorf("parsing address: %w", errAddr)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	var prefix, errPrefix = netip.ParsePrefix(cidr)
+	if errPrefix != nil {
+		return netip.Prefix{}, fmt.Errorf("parsing CIDR: %w", errPrefix)
+	}
+	return prefix, nil
+}
+
+// unmapPrefix converts IPv4-mapped IPv6 prefixes to IPv4 ones,
+// so they match unmapped addresses.
+func unmapPrefix(prefix netip.Prefix) netip.Prefix {
+	prefix = prefix.Masked()
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix
+}
+
+// remoteIP extracts IP address of a TCP connection.
+func remoteIP(addr net.Addr) (netip.Addr, bool) {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		var ip, ipOK = netip.AddrFromSlice(tcpAddr.IP)
+		return ip.Unmap(), ipOK
+	}
+	var addrPort, errParse = netip.ParseAddrPort(addr.String())
+	if errParse != nil {
+		return netip.Addr{}, false
+	}
+	return addrPort.Addr().Unmap(), true
+}
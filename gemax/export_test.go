@@ -0,0 +1,8 @@
+package gemax
+
+// HostLimiters returns the number of per-host limiters kept by the client.
+func (client *Client) HostLimiters() int {
+	client.hostsMu.Lock()
+	defer client.hostsMu.Unlock()
+	return len(client.hosts)
+}
@@ -0,0 +1,131 @@
+package gemax
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax/internal/fcgi"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// FastCGI forwards requests to a FastCGI application server (php-fpm, flup, etc.)
+// in the responder role. The application gets the same variables as CGI scripts
+// and writes a complete gemini response, header included.
+//
+// Connections to the application are persistent. Requests can be multiplexed
+// on a single connection, see MaxRequestsPerConn. Responses of multiplexed requests,
+// which are not read fast enough by clients, are aborted, so they don't stall
+// other requests on the connection.
+// Backend failures and malformed responses are reported as status.CGIError.
+type FastCGI struct {
+	// Application network: "unix" or "tcp". Default is "unix".
+	Network string
+	// Application address: a unix socket path or host:port.
+	Addr string
+	// URL path of the application. It's used to compute SCRIPT_NAME and PATH_INFO.
+	ScriptName string
+	// Additional params in form of "KEY=value", e.g. SCRIPT_FILENAME for php-fpm.
+	Env []string
+	// Maximum number of simultaneous connections to the application.
+	//	0 - 4 connections
+	//	<0 - no limitation
+	MaxConnections int
+	// Maximum number of requests multiplexed on a single connection.
+	//	0 - 1, requests are not multiplexed
+	//	<0 - negotiated with the application
+	MaxRequestsPerConn int
+	// Time limit of a single backend transaction.
+	// The earliest of the handler context deadline and the timeout is used.
+	//	0 - DefaultCGITimeout
+	//	<0 - no limitation
+	Timeout time.Duration
+	// Optional custom dialer. If nil, then net.Dialer is used.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Optional text logger. Application stderr is logged as well.
+	Logf func(format string, args ...any)
+
+	once   sync.Once
+	client *fcgi.Client
+}
+
+var _ Handler = new(FastCGI).Serve
+
+// Serve forwards request to the FastCGI application and streams its response to the client.
+func (fastCGI *FastCGI) Serve(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
+	fastCGI.init()
+	ctx, cancel := backendContext(ctx, fastCGI.Timeout)
+	defer cancel()
+
+	var body, size, errBody = backendBody(req)
+	if errBody != nil {
+		fastCGI.logf("ERROR: fastcgi %s: %v", fastCGI.Addr, errBody)
+		rw.WriteStatus(status.CGIError, status.CGIError.String())
+		return
+	}
+	var env = make([]string, 0, 32)
+	for _, kv := range append(cgiEnv(req, fastCGI.ScriptName), fastCGI.Env...) {
+		if !strings.HasPrefix(kv, "CONTENT_LENGTH=") {
+			env = append(env, kv)
+		}
+	}
+	if body != nil {
+		env = append(env, "CONTENT_LENGTH="+strconv.FormatInt(size, 10))
+	}
+	var stderr io.Writer
+	if fastCGI.Logf != nil {
+		stderr = &cgiStderr{name: fastCGI.Addr, logf: fastCGI.Logf}
+	}
+
+	var resp, errDo = fastCGI.client.Do(ctx, env, body, stderr)
+	if errDo != nil {
+		fastCGI.logf("ERROR: fastcgi %s: %v", fastCGI.Addr, errDo)
+		rw.WriteStatus(status.CGIError, status.CGIError.String())
+		return
+	}
+	defer func() { _ = resp.Close() }()
+	if errServe := serveCGIResponse(rw, bufio.NewReader(resp)); errServe != nil {
+		fastCGI.logf("ERROR: fastcgi %s: %v", fastCGI.Addr, errServe)
+		return
+	}
+	if appStatus := resp.AppStatus(); appStatus != 0 {
+		fastCGI.logf("WARN: fastcgi %s: application exit status %d", fastCGI.Addr, appStatus)
+	}
+}
+
+// Close closes connections to the application.
+func (fastCGI *FastCGI) Close() error {
+	fastCGI.init()
+	return fastCGI.client.Close()
+}
+
+func (fastCGI *FastCGI) init() {
+	fastCGI.once.Do(func() {
+		var network = fastCGI.Network
+		if network == "" {
+			network = "unix"
+		}
+		fastCGI.client = &fcgi.Client{
+			MaxConns:   fastCGI.MaxConnections,
+			MaxStreams: fastCGI.MaxRequestsPerConn,
+			Dial: func(ctx context.Context) (net.Conn, error) {
+				if fastCGI.Dial != nil {
+					return fastCGI.Dial(ctx, network, fastCGI.Addr)
+				}
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, fastCGI.Addr)
+			},
+		}
+	})
+}
+
+func (fastCGI *FastCGI) logf(format string, args ...any) {
+	if fastCGI.Logf != nil {
+		fastCGI.Logf(format, args...)
+	}
+}
@@ -0,0 +1,184 @@
+package gemax_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+
+	"github.com/ninedraft/gemax/vend/tailscale.com/net/memnet"
+)
+
+func TestFastCGI(test *testing.T) {
+	var listener = memnet.Listen(test.Name())
+	defer func() { _ = listener.Close() }()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			var conn, errAccept = listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			accepted.Add(1)
+			go serveFastCGI(conn, func(params map[string]string) string {
+				switch params["PATH_INFO"] {
+				case "/oops":
+					return "oops"
+				case "/large":
+					return "20 text/plain\r\n" + strings.Repeat("a", 1<<20)
+				}
+				return "20 text/plain\r\n" + params["GEMINI_URL"] + " " + params["PATH_INFO"]
+			})
+		}
+	}()
+
+	var fastCGI = &gemax.FastCGI{
+		Network:            "tcp",
+		Addr:               test.Name(),
+		ScriptName:         "/app",
+		MaxConnections:     1,
+		MaxRequestsPerConn: -1,
+		Dial:               listener.Dial,
+		Logf:               test.Logf,
+	}
+	defer func() { _ = fastCGI.Close() }()
+	var serve = func(path string) *responseRecorder {
+		var rw = &responseRecorder{}
+		var req = &request{
+			remoteAddr: test.Name(),
+			url:        "gemini://example.com/app" + path,
+		}
+		fastCGI.Serve(context.Background(), rw, req)
+		return rw
+	}
+
+	test.Run("multiplexed", func(test *testing.T) {
+		var wg sync.WaitGroup
+		for i := range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var path = fmt.Sprintf("/page/%d", i)
+				var rw = serve(path)
+				var expected = "gemini://example.com/app" + path + " " + path
+				if rw.status != status.Success || rw.String() != expected {
+					test.Errorf("expected %s %q, got %s %q", status.Success, expected, rw.status, rw.String())
+				}
+			}()
+		}
+		wg.Wait()
+		if n := accepted.Load(); n != 1 {
+			test.Errorf("expected 1 persistent connection, got %d", n)
+		}
+	})
+
+	test.Run("large response", func(test *testing.T) {
+		var single = &gemax.FastCGI{
+			Network:        "tcp",
+			Addr:           fastCGI.Addr,
+			ScriptName:     "/app",
+			MaxConnections: 1,
+			Dial:           listener.Dial,
+			Logf:           test.Logf,
+		}
+		defer func() { _ = single.Close() }()
+		var rw = &responseRecorder{}
+		single.Serve(context.Background(), rw, &request{url: "gemini://example.com/app/large"})
+		if rw.status != status.Success || rw.Len() != 1<<20 {
+			test.Fatalf("expected %s with %d bytes, got %s with %d bytes", status.Success, 1<<20, rw.status, rw.Len())
+		}
+	})
+
+	test.Run("malformed response", func(test *testing.T) {
+		var rw = serve("/oops")
+		if rw.status != status.CGIError {
+			test.Fatalf("expected %s, got %s", status.CGIError, rw.status)
+		}
+	})
+
+	test.Run("backend unavailable", func(test *testing.T) {
+		var unavailable = &gemax.FastCGI{
+			Addr: "/nonexistent/socket",
+			Logf: test.Logf,
+		}
+		var rw = &responseRecorder{}
+		unavailable.Serve(context.Background(), rw, &request{url: "gemini://example.com/"})
+		if rw.status != status.CGIError {
+			test.Fatalf("expected %s, got %s", status.CGIError, rw.status)
+		}
+	})
+}
+
+// serveFastCGI is a minimal multiplexing FastCGI responder.
+func serveFastCGI(conn net.Conn, handle func(params map[string]string) string) {
+	defer func() { _ = conn.Close() }()
+	var re = bufio.NewReader(conn)
+	var wmu sync.Mutex
+	var write = func(typ byte, id uint16, content []byte) {
+		var head = make([]byte, 8)
+		head[0], head[1] = 1, typ
+		binary.BigEndian.PutUint16(head[2:], id)
+		binary.BigEndian.PutUint16(head[4:], uint16(len(content)))
+		wmu.Lock()
+		defer wmu.Unlock()
+		_, _ = conn.Write(append(head, content...))
+	}
+	var params = map[uint16]*strings.Builder{}
+	for {
+		var head = make([]byte, 8)
+		if _, err := io.ReadFull(re, head); err != nil {
+			return
+		}
+		var typ, id = head[1], binary.BigEndian.Uint16(head[2:])
+		var content = make([]byte, int(binary.BigEndian.Uint16(head[4:]))+int(head[6]))
+		if _, err := io.ReadFull(re, content); err != nil {
+			return
+		}
+		content = content[:binary.BigEndian.Uint16(head[4:])]
+		switch typ {
+		case 9: // get values
+			write(10, 0, []byte("\x0f\x01FCGI_MPXS_CONNS1\x0d\x02FCGI_MAX_REQS10"))
+		case 1: // begin request
+			params[id] = &strings.Builder{}
+		case 4: // params
+			params[id].Write(content)
+		case 5: // stdin
+			if len(content) > 0 {
+				continue
+			}
+			var decoded = decodeFastCGIParams(params[id].String())
+			delete(params, id)
+			go func() {
+				var out = handle(decoded)
+				for len(out) > 0 {
+					var n = min(len(out), 7+len(out)/16, 1<<15)
+					write(6, id, []byte(out[:n]))
+					out = out[n:]
+				}
+				write(6, id, nil)
+				write(3, id, make([]byte, 8))
+			}()
+		}
+	}
+}
+
+// decodeFastCGIParams decodes short name-value pairs.
+func decodeFastCGIParams(data string) map[string]string {
+	var params = map[string]string{}
+	for len(data) >= 2 {
+		var nameLen, valueLen = int(data[0]), int(data[1])
+		data = data[2:]
+		params[data[:nameLen]] = data[nameLen : nameLen+valueLen]
+		data = data[nameLen+valueLen:]
+	}
+	return params
+}
@@ -7,9 +7,13 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
 	"net/http"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ninedraft/gemax/gemax/status"
 )
@@ -27,31 +31,52 @@ type FileSystem struct {
 	// Will be prepended to the request pats.
 	Prefix string
 	// Optional text logger.
+	// Messages keep their format since before Logger was added,
+	// CGI script messages are formatted as "LEVEL: message key=value ..." lines.
 	Logf func(format string, args ...any)
+	// Optional structured logger. It can be used along with Logf.
+	Logger *slog.Logger
+
+	// URL path prefix of CGI scripts, for example "/cgi-bin".
+	// Requests with this prefix are served by executables from CGIDir.
+	// Path segments after the script name are passed as PATH_INFO.
+	// CGI is disabled if either CGIPrefix or CGIDir is empty.
+	CGIPrefix string
+	// OS directory with CGI executables.
+	CGIDir string
+	// Time limit of a single script execution. See CGI.Timeout.
+	CGITimeout time.Duration
 }
 
 var _ Handler = new(FileSystem).Serve
 
 // Serve provided file system as gemini catalogs.
 func (fileSystem *FileSystem) Serve(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
-	fileSystem.logf("INFO: %s is requested", req.URL())
+	fileSystem.log(ctx, slog.LevelDebug, "request",
+		logfLine("INFO: %s is requested", req.URL()),
+		"url", req.URL().String(), "remote_addr", req.RemoteAddr())
+	if fileSystem.serveCGI(ctx, rw, req) {
+		return
+	}
 
 	var p = path.Join(fileSystem.Prefix, req.URL().Path)
 	p = strings.TrimPrefix(p, "/")
 	if p == "" {
 		p = "."
 	}
-	fileSystem.logf("INFO: serving path: %s", p)
+	fileSystem.log(ctx, slog.LevelDebug, "serving path", logfLine("INFO: serving path: %s", p), "path", p)
 
 	var file, errOpen = fileSystem.FS.Open(p)
 	switch {
 	case errors.Is(errOpen, fs.ErrNotExist):
 		const code = status.NotFound
-		fileSystem.logf("WARN: %s is not found", p)
+		fileSystem.log(ctx, slog.LevelWarn, "not found", logfLine("WARN: %s is not found", p), "path", p)
 		rw.WriteStatus(code, code.String()+": "+req.URL().Path)
 		return
 	case errOpen != nil:
-		fileSystem.logf("ERROR: serving %s: opening file: %v", p, errOpen)
+		fileSystem.log(ctx, slog.LevelError, "opening file",
+			logfLine("ERROR: serving %s: opening file: %v", p, errOpen),
+			"path", p, "error", errOpen)
 		rw.WriteStatus(status.ServerUnavailable, "")
 		return
 	}
@@ -64,28 +89,37 @@ func (fileSystem *FileSystem) Serve(ctx context.Context, rw ResponseWriter, req
 	}
 	switch {
 	case info.IsDir() && !isDirReader(file):
-		fileSystem.logf("ERROR: serving dir %s: file is not ad directory reader!", p)
+		fileSystem.log(ctx, slog.LevelError, "file is not a directory reader",
+			logfLine("ERROR: serving dir %s: file is not ad directory reader!", p),
+			"path", p)
 		rw.WriteStatus(status.ServerUnavailable, "")
 	case info.IsDir() && isDirReader(file):
 		_ = file.Close()
-		fileSystem.serveDir(rw, req, p)
+		fileSystem.serveDir(ctx, rw, req, p)
 	default:
-		fileSystem.serveFile(rw, p, file)
+		fileSystem.serveFile(ctx, rw, p, file)
 	}
 }
 
-func (fileSystem *FileSystem) serveFile(rw ResponseWriter, name string, file io.Reader) {
+func (fileSystem *FileSystem) serveFile(ctx context.Context, rw ResponseWriter, name string, file io.Reader) {
 	var errHead = fileSystem.serveFileHead(rw, name, file)
 	if errHead != nil {
-		fileSystem.logf("serving file %s: reading file head: %v", name, errHead)
+		fileSystem.log(ctx, slog.LevelError, "reading file head",
+			logfLine("serving file %s: reading file head: %v", name, errHead),
+			"path", name, "error", errHead)
 		rw.WriteStatus(status.ServerUnavailable, "")
 		return
 	}
 	var _, errCopy = io.Copy(rw, file)
 	if errCopy != nil {
-		fileSystem.logf("ERROR: serving file %s: %v", name, errCopy)
+		fileSystem.log(ctx, slog.LevelError, "serving file",
+			logfLine("ERROR: serving file %s: %v", name, errCopy),
+			"path", name, "error", errCopy)
+		return
 	}
-	fileSystem.logf("INFO: serving file %s: ok", name)
+	fileSystem.log(ctx, slog.LevelInfo, "file served",
+		logfLine("INFO: serving file %s: ok", name),
+		"path", name)
 }
 
 func (fileSystem *FileSystem) serveFileHead(rw ResponseWriter, name string, file io.Reader) error {
@@ -107,13 +141,15 @@ func (fileSystem *FileSystem) serveFileHead(rw ResponseWriter, name string, file
 	return errWrite
 }
 
-func (fileSystem *FileSystem) serveDir(rw ResponseWriter, req IncomingRequest, dir string) {
-	if fileSystem.serveIndexFile(rw, dir, "index.gmi", "index.gemini") {
+func (fileSystem *FileSystem) serveDir(ctx context.Context, rw ResponseWriter, req IncomingRequest, dir string) {
+	if fileSystem.serveIndexFile(ctx, rw, dir, "index.gmi", "index.gemini") {
 		return
 	}
 	var entries, errEntries = fs.ReadDir(fileSystem.FS, dir)
 	if errEntries != nil {
-		fileSystem.logf("ERROR: serving dir %s: reading dir content: %v", dir, errEntries)
+		fileSystem.log(ctx, slog.LevelError, "reading dir content",
+			logfLine("ERROR: serving dir %s: reading dir content: %v", dir, errEntries),
+			"path", dir, "error", errEntries)
 		rw.WriteStatus(status.ServerUnavailable, "")
 		return
 	}
@@ -127,31 +163,108 @@ func (fileSystem *FileSystem) serveDir(rw ResponseWriter, req IncomingRequest, d
 		var fileLink = path.Join(req.URL().Path, entry.Name())
 		var _, errWriteEntry = fmt.Fprintf(rw, "=> %s %s \r\n", fileLink, entry.Name())
 		if errWriteEntry != nil {
-			fileSystem.logf("ERROR: serving dir %s: writing file entry %s: %v", dir, entry.Name(), errWriteEntry)
+			fileSystem.log(ctx, slog.LevelError, "writing dir entry",
+				logfLine("ERROR: serving dir %s: writing file entry %s: %v", dir, entry.Name(), errWriteEntry),
+				"path", dir, "entry", entry.Name(), "error", errWriteEntry)
 			return
 		}
 	}
 }
 
-func (fileSystem *FileSystem) serveIndexFile(rw ResponseWriter, dir string, names ...string) bool {
+func (fileSystem *FileSystem) serveIndexFile(ctx context.Context, rw ResponseWriter, dir string, names ...string) bool {
 	for _, name := range names {
 		var indexFile = path.Join(dir, name)
 		var data, err = fs.ReadFile(fileSystem.FS, indexFile)
 		if err != nil {
-			fileSystem.logf("WARN: serving dir %s: searching index file %s: %v", dir, indexFile, err)
+			fileSystem.log(ctx, slog.LevelDebug, "searching index file",
+				logfLine("WARN: serving dir %s: searching index file %s: %v", dir, indexFile, err),
+				"path", indexFile, "error", err)
 			continue
 		}
-		fileSystem.serveFile(rw, indexFile, bytes.NewReader(data))
+		fileSystem.serveFile(ctx, rw, indexFile, bytes.NewReader(data))
 		return true
 	}
-	fileSystem.logf("WARN: serving dir %s: searching index files %v: no index files were found", dir, names)
+	fileSystem.log(ctx, slog.LevelDebug, "no index files were found",
+		logfLine("WARN: serving dir %s: searching index files %v: no index files were found", dir, names),
+		"path", dir, "names", names)
 	return false
 }
 
-func (fileSystem *FileSystem) logf(format string, args ...any) {
-	if fileSystem.Logf != nil {
-		fileSystem.Logf(format, args...)
+// serveCGI runs CGI script if the request path has CGIPrefix.
+// Returns false if the request is not a CGI request.
+func (fileSystem *FileSystem) serveCGI(ctx context.Context, rw ResponseWriter, req IncomingRequest) bool {
+	if fileSystem.CGIPrefix == "" || fileSystem.CGIDir == "" {
+		return false
+	}
+	var prefix = "/" + strings.Trim(fileSystem.CGIPrefix, "/")
+	var urlPath = req.URL().Path
+	if urlPath != prefix && !strings.HasPrefix(urlPath, prefix+"/") {
+		return false
+	}
+
+	var scriptPath, scriptName = fileSystem.findCGIScript(ctx, prefix, urlPath)
+	if scriptPath == "" {
+		NotFound(rw, req)
+		return true
+	}
+	var cgi = &CGI{
+		Path:       scriptPath,
+		Dir:        filepath.Dir(scriptPath),
+		ScriptName: scriptName,
+		Timeout:    fileSystem.CGITimeout,
+		Logf:       fileSystem.Logf,
 	}
+	cgi.Serve(ctx, rw, req)
+	return true
+}
+
+// findCGIScript walks the URL path segments after the CGI prefix down CGIDir
+// and returns path and script name of the first executable file.
+// Empty path is returned, if there is no such file.
+func (fileSystem *FileSystem) findCGIScript(ctx context.Context, prefix, urlPath string) (scriptPath, scriptName string) {
+	var segments = strings.Split(strings.Trim(strings.TrimPrefix(urlPath, prefix), "/"), "/")
+	var cgiDir = filepath.Clean(fileSystem.CGIDir)
+	scriptPath = cgiDir
+	for i, segment := range segments {
+		if segment == "" {
+			break
+		}
+		if !validCGISegment(segment) {
+			fileSystem.log(ctx, slog.LevelWarn, "cgi script: invalid path", "path", urlPath)
+			break
+		}
+		scriptPath = filepath.Clean(filepath.Join(scriptPath, segment))
+		if !strings.HasPrefix(scriptPath, cgiDir+string(filepath.Separator)) {
+			fileSystem.log(ctx, slog.LevelWarn, "cgi script: path is outside of CGIDir", "path", urlPath)
+			break
+		}
+		var info, errStat = os.Stat(scriptPath)
+		switch {
+		case errStat != nil:
+			fileSystem.log(ctx, slog.LevelWarn, "cgi script", "path", scriptPath, "error", errStat)
+		case info.IsDir():
+			continue
+		case isExecutable(info):
+			return scriptPath, path.Join(prefix, path.Join(segments[:i+1]...))
+		default:
+			fileSystem.log(ctx, slog.LevelWarn, "cgi script is not executable", "path", scriptPath)
+		}
+		break
+	}
+	return "", ""
+}
+
+// validCGISegment reports whether the URL path segment can be used as a file name.
+func validCGISegment(segment string) bool {
+	return segment != "." && segment != ".." && !strings.ContainsAny(segment, "\x00\\")
+}
+
+func (fileSystem *FileSystem) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	fileSystem.logSink().log(ctx, level, msg, args...)
+}
+
+func (fileSystem *FileSystem) logSink() logSink {
+	return logSink{logger: fileSystem.Logger, logf: fileSystem.Logf}
 }
 
 func isDirReader(file fs.File) bool {
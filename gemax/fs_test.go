@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"crypto/x509"
+	"fmt"
 	"io"
 	"io/fs"
 	"net/url"
+	"slices"
+	"strings"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -39,6 +42,34 @@ func TestFS(test *testing.T) {
 	})
 }
 
+func TestFS_Logf(test *testing.T) {
+	var lines []string
+	var fserve = gemax.FileSystem{
+		FS: fstest.MapFS{
+			"docs/page.gmi": {Data: []byte("# hello\n")},
+		},
+		Logf: func(format string, args ...any) {
+			lines = append(lines, fmt.Sprintf(format, args...))
+		},
+	}
+	var rw = &responseWriter{}
+	var req = &incomingRequest{remoteAddr: test.Name()}
+	req.url, _ = url.Parse("gemini://example.com/docs/")
+
+	fserve.Serve(context.Background(), rw, req)
+
+	var expected = []string{
+		"INFO: gemini://example.com/docs/ is requested",
+		"INFO: serving path: docs",
+		"WARN: serving dir docs: searching index file docs/index.gmi: open docs/index.gmi: file does not exist",
+		"WARN: serving dir docs: searching index file docs/index.gemini: open docs/index.gemini: file does not exist",
+		"WARN: serving dir docs: searching index files [index.gmi index.gemini]: no index files were found",
+	}
+	if !slices.Equal(lines, expected) {
+		test.Fatalf("expected Logf lines:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
+
 func TestFS_ReadDirError_NilLogger_NoPanic(test *testing.T) {
 	test.Parallel()
 
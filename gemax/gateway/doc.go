@@ -0,0 +1,3 @@
+// Package gateway provides an HTTP to gemini gateway.
+// It allows to read gemini capsules with web browsers.
+package gateway
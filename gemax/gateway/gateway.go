@@ -0,0 +1,338 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	urlpkg "net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// InputField is the name of the form field used to submit gemini input.
+const InputField = "input"
+
+// DefaultMaxDocumentSize is the default size limit of gemtext documents rendered as HTML.
+const DefaultMaxDocumentSize = 4 << 20
+
+// Handler serves gemini resources over HTTP.
+// Request paths are mapped to gemini URLs as follows:
+//
+//	{Prefix}/{host}/{path}?{query} -> gemini://{host}/{path}?{query}
+//
+// Gemtext documents are rendered as HTML. Images, audio, video and plain text are passed through,
+// other media types are served as plain text, so upstream content can't run scripts on the gateway origin.
+// Gemini links are rewritten to keep navigation inside the gateway.
+// Input requests are rendered as HTML forms, redirects to allowed gemini hosts are mapped
+// to HTTP redirects, other redirects are rendered as link pages, and failures are mapped
+// to HTTP error pages.
+type Handler struct {
+	// Client is used to fetch gemini resources.
+	// To map gemini redirects to HTTP redirects, Client.CheckRedirect
+	// must return gemax.ErrUseLastResponse.
+	// Custom clients should use gemax.HostAllowlist.Dial, so host names can't be resolved
+	// to internal addresses.
+	// If nil, then a client, which doesn't follow redirects and dials with gemax.HostAllowlist.Dial, is used.
+	Client *gemax.Client
+	// Prefix is the URL path the handler is mounted at, e.g. "/gemini".
+	Prefix string
+	// Allowed gemini hosts. See gemax.HostAllowlist.
+	// Use gemax.AnyPublicHost to serve any public host.
+	// If empty, then all requests are refused.
+	Hosts []string
+	// Maximum size of a gemtext document rendered as HTML.
+	// Larger documents are reported as 502 Bad Gateway.
+	//	0 - DefaultMaxDocumentSize
+	//	<0 - no limitation
+	MaxDocumentSize int64
+	// Optional text logger.
+	Logf func(format string, args ...any)
+
+	once   sync.Once
+	client *gemax.Client
+}
+
+var _ http.Handler = new(Handler)
+
+// ServeHTTP fetches requested gemini resource and serves it over HTTP.
+func (handler *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	handler.init()
+	var target, ok = handler.geminiURL(req.URL)
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	if !handler.allowed(target) {
+		http.Error(rw, "host "+target.Host+" is not allowed", http.StatusForbidden)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		// pass
+	case http.MethodPost:
+		target.RawQuery = escapeQuery(req.PostFormValue(InputField))
+		http.Redirect(rw, req, handler.gatewayPath(target), http.StatusSeeOther)
+		return
+	default:
+		rw.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resp = handler.fetch(rw, req, target)
+	if resp == nil {
+		return
+	}
+	defer func() { _ = resp.Close() }()
+
+	switch {
+	case resp.Status == status.Input, resp.Status == status.InputSensitive:
+		handler.serveInput(rw, target, resp)
+	case resp.Status == status.Redirect, resp.Status == status.RedirectPermanent:
+		handler.serveRedirect(rw, req, target, resp)
+	case resp.Status == status.Success:
+		handler.serveBody(rw, target, resp)
+	default:
+		handler.serveError(rw, resp)
+	}
+}
+
+// fetch fetches the target resource. Fetching errors are served to rw and nil is returned.
+func (handler *Handler) fetch(rw http.ResponseWriter, req *http.Request, target *urlpkg.URL) *gemax.Response {
+	var resp, errFetch = handler.client.Fetch(req.Context(), target.String())
+	var errScheme *gemax.UnsupportedSchemeError
+	switch {
+	case errors.Is(errFetch, gemax.ErrHostNotAllowed):
+		http.Error(rw, "host "+target.Host+" is not allowed", http.StatusForbidden)
+		return nil
+	case errors.As(errFetch, &errScheme):
+		// redirect to a non-gemini URL
+		renderRedirectPage(rw, status.Text(status.Redirect), errScheme.URL)
+		return nil
+	case errFetch != nil:
+		handler.logf("ERROR: fetching %s: %v", target, errFetch)
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return nil
+	}
+	return resp
+}
+
+func (handler *Handler) init() {
+	handler.once.Do(func() {
+		handler.client = handler.Client
+		if handler.client == nil {
+			handler.client = &gemax.Client{
+				Dial: gemax.HostAllowlist(handler.Hosts).Dial,
+				CheckRedirect: func(context.Context, *urlpkg.URL, []gemax.RedirectedRequest) error {
+					return gemax.ErrUseLastResponse
+				},
+			}
+		}
+	})
+}
+
+func (handler *Handler) serveInput(rw http.ResponseWriter, target *urlpkg.URL, resp *gemax.Response) {
+	renderPage(rw, http.StatusOK, &page{
+		Title:     resp.Meta,
+		Input:     true,
+		Sensitive: resp.Status == status.InputSensitive,
+		Action:    handler.gatewayPath(target),
+		Field:     InputField,
+	})
+}
+
+func (handler *Handler) serveRedirect(rw http.ResponseWriter, req *http.Request, target *urlpkg.URL, resp *gemax.Response) {
+	var location, errLocation = urlpkg.Parse(strings.TrimSpace(resp.Meta))
+	if errLocation != nil {
+		handler.logf("ERROR: fetching %s: invalid redirect target %q: %v", target, resp.Meta, errLocation)
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	var resolved = target.ResolveReference(location)
+	if resolved.Scheme != "gemini" || !handler.allowed(resolved) {
+		// HTTP redirects to arbitrary targets would make the gateway an open redirect
+		renderRedirectPage(rw, status.Text(resp.Status), resolved)
+		return
+	}
+	var code = http.StatusFound
+	if resp.Status == status.RedirectPermanent {
+		code = http.StatusMovedPermanently
+	}
+	http.Redirect(rw, req, handler.gatewayPath(resolved), code)
+}
+
+func (handler *Handler) serveBody(rw http.ResponseWriter, target *urlpkg.URL, resp *gemax.Response) {
+	var mediaType, params, errMediaType = mime.ParseMediaType(resp.Meta)
+	if errMediaType != nil {
+		mediaType, params = gemax.MIMEGemtext, nil
+	}
+	if mediaType != gemax.MIMEGemtext {
+		var header = rw.Header()
+		header.Set("Content-Type", passthroughType(mediaType, params))
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Content-Security-Policy", "sandbox")
+		if _, errCopy := io.Copy(rw, resp); errCopy != nil {
+			handler.logf("ERROR: serving %s: %v", target, errCopy)
+		}
+		return
+	}
+	var body io.Reader = resp
+	var limit = handler.maxDocumentSize()
+	if limit > 0 {
+		body = io.LimitReader(resp, limit+1)
+	}
+	var counter = &countingReader{re: body}
+	var doc, errRender = renderGemtext(counter, func(link *urlpkg.URL) string {
+		return handler.rewriteLink(target, link)
+	})
+	if errRender == nil && limit > 0 && counter.n > limit {
+		errRender = errDocumentTooLarge
+	}
+	if errRender != nil {
+		handler.logf("ERROR: serving %s: reading document: %v", target, errRender)
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	doc.Lang = params["lang"]
+	if doc.Title == "" {
+		doc.Title = target.String()
+	}
+	renderPage(rw, http.StatusOK, doc)
+}
+
+var errDocumentTooLarge = errors.New("document is too large")
+
+func (handler *Handler) maxDocumentSize() int64 {
+	if handler.MaxDocumentSize == 0 {
+		return DefaultMaxDocumentSize
+	}
+	return handler.MaxDocumentSize
+}
+
+// countingReader counts bytes read from the underlying reader.
+type countingReader struct {
+	re io.Reader
+	n  int64
+}
+
+func (counter *countingReader) Read(dst []byte) (int, error) {
+	var n, err = counter.re.Read(dst)
+	counter.n += int64(n)
+	return n, err
+}
+
+func (handler *Handler) serveError(rw http.ResponseWriter, resp *gemax.Response) {
+	var code = httpStatus(resp.Status)
+	if resp.Status == status.SlowDown {
+		if delay, err := gemax.SlowDownDelay(resp.Meta); err == nil {
+			rw.Header().Set("Retry-After", strconv.Itoa(int(delay.Seconds())))
+		}
+	}
+	renderPage(rw, code, &page{
+		Title: status.Text(resp.Status),
+		Error: resp.Meta,
+	})
+}
+
+// passthroughType returns Content-Type of a passed through body.
+// Media types, which browsers can run scripts from, are replaced with text/plain.
+func passthroughType(mediaType string, params map[string]string) string {
+	var major, _, _ = strings.Cut(mediaType, "/")
+	switch {
+	case mediaType == "text/plain",
+		major == "image" && mediaType != "image/svg+xml",
+		major == "audio",
+		major == "video":
+		return mime.FormatMediaType(mediaType, params)
+	}
+	return "text/plain"
+}
+
+// httpStatus maps gemini failure codes to HTTP status codes.
+func httpStatus(code status.Code) int {
+	switch code {
+	case status.CGIError:
+		return http.StatusInternalServerError
+	case status.ProxyError:
+		return http.StatusBadGateway
+	case status.SlowDown:
+		return http.StatusTooManyRequests
+	case status.NotFound:
+		return http.StatusNotFound
+	case status.Gone:
+		return http.StatusGone
+	case status.ProxyRequestRefused:
+		return http.StatusForbidden
+	case status.BadRequest:
+		return http.StatusBadRequest
+	}
+	switch code / 10 {
+	case 4:
+		return http.StatusServiceUnavailable
+	case 6:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// geminiURL maps gateway request URL to gemini URL.
+func (handler *Handler) geminiURL(u *urlpkg.URL) (*urlpkg.URL, bool) {
+	var p, ok = strings.CutPrefix(u.Path, strings.TrimSuffix(handler.Prefix, "/")+"/")
+	if !ok {
+		return nil, false
+	}
+	var host, path, _ = strings.Cut(p, "/")
+	if host == "" {
+		return nil, false
+	}
+	return &urlpkg.URL{
+		Scheme:   "gemini",
+		Host:     host,
+		Path:     "/" + path,
+		RawQuery: u.RawQuery,
+	}, true
+}
+
+// gatewayPath maps gemini URL to gateway request path.
+func (handler *Handler) gatewayPath(u *urlpkg.URL) string {
+	var p = strings.TrimSuffix(handler.Prefix, "/") + "/" + u.Host + u.EscapedPath()
+	if u.Path == "" {
+		p += "/"
+	}
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return p
+}
+
+// rewriteLink resolves link against the page URL and maps allowed gemini links to the gateway paths.
+func (handler *Handler) rewriteLink(base, link *urlpkg.URL) string {
+	var resolved = base.ResolveReference(link)
+	if resolved.Scheme != "gemini" || !handler.allowed(resolved) {
+		return resolved.String()
+	}
+	return handler.gatewayPath(resolved)
+}
+
+func (handler *Handler) allowed(u *urlpkg.URL) bool {
+	return gemax.HostAllowlist(handler.Hosts).Allowed(u)
+}
+
+func (handler *Handler) logf(format string, args ...any) {
+	if handler.Logf != nil {
+		handler.Logf(format, args...)
+	}
+}
+
+// escapeQuery escapes user input as a gemini query. Spaces are encoded as %20.
+func escapeQuery(input string) string {
+	return strings.ReplaceAll(urlpkg.QueryEscape(input), "+", "%20")
+}
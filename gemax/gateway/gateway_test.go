@@ -0,0 +1,220 @@
+package gateway_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/gateway"
+	"github.com/ninedraft/gemax/gemax/internal/tester"
+)
+
+func TestHandler(test *testing.T) {
+	var pages = fstest.MapFS{
+		"capsule.com":  {Data: []byte("20 text/gemini; lang=en\r\n# Hello\n=> /other Other page\n=> https://example.org Web\n* a\n* b\n```\n<b>\n```\n")},
+		"image.com":    {Data: []byte("20 image/png\r\nPNG")},
+		"input.com":    {Data: []byte("11 Password\r\n")},
+		"redirect.com": {Data: []byte("31 /target\r\n")},
+		"missing.com":  {Data: []byte("51 not here\r\n")},
+		"slow.com":     {Data: []byte("44 30\r\n")},
+		"script.com":   {Data: []byte("20 text/html\r\n<script>alert(1)</script>")},
+		"other.com":    {Data: []byte("20 text/plain\r\nsecret")},
+		"web.com":      {Data: []byte("30 https://example.org/phishing\r\n")},
+		"offsite.com":  {Data: []byte("31 gemini://other.com/\r\n")},
+	}
+	var dialer = &tester.DialFS{FS: pages}
+	var handler = &gateway.Handler{
+		Client: &gemax.Client{
+			Dial: dialer.Dial,
+			CheckRedirect: func(context.Context, *url.URL, []gemax.RedirectedRequest) error {
+				return gemax.ErrUseLastResponse
+			},
+		},
+		Prefix: "/gemini",
+		Hosts:  []string{"capsule.com", "image.com", "input.com", "redirect.com", "missing.com", "slow.com", "script.com", "web.com", "offsite.com"},
+		Logf:   test.Logf,
+	}
+	var server = httptest.NewServer(handler)
+	defer server.Close()
+	var client = server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	var get = func(test *testing.T, path string) (*http.Response, string) {
+		test.Helper()
+		var resp, errGet = client.Get(server.URL + path)
+		if errGet != nil {
+			test.Fatalf("unexpected error: %v", errGet)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		var body, _ = io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	test.Run("gemtext", func(test *testing.T) {
+		var resp, body = get(test, "/gemini/capsule.com/")
+		if resp.StatusCode != http.StatusOK {
+			test.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+		for _, want := range []string{
+			`<html lang="en">`,
+			`<title>Hello</title>`,
+			`<a href="/gemini/capsule.com/other">Other page</a>`,
+			`<a href="https://example.org">Web</a>`,
+			"<li>a</li>\n<li>b</li>",
+			"<pre>&lt;b&gt;\n</pre>",
+		} {
+			if !strings.Contains(body, want) {
+				test.Errorf("body must contain %q:\n%s", want, body)
+			}
+		}
+	})
+
+	test.Run("passthrough", func(test *testing.T) {
+		var resp, body = get(test, "/gemini/image.com/cat.png")
+		if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+			test.Fatalf("unexpected content type %q", ct)
+		}
+		if body != "PNG" {
+			test.Fatalf("unexpected body %q", body)
+		}
+		if resp.Header.Get("X-Content-Type-Options") != "nosniff" ||
+			resp.Header.Get("Content-Security-Policy") != "sandbox" {
+			test.Fatalf("unexpected headers %v", resp.Header)
+		}
+	})
+
+	test.Run("active content", func(test *testing.T) {
+		var resp, _ = get(test, "/gemini/script.com/")
+		if ct := resp.Header.Get("Content-Type"); ct != "text/plain" {
+			test.Fatalf("unexpected content type %q", ct)
+		}
+		if resp.Header.Get("Content-Security-Policy") != "sandbox" {
+			test.Fatalf("unexpected headers %v", resp.Header)
+		}
+	})
+
+	test.Run("not allowed", func(test *testing.T) {
+		var resp, body = get(test, "/gemini/other.com/")
+		if resp.StatusCode != http.StatusForbidden || strings.Contains(body, "secret") {
+			test.Fatalf("unexpected response %d:\n%s", resp.StatusCode, body)
+		}
+	})
+
+	test.Run("input", func(test *testing.T) {
+		var _, body = get(test, "/gemini/input.com/login")
+		if !strings.Contains(body, `<form method="post" action="/gemini/input.com/login">`) ||
+			!strings.Contains(body, `type="password"`) {
+			test.Fatalf("unexpected input form:\n%s", body)
+		}
+
+		var resp, errPost = client.PostForm(server.URL+"/gemini/input.com/login", url.Values{
+			gateway.InputField: {"hello world"},
+		})
+		if errPost != nil {
+			test.Fatalf("unexpected error: %v", errPost)
+		}
+		_ = resp.Body.Close()
+		if loc := resp.Header.Get("Location"); loc != "/gemini/input.com/login?hello%20world" {
+			test.Fatalf("unexpected location %q", loc)
+		}
+	})
+
+	test.Run("redirect", func(test *testing.T) {
+		var resp, _ = get(test, "/gemini/redirect.com/source")
+		if resp.StatusCode != http.StatusMovedPermanently {
+			test.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+		if loc := resp.Header.Get("Location"); loc != "/gemini/redirect.com/target" {
+			test.Fatalf("unexpected location %q", loc)
+		}
+	})
+
+	test.Run("redirect to other scheme", func(test *testing.T) {
+		var resp, body = get(test, "/gemini/web.com/")
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Location") != "" {
+			test.Fatalf("unexpected response %d, Location: %q", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		if !strings.Contains(body, `<a href="https://example.org/phishing">`) {
+			test.Fatalf("body must contain redirect link:\n%s", body)
+		}
+	})
+
+	test.Run("redirect to not allowed host", func(test *testing.T) {
+		var resp, body = get(test, "/gemini/offsite.com/")
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Location") != "" {
+			test.Fatalf("unexpected response %d, Location: %q", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		if !strings.Contains(body, `>gemini://other.com/</a>`) {
+			test.Fatalf("body must contain redirect link:\n%s", body)
+		}
+	})
+
+	test.Run("errors", func(test *testing.T) {
+		var resp, body = get(test, "/gemini/missing.com/")
+		if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "not here") {
+			test.Fatalf("unexpected response %d:\n%s", resp.StatusCode, body)
+		}
+		resp, _ = get(test, "/gemini/slow.com/")
+		if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "30" {
+			test.Fatalf("unexpected response %d, Retry-After: %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+		}
+		resp, _ = get(test, "/other/path")
+		if resp.StatusCode != http.StatusNotFound {
+			test.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestHandler_DefaultDeny(test *testing.T) {
+	var dialer = &tester.DialFS{FS: fstest.MapFS{
+		"capsule.com": {Data: []byte("20 text/plain\r\nhello")},
+	}}
+	var handler = &gateway.Handler{
+		Client: &gemax.Client{Dial: dialer.Dial},
+		Prefix: "/gemini",
+	}
+	var rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/gemini/capsule.com/", nil))
+	if rw.Code != http.StatusForbidden {
+		test.Fatalf("unexpected status %d:\n%s", rw.Code, rw.Body)
+	}
+}
+
+func TestHandler_MaxDocumentSize(test *testing.T) {
+	var dialer = &tester.DialFS{FS: fstest.MapFS{
+		"small.com": {Data: []byte("20 text/gemini\r\n# Hello\n")},
+		"large.com": {Data: []byte("20 text/gemini\r\n" + strings.Repeat("lorem ipsum\n", 100))},
+		"long.com":  {Data: []byte("20 text/gemini\r\n# Long\n" + strings.Repeat("x", 100<<10) + "\n")},
+	}}
+	var handler = &gateway.Handler{
+		Client:          &gemax.Client{Dial: dialer.Dial},
+		Prefix:          "/gemini",
+		Hosts:           []string{"small.com", "large.com", "long.com"},
+		MaxDocumentSize: 64,
+		Logf:            test.Logf,
+	}
+	var tc = func(host string, expected int) {
+		test.Run(host, func(test *testing.T) {
+			var rw = httptest.NewRecorder()
+			handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/gemini/"+host+"/", nil))
+			if rw.Code != expected {
+				test.Fatalf("expected status %d, got %d:\n%s", expected, rw.Code, rw.Body)
+			}
+		})
+	}
+
+	tc("small.com", http.StatusOK)
+	tc("large.com", http.StatusBadGateway)
+
+	// lines are limited by the document size only
+	handler.MaxDocumentSize = 0
+	tc("long.com", http.StatusOK)
+}
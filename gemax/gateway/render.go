@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	urlpkg "net/url"
+
+	"github.com/ninedraft/gemax/gemax/gemtext"
+)
+
+// page contains data for the HTML page template.
+type page struct {
+	Lang   string
+	Title  string
+	Blocks []block
+
+	// Input form
+	Input     bool
+	Sensitive bool
+	Action    string
+	Field     string
+
+	// Error message
+	Error string
+}
+
+// block is a group of consecutive gemtext lines rendered as a single HTML element.
+type block struct {
+	Tag   string
+	Text  string
+	URL   string
+	Items []string
+}
+
+var headingTags = map[gemtext.LineType]string{
+	gemtext.Heading1: "h1",
+	gemtext.Heading2: "h2",
+	gemtext.Heading3: "h3",
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html{{with .Lang}} lang="{{.}}"{{end}}>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+</head>
+<body>
+{{- if .Input}}
+<form method="post" action="{{.Action}}">
+<label>{{.Title}}<br>
+<input name="{{.Field}}" type="{{if .Sensitive}}password{{else}}text{{end}}" autofocus>
+</label>
+<button type="submit">Submit</button>
+</form>
+{{- end}}
+{{- with .Error}}
+<h1>{{$.Title}}</h1>
+<p>{{.}}</p>
+{{- end}}
+{{- range .Blocks}}
+{{- if eq .Tag "a"}}
+<p><a href="{{.URL}}">{{if .Text}}{{.Text}}{{else}}{{.URL}}{{end}}</a></p>
+{{- else if eq .Tag "pre"}}
+<pre{{with .Text}} title="{{.}}"{{end}}>{{range .Items}}{{.}}
+{{end}}</pre>
+{{- else if eq .Tag "h1"}}
+<h1>{{.Text}}</h1>
+{{- else if eq .Tag "h2"}}
+<h2>{{.Text}}</h2>
+{{- else if eq .Tag "h3"}}
+<h3>{{.Text}}</h3>
+{{- else if eq .Tag "ul"}}
+<ul>
+{{- range .Items}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- else if eq .Tag "blockquote"}}
+<blockquote>{{.Text}}</blockquote>
+{{- else if .Text}}
+<p>{{.Text}}</p>
+{{- else}}
+<br>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+func renderPage(rw http.ResponseWriter, code int, data *page) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(code)
+	_ = pageTemplate.Execute(rw, data)
+}
+
+// renderRedirectPage renders redirect target as a link.
+// It's used for targets, which the gateway can't serve.
+func renderRedirectPage(rw http.ResponseWriter, title string, target *urlpkg.URL) {
+	renderPage(rw, http.StatusOK, &page{
+		Title:  title,
+		Blocks: []block{{Tag: "a", URL: target.String()}},
+	})
+}
+
+// renderGemtext groups gemtext lines into HTML blocks.
+// Links are rewritten with provided function.
+func renderGemtext(re io.Reader, rewrite func(link *urlpkg.URL) string) (*page, error) {
+	var r = &gemtextRenderer{doc: &page{}, rewrite: rewrite}
+	var scanner = gemtext.NewScanner(re)
+	for scanner.Scan() {
+		r.render(scanner.Line())
+	}
+	return r.doc, scanner.Err()
+}
+
+type gemtextRenderer struct {
+	doc          *page
+	rewrite      func(link *urlpkg.URL) string
+	preformatted bool
+}
+
+func (r *gemtextRenderer) render(line gemtext.Line) {
+	switch line.Type {
+	case gemtext.PreformatToggle:
+		if !r.preformatted {
+			r.add(block{Tag: "pre", Text: line.Text})
+		}
+		r.preformatted = !r.preformatted
+	case gemtext.Preformatted:
+		r.addItem("pre", line.Text)
+	case gemtext.ListItem:
+		r.addItem("ul", line.Text)
+	case gemtext.Link:
+		r.add(block{Tag: "a", Text: line.Text, URL: rewriteLink(line.URL, r.rewrite)})
+	case gemtext.Heading1, gemtext.Heading2, gemtext.Heading3:
+		if r.doc.Title == "" {
+			r.doc.Title = line.Text
+		}
+		r.add(block{Tag: headingTags[line.Type], Text: line.Text})
+	case gemtext.Quote:
+		r.add(block{Tag: "blockquote", Text: line.Text})
+	default:
+		r.add(block{Tag: "p", Text: line.Text})
+	}
+}
+
+func (r *gemtextRenderer) add(b block) {
+	r.doc.Blocks = append(r.doc.Blocks, b)
+}
+
+// addItem appends item to the last block, a new block is started if the last one has another tag.
+func (r *gemtextRenderer) addItem(tag, item string) {
+	var blocks = r.doc.Blocks
+	if len(blocks) == 0 || blocks[len(blocks)-1].Tag != tag {
+		r.add(block{Tag: tag})
+	}
+	var last = &r.doc.Blocks[len(r.doc.Blocks)-1]
+	last.Items = append(last.Items, item)
+}
+
+func rewriteLink(link string, rewrite func(link *urlpkg.URL) string) string {
+	var u, errParse = urlpkg.Parse(link)
+	if errParse != nil {
+		return link
+	}
+	return rewrite(u)
+}
@@ -0,0 +1,3 @@
+// Package gemtext provides a text/gemini document parser.
+// Reference document: gemini://gemini.circumlunar.space/docs/specification.gmi
+package gemtext
@@ -0,0 +1,124 @@
+package gemtext
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// LineType describes a kind of text/gemini line.
+type LineType int
+
+// Line types.
+const (
+	Text LineType = iota
+	Link
+	PreformatToggle
+	Preformatted
+	Heading1
+	Heading2
+	Heading3
+	ListItem
+	Quote
+)
+
+// Line is a parsed text/gemini line.
+type Line struct {
+	Type LineType
+	// Text is the line content without the line type prefix.
+	// For links it's a user-friendly link name, which can be empty.
+	// For preformat toggle lines it's an alt text.
+	Text string
+	// URL is a link target. It's empty for non-link lines.
+	URL string
+}
+
+// Scanner reads text/gemini document line by line.
+// It tracks preformatted blocks, so lines between preformat toggles
+// are reported as Preformatted.
+// Line length is not limited, so limit the document size instead.
+type Scanner struct {
+	re           *bufio.Reader
+	err          error
+	line         Line
+	preformatted bool
+}
+
+// NewScanner creates a new scanner, which reads provided document.
+func NewScanner(re io.Reader) *Scanner {
+	return &Scanner{
+		re: bufio.NewReader(re),
+	}
+}
+
+// Scan advances the scanner to the next line.
+// It returns false if the end of document is reached or an error occurred.
+func (scanner *Scanner) Scan() bool {
+	if scanner.err != nil {
+		return false
+	}
+	var raw, errRead = scanner.re.ReadString('\n')
+	if errRead != nil {
+		scanner.err = errRead
+		if raw == "" {
+			return false
+		}
+	}
+	raw = strings.TrimSuffix(strings.TrimSuffix(raw, "\n"), "\r")
+	scanner.line = ParseLine(raw, scanner.preformatted)
+	if scanner.line.Type == PreformatToggle {
+		scanner.preformatted = !scanner.preformatted
+	}
+	return true
+}
+
+// Line returns the most recent line parsed by Scan.
+func (scanner *Scanner) Line() Line {
+	return scanner.line
+}
+
+// Err returns the first non-EOF error encountered by the Scanner.
+func (scanner *Scanner) Err() error {
+	if errors.Is(scanner.err, io.EOF) {
+		return nil
+	}
+	return scanner.err
+}
+
+// ParseLine parses a single line without line terminator.
+// Preformatted flag reports whether the line is inside a preformatted block.
+func ParseLine(line string, preformatted bool) Line {
+	switch {
+	case strings.HasPrefix(line, "```"):
+		return Line{Type: PreformatToggle, Text: strings.TrimSpace(line[3:])}
+	case preformatted:
+		return Line{Type: Preformatted, Text: line}
+	case strings.HasPrefix(line, "=>"):
+		var url, name = ParseLink(line)
+		return Line{Type: Link, Text: name, URL: url}
+	case strings.HasPrefix(line, "###"):
+		return Line{Type: Heading3, Text: strings.TrimSpace(line[3:])}
+	case strings.HasPrefix(line, "##"):
+		return Line{Type: Heading2, Text: strings.TrimSpace(line[2:])}
+	case strings.HasPrefix(line, "#"):
+		return Line{Type: Heading1, Text: strings.TrimSpace(line[1:])}
+	case strings.HasPrefix(line, "* "):
+		return Line{Type: ListItem, Text: strings.TrimSpace(line[2:])}
+	case strings.HasPrefix(line, ">"):
+		return Line{Type: Quote, Text: strings.TrimSpace(line[1:])}
+	default:
+		return Line{Type: Text, Text: line}
+	}
+}
+
+// ParseLink extracts URL and optional name from a link line in form of "=>[<whitespace>]<URL>[<whitespace><USER-FRIENDLY LINK NAME>]".
+func ParseLink(line string) (url, name string) {
+	line = strings.TrimPrefix(line, "=>")
+	line = strings.TrimLeft(line, " \t")
+	var i = strings.IndexAny(line, " \t")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i:])
+}
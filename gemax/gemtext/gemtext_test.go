@@ -0,0 +1,71 @@
+package gemtext_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ninedraft/gemax/gemax/gemtext"
+)
+
+func TestScanner(test *testing.T) {
+	var document = strings.Join([]string{
+		"# Title",
+		"## Sub",
+		"### Subsub",
+		"=> gemini://example.com Example",
+		"=>\t/path",
+		"* item",
+		"> quote",
+		"```go",
+		"=> not a link",
+		"```",
+		"plain text\r",
+	}, "\n")
+
+	var expected = []gemtext.Line{
+		{Type: gemtext.Heading1, Text: "Title"},
+		{Type: gemtext.Heading2, Text: "Sub"},
+		{Type: gemtext.Heading3, Text: "Subsub"},
+		{Type: gemtext.Link, Text: "Example", URL: "gemini://example.com"},
+		{Type: gemtext.Link, URL: "/path"},
+		{Type: gemtext.ListItem, Text: "item"},
+		{Type: gemtext.Quote, Text: "quote"},
+		{Type: gemtext.PreformatToggle, Text: "go"},
+		{Type: gemtext.Preformatted, Text: "=> not a link"},
+		{Type: gemtext.PreformatToggle},
+		{Type: gemtext.Text, Text: "plain text"},
+	}
+
+	var scanner = gemtext.NewScanner(strings.NewReader(document))
+	var got []gemtext.Line
+	for scanner.Scan() {
+		got = append(got, scanner.Line())
+	}
+	if err := scanner.Err(); err != nil {
+		test.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		test.Fatalf("expected\n%+v\ngot\n%+v", expected, got)
+	}
+}
+
+func TestScanner_LongLine(test *testing.T) {
+	var long = strings.Repeat("x", 1<<20)
+	var scanner = gemtext.NewScanner(strings.NewReader("# Title\n" + long + "\r\n=> /next"))
+	var got []gemtext.Line
+	for scanner.Scan() {
+		got = append(got, scanner.Line())
+	}
+	if err := scanner.Err(); err != nil {
+		test.Fatalf("unexpected error: %v", err)
+	}
+	var expected = []gemtext.Line{
+		{Type: gemtext.Heading1, Text: "Title"},
+		{Type: gemtext.Text, Text: long},
+		{Type: gemtext.Link, URL: "/next"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		test.Fatalf("unexpected lines: got %d lines", len(got))
+	}
+}
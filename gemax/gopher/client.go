@@ -0,0 +1,72 @@
+package gopher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	urlpkg "net/url"
+	"strconv"
+
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// Client is used to fetch gopher resources.
+// Empty client value can be considered as initialized.
+type Client struct {
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Response contains gopher server response.
+// It's shaped like gemax.Response: gopher has no status codes,
+// so Status is always status.Success and Meta is a media type
+// derived from the item type.
+type Response struct {
+	Status status.Code
+	Meta   string
+	Type   ItemType
+	io.ReadCloser
+}
+
+// Fetch gopher resource by URL in form of gopher://host[:port]/<type><selector>[%09<search>].
+func (client *Client) Fetch(ctx context.Context, url string) (*Response, error) {
+	var u, errParse = urlpkg.Parse(url)
+	if errParse != nil {
+		return nil, fmt.Errorf("parsing URL: %w", errParse)
+	}
+	if u.Scheme != "gopher" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	var port = u.Port()
+	if port == "" {
+		port = strconv.Itoa(DefaultPort)
+	}
+	var addr = net.JoinHostPort(u.Hostname(), port)
+	var conn, errDial = client.dial(ctx, addr)
+	if errDial != nil {
+		return nil, fmt.Errorf("connecting to the server %q: %w", addr, errDial)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	var itemType, selector = splitTypeSelector(u.Path)
+	if _, errWrite := io.WriteString(conn, selector+"\r\n"); errWrite != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("sending request: %w", errWrite)
+	}
+	return &Response{
+		Status:     status.Success,
+		Meta:       itemType.MediaType(selector),
+		Type:       itemType,
+		ReadCloser: conn,
+	}, nil
+}
+
+func (client *Client) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if client.Dial != nil {
+		return client.Dial(ctx, "tcp", addr)
+	}
+	var dialer = &net.Dialer{}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
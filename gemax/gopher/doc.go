@@ -0,0 +1,4 @@
+// Package gopher provides a Gopher protocol (RFC 1436) server and client.
+// Server serves file systems as gopher menus and converts gemtext index files
+// into menu items, so the same content can be published over both gemini and gopher.
+package gopher
@@ -0,0 +1,127 @@
+package gopher_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax/gopher"
+	"github.com/ninedraft/gemax/gemax/status"
+
+	"github.com/ninedraft/gemax/vend/tailscale.com/net/memnet"
+)
+
+func TestServer(test *testing.T) {
+	var fsys = fstest.MapFS{
+		"index.gmi": {Data: []byte(strings.Join([]string{
+			"# Capsule",
+			"=> blog/ Blog",
+			"=> cat.jpg",
+			"=> gemini://example.com Gemini",
+			"=> gopher://example.com:7070/0/about.txt About",
+		}, "\n"))},
+		"cat.jpg":       {Data: []byte("JPEG")},
+		"blog/post.gmi": {Data: []byte("# Post\n")},
+	}
+	var server = &gopher.Server{
+		Addr:               "example.org:70",
+		FS:                 fsys,
+		Logf:               test.Logf,
+		ReadRequestTimeout: 100 * time.Millisecond,
+	}
+	var listener = memnet.Listen(test.Name())
+	var ctx = test.Context()
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		var errServe = server.Serve(ctx, listener)
+		if !errors.Is(errServe, net.ErrClosed) {
+			test.Errorf("unexpected serve error: %v", errServe)
+		}
+	}()
+	defer func() {
+		_ = listener.Close()
+		<-done
+	}()
+
+	var client = &gopher.Client{
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return listener.Dial(ctx, network, test.Name())
+		},
+	}
+	var fetch = func(test *testing.T, url string) (*gopher.Response, string) {
+		test.Helper()
+		var resp, errFetch = client.Fetch(ctx, url)
+		if errFetch != nil {
+			test.Fatalf("unexpected fetch error: %v", errFetch)
+		}
+		defer func() { _ = resp.Close() }()
+		var data, errRead = io.ReadAll(resp)
+		if errRead != nil {
+			test.Fatalf("unexpected read error: %v", errRead)
+		}
+		return resp, string(data)
+	}
+
+	test.Run("index menu", func(test *testing.T) {
+		var resp, menu = fetch(test, "gopher://example.org/")
+		if resp.Status != status.Success || resp.Meta != gopher.MIMEMenu {
+			test.Fatalf("unexpected response %s %q", resp.Status, resp.Meta)
+		}
+		var expected = strings.Join([]string{
+			"iCapsule\t\tnull.host\t1",
+			"1Blog\t/blog\texample.org\t70",
+			"Icat.jpg\t/cat.jpg\texample.org\t70",
+			"hGemini\tURL:gemini://example.com\texample.org\t70",
+			"0About\t/about.txt\texample.com\t7070",
+			".",
+			"",
+		}, "\r\n")
+		if menu != expected {
+			test.Fatalf("expected menu\n%q\ngot\n%q", expected, menu)
+		}
+	})
+
+	test.Run("directory listing", func(test *testing.T) {
+		var _, menu = fetch(test, "gopher://example.org/1/blog")
+		if menu != "0post.gmi\t/blog/post.gmi\texample.org\t70\r\n.\r\n" {
+			test.Fatalf("unexpected menu %q", menu)
+		}
+	})
+
+	test.Run("file", func(test *testing.T) {
+		var resp, data = fetch(test, "gopher://example.org/0/blog/post.gmi")
+		if data != "# Post\n" {
+			test.Fatalf("unexpected file content %q", data)
+		}
+		if resp.Type != gopher.TypeFile {
+			test.Fatalf("unexpected item type %q", resp.Type)
+		}
+	})
+
+	test.Run("not found", func(test *testing.T) {
+		var _, menu = fetch(test, "gopher://example.org/0/missing")
+		if !strings.HasPrefix(menu, "3not found: /missing\t") {
+			test.Fatalf("unexpected error menu %q", menu)
+		}
+	})
+
+	test.Run("read timeout", func(test *testing.T) {
+		var conn, errDial = client.Dial(ctx, "tcp", "")
+		if errDial != nil {
+			test.Fatal(errDial)
+		}
+		defer func() { _ = conn.Close() }()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// client never sends a selector
+		var data, errRead = io.ReadAll(conn)
+		if errRead != nil || len(data) != 0 {
+			test.Fatalf("expected connection to be closed without response, got %q, %v", data, errRead)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package gopher
+
+import (
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ItemType is a gopher menu item type.
+type ItemType byte
+
+// Item types defined by RFC 1436 and common extensions.
+const (
+	TypeFile      ItemType = '0'
+	TypeDirectory ItemType = '1'
+	TypeError     ItemType = '3'
+	TypeSearch    ItemType = '7'
+	TypeBinary    ItemType = '9'
+	TypeGIF       ItemType = 'g'
+	TypeImage     ItemType = 'I'
+	TypeHTML      ItemType = 'h'
+	TypeInfo      ItemType = 'i'
+)
+
+// MIMEMenu is a media type of gopher menus.
+const MIMEMenu = "application/gopher-menu"
+
+// Item is a gopher menu line.
+type Item struct {
+	Type     ItemType
+	Display  string
+	Selector string
+	Host     string
+	Port     int
+}
+
+// String formats item as a menu line "<type><display>\t<selector>\t<host>\t<port>\r\n".
+func (item Item) String() string {
+	var display = menuSanitizer.Replace(item.Display)
+	var selector = menuSanitizer.Replace(item.Selector)
+	return string(item.Type) + display + "\t" + selector + "\t" + item.Host + "\t" + strconv.Itoa(item.Port) + "\r\n"
+}
+
+var menuSanitizer = strings.NewReplacer(
+	"\t", " ",
+	"\r", "",
+	"\n", " ",
+)
+
+// TypeByName guesses item type by file name extension.
+func TypeByName(name string) ItemType {
+	var ext = path.Ext(name)
+	switch ext {
+	case ".gmi", ".gemini", ".txt", ".md":
+		return TypeFile
+	case ".gif":
+		return TypeGIF
+	case ".html", ".htm":
+		return TypeHTML
+	}
+	var mediaType, _, _ = mime.ParseMediaType(mime.TypeByExtension(ext))
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return TypeFile
+	case strings.HasPrefix(mediaType, "image/"):
+		return TypeImage
+	default:
+		return TypeBinary
+	}
+}
+
+// MediaType returns a media type of the item content.
+// Selector is used to guess types of images and binaries.
+func (itemType ItemType) MediaType(selector string) string {
+	switch itemType {
+	case TypeDirectory, TypeSearch:
+		return MIMEMenu
+	case TypeFile, TypeError, TypeInfo:
+		return "text/plain; charset=utf-8"
+	case TypeGIF:
+		return "image/gif"
+	case TypeHTML:
+		return "text/html"
+	}
+	if mediaType := mime.TypeByExtension(path.Ext(selector)); mediaType != "" {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,96 @@
+package gopher
+
+import (
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ninedraft/gemax/gemax/gemtext"
+)
+
+// infoItem creates a non-selectable menu line.
+func infoItem(text string) Item {
+	return Item{Type: TypeInfo, Display: text, Host: "null.host", Port: 1}
+}
+
+// gemtextMenu converts gemtext document into gopher menu items.
+// Relative links are resolved against dir and typed by the file system entries.
+func (server *Server) gemtextMenu(re io.Reader, dir string) ([]Item, error) {
+	var items []Item
+	var scanner = gemtext.NewScanner(re)
+	for scanner.Scan() {
+		var line = scanner.Line()
+		switch line.Type {
+		case gemtext.PreformatToggle:
+			// skip
+		case gemtext.Link:
+			items = append(items, server.linkItem(line.URL, line.Text, dir))
+		case gemtext.ListItem:
+			items = append(items, infoItem("* "+line.Text))
+		case gemtext.Quote:
+			items = append(items, infoItem("> "+line.Text))
+		default:
+			items = append(items, infoItem(line.Text))
+		}
+	}
+	return items, scanner.Err()
+}
+
+// linkItem converts gemtext link into a menu item.
+func (server *Server) linkItem(link, display, dir string) Item {
+	if display == "" {
+		display = link
+	}
+	var u, errParse = url.Parse(link)
+	switch {
+	case errParse != nil:
+		return infoItem(display)
+	case u.Scheme == "gopher":
+		return gopherURLItem(u, display)
+	case u.Scheme != "" || u.Host != "":
+		return server.item(TypeHTML, display, "URL:"+link)
+	}
+
+	var selector = u.Path
+	if !path.IsAbs(selector) {
+		selector = path.Join("/", dir, selector)
+	}
+	var name = strings.TrimPrefix(path.Clean(selector), "/")
+	if name == "" {
+		name = "."
+	}
+	var itemType = TypeByName(name)
+	if info, err := fs.Stat(server.FS, name); err == nil && info.IsDir() {
+		itemType = TypeDirectory
+	}
+	return server.item(itemType, display, selector)
+}
+
+// gopherURLItem converts gopher://host[:port]/<type><selector> URL into a menu item.
+func gopherURLItem(u *url.URL, display string) Item {
+	var itemType, selector = splitTypeSelector(u.Path)
+	var port, errPort = strconv.Atoi(u.Port())
+	if errPort != nil {
+		port = DefaultPort
+	}
+	return Item{
+		Type:     itemType,
+		Display:  display,
+		Selector: selector,
+		Host:     u.Hostname(),
+		Port:     port,
+	}
+}
+
+// splitTypeSelector splits gopher URL path into item type and selector.
+// Empty path means the root menu.
+func splitTypeSelector(p string) (ItemType, string) {
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return TypeDirectory, ""
+	}
+	return ItemType(p[0]), p[1:]
+}
@@ -0,0 +1,216 @@
+package gopher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax/internal/connserver"
+)
+
+// DefaultPort is a standard gopher port.
+const DefaultPort = 70
+
+// MaxSelectorSize is the maximum request line size in bytes.
+const MaxSelectorSize = 1024
+
+// Server serves a file system as gopher menus.
+// It will search index.gmi and index.gemini in each directory
+// and convert them into menus, otherwise directory listing is served.
+type Server struct {
+	Addr string
+	// Hostname and Port are advertised in menu items.
+	// If empty, then they are derived from Addr.
+	Hostname string
+	Port     int
+	// The backend file system.
+	FS fs.FS
+	// Optional text logger.
+	Logf func(format string, args ...any)
+
+	// Maximum number of simultaneous connections served by Server.
+	//	0 - connserver.DefaultMaxConnections
+	//	<0 - no limitation
+	MaxConnections int
+	// Time limit of reading the selector line.
+	// Connections, which don't send a selector in time, are closed without response.
+	//	0 - connserver.DefaultReadRequestTimeout
+	//	<0 - no limitation
+	ReadRequestTimeout time.Duration
+
+	srv connserver.Server
+}
+
+// ListenAndServe starts a gopher server at server address.
+// It will block until context is canceled.
+// It respects the MaxConnections setting.
+func (server *Server) ListenAndServe(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	server.srv.MaxConnections = server.MaxConnections
+	var listener, errListen = server.srv.Listen(ctx, "tcp", server.Addr)
+	if errListen != nil {
+		return errListen
+	}
+	defer func() { _ = listener.Close() }()
+	return server.Serve(ctx, listener)
+}
+
+// Serve starts server on provided listener.
+// Serve will await all running handlers to end.
+func (server *Server) Serve(ctx context.Context, listener net.Listener) error {
+	var errServe = server.srv.Serve(ctx, listener, server.handle)
+	return fmt.Errorf("gopher server: %w", errServe)
+}
+
+// Stop closes all listeners and connections.
+func (server *Server) Stop() {
+	server.srv.Stop()
+}
+
+func (server *Server) handle(ctx context.Context, conn net.Conn) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	_ = conn.SetReadDeadline(connserver.ReadDeadline(ctx, server.ReadRequestTimeout))
+	var re = bufio.NewReaderSize(io.LimitReader(conn, MaxSelectorSize), MaxSelectorSize)
+	var line, errLine = re.ReadString('\n')
+	if errLine != nil {
+		server.logf("WARN: bad request: remote_addr=%s: %v", conn.RemoteAddr(), errLine)
+		return
+	}
+	// search queries are separated from selector with a tab
+	var selector, _, _ = strings.Cut(strings.TrimRight(line, "\r\n"), "\t")
+
+	var wr = bufio.NewWriter(conn)
+	defer func() { _ = wr.Flush() }()
+	server.serve(wr, selector)
+}
+
+func (server *Server) serve(wr io.Writer, selector string) {
+	server.logf("INFO: %q is requested", selector)
+	var name = strings.TrimPrefix(path.Clean("/"+selector), "/")
+	if name == "" {
+		name = "."
+	}
+	var info, errStat = fs.Stat(server.FS, name)
+	switch {
+	case errors.Is(errStat, fs.ErrNotExist):
+		server.logf("WARN: %s is not found", name)
+		server.writeMenu(wr, server.errorItem("not found: "+selector))
+	case errStat != nil:
+		server.logf("ERROR: serving %s: %v", name, errStat)
+		server.writeMenu(wr, server.errorItem("server error"))
+	case info.IsDir():
+		server.serveDir(wr, name)
+	default:
+		server.serveFile(wr, name)
+	}
+}
+
+func (server *Server) serveFile(wr io.Writer, name string) {
+	var file, errOpen = server.FS.Open(name)
+	if errOpen != nil {
+		server.logf("ERROR: serving file %s: %v", name, errOpen)
+		server.writeMenu(wr, server.errorItem("server error"))
+		return
+	}
+	defer func() { _ = file.Close() }()
+	if _, errCopy := io.Copy(wr, file); errCopy != nil {
+		server.logf("ERROR: serving file %s: %v", name, errCopy)
+	}
+}
+
+func (server *Server) serveDir(wr io.Writer, dir string) {
+	for _, index := range []string{"index.gmi", "index.gemini"} {
+		var file, errOpen = server.FS.Open(path.Join(dir, index))
+		if errOpen != nil {
+			continue
+		}
+		var items, errMenu = server.gemtextMenu(file, dir)
+		_ = file.Close()
+		if errMenu != nil {
+			server.logf("ERROR: serving dir %s: converting %s: %v", dir, index, errMenu)
+			server.writeMenu(wr, server.errorItem("server error"))
+			return
+		}
+		server.writeMenu(wr, items...)
+		return
+	}
+
+	var entries, errEntries = fs.ReadDir(server.FS, dir)
+	if errEntries != nil {
+		server.logf("ERROR: serving dir %s: reading dir content: %v", dir, errEntries)
+		server.writeMenu(wr, server.errorItem("server error"))
+		return
+	}
+	var items = make([]Item, 0, len(entries))
+	for _, entry := range entries {
+		var itemType = TypeByName(entry.Name())
+		if entry.IsDir() {
+			itemType = TypeDirectory
+		}
+		var selector = path.Join("/", dir, entry.Name())
+		items = append(items, server.item(itemType, entry.Name(), selector))
+	}
+	server.writeMenu(wr, items...)
+}
+
+// writeMenu writes menu items terminated by a period line.
+func (server *Server) writeMenu(wr io.Writer, items ...Item) {
+	for _, item := range items {
+		if _, err := io.WriteString(wr, item.String()); err != nil {
+			server.logf("ERROR: writing menu: %v", err)
+			return
+		}
+	}
+	_, _ = io.WriteString(wr, ".\r\n")
+}
+
+// item creates a menu item, which points to this server.
+func (server *Server) item(itemType ItemType, display, selector string) Item {
+	var host, port = server.hostPort()
+	return Item{
+		Type:     itemType,
+		Display:  display,
+		Selector: selector,
+		Host:     host,
+		Port:     port,
+	}
+}
+
+func (server *Server) errorItem(msg string) Item {
+	return Item{Type: TypeError, Display: msg, Host: "error.host", Port: 1}
+}
+
+func (server *Server) hostPort() (string, int) {
+	var host, port = server.Hostname, server.Port
+	var addrHost, addrPort, errSplit = net.SplitHostPort(server.Addr)
+	if host == "" && errSplit == nil {
+		host = addrHost
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if port == 0 && errSplit == nil {
+		port, _ = strconv.Atoi(addrPort)
+	}
+	if port == 0 {
+		port = DefaultPort
+	}
+	return host, port
+}
+
+func (server *Server) logf(format string, args ...any) {
+	if server.Logf != nil {
+		server.Logf(format, args...)
+	}
+}
@@ -0,0 +1,209 @@
+// Package connserver provides a connection serving loop shared by protocol servers.
+// It tracks connections and listeners, limits number of simultaneous connections
+// and implements immediate and graceful shutdown.
+package connserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/netutil"
+)
+
+// DefaultMaxConnections default number of maximum connections.
+const DefaultMaxConnections = 256
+
+// DefaultReadRequestTimeout is the default time limit of reading a request.
+const DefaultReadRequestTimeout = 10 * time.Second
+
+// ReadDeadline returns the earliest of the context deadline and the request read timeout.
+//
+//	0 - DefaultReadRequestTimeout
+//	<0 - context deadline only
+func ReadDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	return Deadline(ctx, timeout, DefaultReadRequestTimeout)
+}
+
+// Deadline returns the earliest of the context deadline and the timeout.
+//
+//	0 - defaultTimeout
+//	<0 - context deadline only
+func Deadline(ctx context.Context, timeout, defaultTimeout time.Duration) time.Time {
+	var deadline, _ = ctx.Deadline()
+	switch {
+	case timeout < 0:
+		return deadline
+	case timeout == 0:
+		timeout = defaultTimeout
+	}
+	var timeoutDeadline = time.Now().Add(timeout)
+	if !deadline.IsZero() && deadline.Before(timeoutDeadline) {
+		return deadline
+	}
+	return timeoutDeadline
+}
+
+// Server accepts connections and serves them in separate goroutines.
+type Server struct {
+	// Maximum number of simultaneous connections served by Server.
+	//	0 - DefaultMaxConnections
+	//	<0 - no limitation
+	MaxConnections int
+	// StopCause is the cause of handler context cancellation by Stop.
+	//	nil - context.Canceled
+	StopCause error
+
+	once      sync.Once
+	mu        sync.RWMutex
+	conns     map[*connTrack]struct{}
+	listeners map[net.Listener]struct{}
+}
+
+type connTrack struct {
+	c      net.Conn
+	cancel context.CancelCauseFunc
+}
+
+// shutdownPollInterval is the interval of checking active connections by Shutdown.
+const shutdownPollInterval = 10 * time.Millisecond
+
+func (server *Server) init() {
+	server.once.Do(func() {
+		server.conns = map[*connTrack]struct{}{}
+		server.listeners = map[net.Listener]struct{}{}
+	})
+}
+
+// Listen creates a new listener, which respects the MaxConnections setting.
+// Listener is closed then context is canceled.
+func (server *Server) Listen(ctx context.Context, network, addr string) (net.Listener, error) {
+	server.init()
+	var lc = net.ListenConfig{}
+	var listener, errListen = lc.Listen(ctx, network, addr)
+	if errListen != nil {
+		return nil, fmt.Errorf("creating listener: %w", errListen)
+	}
+	listener = server.Limit(listener)
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	return listener, nil
+}
+
+// Limit wraps listener, so it respects the MaxConnections setting.
+// Returned listener is closed by Stop and Shutdown.
+func (server *Server) Limit(listener net.Listener) net.Listener {
+	server.init()
+	if n := server.maxConnections(); n >= 0 {
+		listener = netutil.LimitListener(listener, n)
+	}
+	server.addListener(listener)
+	return listener
+}
+
+// Serve accepts connections from listener and calls handle for each of them in a new goroutine.
+// Connection is closed after handle returns. Handler context is canceled by Stop.
+// Serve will await all running handlers to end.
+func (server *Server) Serve(ctx context.Context, listener net.Listener, handle func(ctx context.Context, conn net.Conn)) error {
+	server.init()
+	server.addListener(listener)
+	var wg sync.WaitGroup
+	for {
+		var conn, errAccept = listener.Accept()
+		if errAccept != nil {
+			wg.Wait()
+			return errAccept
+		}
+		var ctx, cancel = context.WithCancelCause(ctx)
+		var track = server.addConn(conn, cancel)
+		wg.Go(func() {
+			defer cancel(nil)
+			defer server.removeConn(track)
+			defer func() { _ = conn.Close() }()
+			handle(ctx, conn)
+		})
+	}
+}
+
+// Stop closes all listeners and connections.
+// Contexts of running handlers are canceled with StopCause.
+func (server *Server) Stop() {
+	server.init()
+	server.closeListeners()
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	for track := range server.conns {
+		track.cancel(server.StopCause)
+		_ = track.c.Close()
+	}
+}
+
+// Shutdown closes all listeners and waits for open connections to be served.
+// If ctx is done before, then the remaining connections are closed as with Stop
+// and the context error is returned.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.init()
+	server.closeListeners()
+	var ticker = time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if server.activeConns() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			server.Stop()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (server *Server) closeListeners() {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	for listener := range server.listeners {
+		_ = listener.Close()
+	}
+}
+
+func (server *Server) activeConns() int {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return len(server.conns)
+}
+
+func (server *Server) maxConnections() int {
+	switch {
+	case server.MaxConnections > 0:
+		return server.MaxConnections
+	case server.MaxConnections == 0:
+		return DefaultMaxConnections
+	default:
+		return -1
+	}
+}
+
+func (server *Server) addConn(conn net.Conn, cancel context.CancelCauseFunc) *connTrack {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	var track = &connTrack{c: conn, cancel: cancel}
+	server.conns[track] = struct{}{}
+	return track
+}
+
+func (server *Server) removeConn(track *connTrack) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	delete(server.conns, track)
+}
+
+func (server *Server) addListener(listener net.Listener) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.listeners[listener] = struct{}{}
+}
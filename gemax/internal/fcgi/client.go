@@ -0,0 +1,626 @@
+package fcgi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxConns is the default limit of simultaneous connections to the application.
+const DefaultMaxConns = 4
+
+// DefaultMaxStreams is the number of requests multiplexed on a single connection,
+// if the application supports multiplexing, but doesn't report FCGI_MAX_REQS.
+const DefaultMaxStreams = 16
+
+const (
+	maxNegotiatedStreams = 256
+	negotiateTimeout     = time.Second
+	// abortTimeout limits writing of abort records, so an application,
+	// which doesn't read requests, can't block aborting.
+	abortTimeout   = 5 * time.Second
+	stdinChunkSize = 32 << 10
+	// maxResponseBuffer limits unread stdout of a single request.
+	// Reading from a non-multiplexed connection is paused, until the response is read.
+	// Requests on multiplexed connections are aborted instead, so they don't stall each other.
+	maxResponseBuffer = 256 << 10
+	// maxRequestIDs is the number of usable request IDs: zero is reserved for management records.
+	maxRequestIDs = 1<<16 - 1
+)
+
+var (
+	// ErrClosed means that the client is closed.
+	ErrClosed = errors.New("fastcgi: client is closed")
+	// ErrResponseOverflow means that a multiplexed request was aborted,
+	// because its response was not read fast enough.
+	ErrResponseOverflow = errors.New("fastcgi: response buffer overflow")
+
+	errAborted = errors.New("fastcgi: request is aborted")
+)
+
+// Client sends requests to a FastCGI application in the responder role.
+// Connections are kept open and reused. Several requests can be multiplexed
+// on a single connection, see MaxStreams.
+//
+// FastCGI has no per-request flow control, so responses of multiplexed requests
+// must be read promptly: a request, which has more than 256 KiB of unread output,
+// is aborted with ErrResponseOverflow, so it doesn't stall other requests on the connection.
+// Reading of a non-multiplexed connection is paused instead, until the response is read.
+//
+// Request stdin is sent in background, while the response is read, so applications
+// can write responses before they read the whole stdin.
+type Client struct {
+	// Dial opens a new connection to the application.
+	Dial func(ctx context.Context) (net.Conn, error)
+	// Maximum number of simultaneous connections.
+	//	0 - DefaultMaxConns
+	//	<0 - no limitation
+	MaxConns int
+	// Maximum number of simultaneous requests on a single connection.
+	//	0 - 1, requests are not multiplexed
+	//	<0 - negotiated with the application via FCGI_GET_VALUES
+	MaxStreams int
+
+	mu      sync.Mutex
+	conns   []*conn
+	dialing int
+	changed chan struct{}
+	closed  bool
+}
+
+// Do sends a request with provided params in form of "NAME=value".
+// Stdin content is sent in background, it may be nil. The request fails,
+// if stdin can't be sent. Application stderr is written to stderr, if it's not nil.
+// Writes to the connection are interrupted, when ctx is done.
+// Returned response must be closed.
+func (client *Client) Do(ctx context.Context, params []string, stdin io.Reader, stderr io.Writer) (*Response, error) {
+	var resp, errAcquire = client.acquire(ctx, stderr)
+	if errAcquire != nil {
+		return nil, errAcquire
+	}
+	if errBegin := resp.begin(params); errBegin != nil {
+		_ = resp.Close()
+		return nil, fmt.Errorf("fastcgi: sending request: %w", errBegin)
+	}
+	go func() {
+		if errStdin := resp.sendStdin(stdin); errStdin != nil {
+			_ = resp.abort(fmt.Errorf("fastcgi: sending request: %w", errStdin))
+		}
+	}()
+	return resp, nil
+}
+
+// Close closes all connections. Running requests are failed.
+func (client *Client) Close() error {
+	client.mu.Lock()
+	client.closed = true
+	var conns = client.conns
+	client.conns = nil
+	client.mu.Unlock()
+	for _, c := range conns {
+		_ = c.nc.Close()
+	}
+	return nil
+}
+
+func (client *Client) acquire(ctx context.Context, stderr io.Writer) (*Response, error) {
+	for {
+		client.mu.Lock()
+		if client.closed {
+			client.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if client.changed == nil {
+			client.changed = make(chan struct{})
+		}
+		if resp := client.freeStream(ctx, stderr); resp != nil {
+			client.mu.Unlock()
+			return resp, nil
+		}
+		if len(client.conns)+client.dialing < client.maxConns() {
+			client.dialing++
+			client.mu.Unlock()
+			if errDial := client.addConn(ctx); errDial != nil {
+				return nil, errDial
+			}
+			continue
+		}
+		var wait = client.changed
+		client.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// freeStream starts a new request on a connection, which has a free stream.
+// It returns nil, if all connections are busy. Must be called with client.mu held.
+func (client *Client) freeStream(ctx context.Context, stderr io.Writer) *Response {
+	for _, c := range client.conns {
+		if len(c.streams) < c.maxStreams && len(c.streams)+len(c.aborted) < maxRequestIDs {
+			return c.newStream(ctx, stderr)
+		}
+	}
+	return nil
+}
+
+// addConn dials a new connection. The caller must increase client.dialing before.
+func (client *Client) addConn(ctx context.Context) error {
+	var c, errDial = client.dial(ctx)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.dialing--
+	client.broadcast()
+	switch {
+	case errDial != nil:
+		return fmt.Errorf("fastcgi: connecting: %w", errDial)
+	case client.closed:
+		_ = c.nc.Close()
+		return ErrClosed
+	}
+	client.conns = append(client.conns, c)
+	return nil
+}
+
+// broadcast wakes up all requests waiting for a free stream.
+// Must be called with client.mu held.
+func (client *Client) broadcast() {
+	if client.changed != nil {
+		close(client.changed)
+	}
+	client.changed = make(chan struct{})
+}
+
+func (client *Client) maxConns() int {
+	switch {
+	case client.MaxConns > 0:
+		return client.MaxConns
+	case client.MaxConns == 0:
+		return DefaultMaxConns
+	default:
+		return math.MaxInt
+	}
+}
+
+func (client *Client) dial(ctx context.Context) (*conn, error) {
+	var nc, errDial = client.Dial(ctx)
+	if errDial != nil {
+		return nil, errDial
+	}
+	var c = &conn{
+		client:     client,
+		nc:         nc,
+		maxStreams: client.MaxStreams,
+		streams:    map[uint16]*Response{},
+		aborted:    map[uint16]struct{}{},
+		values:     make(chan map[string]string, 1),
+		dead:       make(chan struct{}),
+	}
+	go c.readLoop()
+	if c.maxStreams == 0 {
+		c.maxStreams = 1
+	}
+	if c.maxStreams < 0 {
+		var n, errNegotiate = c.negotiate(ctx)
+		if errNegotiate != nil {
+			_ = nc.Close()
+			return nil, errNegotiate
+		}
+		c.maxStreams = n
+	}
+	return c, nil
+}
+
+// conn is a persistent connection to the application.
+type conn struct {
+	client     *Client
+	nc         net.Conn
+	maxStreams int
+	values     chan map[string]string
+	dead       chan struct{}
+
+	wmu sync.Mutex
+
+	// guarded by client.mu
+	streams map[uint16]*Response
+	// IDs of aborted requests, which are not ended by the application yet.
+	// They don't occupy stream slots, but can't be reused.
+	aborted map[uint16]struct{}
+	nextID  uint16
+}
+
+// negotiate asks the application whether it can multiplex requests.
+// Applications, which don't answer in time, are considered as not multiplexing.
+func (c *conn) negotiate(ctx context.Context) (int, error) {
+	var errWrite = c.write(ctx, func(wr io.Writer) error {
+		return writeRecord(wr, typeGetValues, 0, encodeParams([]string{varMpxsConns + "=", varMaxReqs + "="}))
+	})
+	if errWrite != nil {
+		return 0, errWrite
+	}
+	var timer = time.NewTimer(negotiateTimeout)
+	defer timer.Stop()
+	select {
+	case values := <-c.values:
+		if values[varMpxsConns] != "1" {
+			return 1, nil
+		}
+		var n, errN = strconv.Atoi(values[varMaxReqs])
+		if errN != nil || n <= 0 {
+			return DefaultMaxStreams, nil
+		}
+		return min(n, maxNegotiatedStreams), nil
+	case <-timer.C:
+		return 1, nil
+	case <-c.dead:
+		return 0, io.ErrUnexpectedEOF
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// newStream registers a new request. Must be called with client.mu held.
+func (c *conn) newStream(ctx context.Context, stderr io.Writer) *Response {
+	for {
+		c.nextID++
+		var _, busy = c.streams[c.nextID]
+		var _, aborted = c.aborted[c.nextID]
+		if c.nextID != 0 && !busy && !aborted {
+			break
+		}
+	}
+	var sendCtx, cancelSend = context.WithCancelCause(ctx)
+	var resp = &Response{
+		conn:       c,
+		id:         c.nextID,
+		ctx:        ctx,
+		sendCtx:    sendCtx,
+		cancelSend: cancelSend,
+		stderr:     stderr,
+		notify:     make(chan struct{}, 1),
+		drained:    make(chan struct{}, 1),
+	}
+	c.streams[resp.id] = resp
+	return resp
+}
+
+// write writes records produced by fn. The write is interrupted, when ctx is done.
+// Failed writes close the connection, because partially written records corrupt the stream.
+func (c *conn) write(ctx context.Context, fn func(wr io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := fn(&buf); err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+	var deadline, _ = ctx.Deadline()
+	_ = c.nc.SetWriteDeadline(deadline)
+	var interrupted = make(chan struct{})
+	var stop = context.AfterFunc(ctx, func() {
+		defer close(interrupted)
+		_ = c.nc.SetWriteDeadline(time.Unix(1, 0))
+	})
+	var _, errWrite = c.nc.Write(buf.Bytes())
+	if !stop() {
+		<-interrupted
+	}
+	_ = c.nc.SetWriteDeadline(time.Time{})
+	if errWrite != nil {
+		_ = c.nc.Close()
+	}
+	return errWrite
+}
+
+func (c *conn) readLoop() {
+	var re = bufio.NewReader(c.nc)
+	var err error
+	for err == nil {
+		var rec record
+		rec, err = readRecord(re)
+		if err == nil {
+			err = c.dispatch(rec)
+		}
+	}
+	c.fail(err)
+}
+
+func (c *conn) dispatch(rec record) error {
+	if rec.Type == typeGetValuesResult {
+		return c.dispatchValues(rec.content)
+	}
+
+	var resp = c.stream(rec)
+	if resp == nil {
+		// aborted or unknown request
+		return nil
+	}
+
+	switch rec.Type {
+	case typeStdout:
+		resp.push(rec.content)
+	case typeStderr:
+		if resp.stderr != nil && len(rec.content) > 0 {
+			_, _ = resp.stderr.Write(rec.content)
+		}
+	case typeEndRequest:
+		var appStatus, protocolStatus, errEnd = parseEndRequest(rec.content)
+		if errEnd != nil {
+			resp.finish(errEnd, 0)
+			return errEnd
+		}
+		resp.finish(protocolError(protocolStatus), appStatus)
+	}
+	return nil
+}
+
+func (c *conn) dispatchValues(content []byte) error {
+	var values, errValues = decodeParams(content)
+	if errValues != nil {
+		return errValues
+	}
+	select {
+	case c.values <- values:
+	default:
+	}
+	return nil
+}
+
+// stream returns the request of the record. The request is removed after its last record.
+func (c *conn) stream(rec record) *Response {
+	var client = c.client
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	var resp = c.streams[rec.RequestID]
+	if rec.Type != typeEndRequest {
+		return resp
+	}
+	if resp != nil {
+		delete(c.streams, rec.RequestID)
+		client.broadcast()
+	}
+	delete(c.aborted, rec.RequestID)
+	return resp
+}
+
+// fail closes connection and fails all running requests.
+func (c *conn) fail(err error) {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	var client = c.client
+	client.mu.Lock()
+	for i, other := range client.conns {
+		if other == c {
+			client.conns = append(client.conns[:i], client.conns[i+1:]...)
+			break
+		}
+	}
+	var streams = c.streams
+	c.streams = map[uint16]*Response{}
+	c.aborted = map[uint16]struct{}{}
+	client.broadcast()
+	client.mu.Unlock()
+
+	close(c.dead)
+	_ = c.nc.Close()
+	for _, resp := range streams {
+		resp.finish(fmt.Errorf("fastcgi: connection: %w", err), 0)
+	}
+}
+
+func protocolError(protocolStatus uint8) error {
+	switch protocolStatus {
+	case statusRequestComplete:
+		return io.EOF
+	case statusCantMultiplex:
+		return ErrCantMultiplex
+	case statusOverloaded:
+		return ErrOverloaded
+	case statusUnknownRole:
+		return ErrUnknownRole
+	default:
+		return fmt.Errorf("%w: unknown protocol status %d", errMalformedRecord, protocolStatus)
+	}
+}
+
+// Response is a FastCGI application response.
+// It reads the application stdout.
+type Response struct {
+	conn *conn
+	id   uint16
+	ctx  context.Context
+	// sendCtx is canceled, when the response is finished, so stdin is not sent anymore
+	sendCtx    context.Context
+	cancelSend context.CancelCauseFunc
+	stderr     io.Writer
+	notify     chan struct{}
+	// drained is signaled, when buffered stdout is read or the response is closed
+	drained chan struct{}
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	done      bool
+	closed    bool
+	err       error
+	appStatus uint32
+}
+
+// begin sends the request start and params.
+func (resp *Response) begin(params []string) error {
+	var id = resp.id
+	return resp.conn.write(resp.sendCtx, func(wr io.Writer) error {
+		if err := writeRecord(wr, typeBeginRequest, id, beginRequestBody(roleResponder, flagKeepConn)); err != nil {
+			return err
+		}
+		if err := writeStream(wr, typeParams, id, encodeParams(params)); err != nil {
+			return err
+		}
+		return writeRecord(wr, typeParams, id, nil)
+	})
+}
+
+// sendStdin sends stdin content and the end of stdin stream.
+// It stops, when the response is finished.
+func (resp *Response) sendStdin(stdin io.Reader) error {
+	var c, id = resp.conn, resp.id
+	if stdin != nil {
+		var chunk = make([]byte, stdinChunkSize)
+		for {
+			var n, errRead = stdin.Read(chunk)
+			if n > 0 {
+				var errWrite = c.write(resp.sendCtx, func(wr io.Writer) error {
+					return writeStream(wr, typeStdin, id, chunk[:n])
+				})
+				if errWrite != nil {
+					return errWrite
+				}
+			}
+			if errors.Is(errRead, io.EOF) {
+				break
+			}
+			if errRead != nil {
+				return fmt.Errorf("reading stdin: %w", errRead)
+			}
+		}
+	}
+	return c.write(resp.sendCtx, func(wr io.Writer) error {
+		return writeRecord(wr, typeStdin, id, nil)
+	})
+}
+
+// Read reads the application stdout.
+// It returns io.EOF after the request is complete.
+func (resp *Response) Read(data []byte) (int, error) {
+	for {
+		resp.mu.Lock()
+		if resp.buf.Len() > 0 {
+			var n, _ = resp.buf.Read(data)
+			resp.mu.Unlock()
+			signal(resp.drained)
+			return n, nil
+		}
+		if resp.done {
+			var err = resp.err
+			resp.mu.Unlock()
+			return 0, err
+		}
+		resp.mu.Unlock()
+		select {
+		case <-resp.notify:
+		case <-resp.ctx.Done():
+			return 0, resp.ctx.Err()
+		}
+	}
+}
+
+// AppStatus returns the application exit status.
+// It's valid only after Read has returned io.EOF.
+func (resp *Response) AppStatus() uint32 {
+	resp.mu.Lock()
+	defer resp.mu.Unlock()
+	return resp.appStatus
+}
+
+// Close releases the response. Incomplete requests are aborted.
+func (resp *Response) Close() error {
+	resp.mu.Lock()
+	if resp.closed {
+		resp.mu.Unlock()
+		return nil
+	}
+	resp.closed = true
+	resp.buf = bytes.Buffer{}
+	resp.mu.Unlock()
+	signal(resp.drained)
+	return resp.abort(errAborted)
+}
+
+// push buffers stdout data. If the buffer is full and the connection is not multiplexed,
+// then push blocks the connection read loop until the response is read.
+// The request fails, if its context is done while waiting.
+// Multiplexed requests with full buffers are aborted, so other requests are not blocked.
+func (resp *Response) push(data []byte) {
+	for {
+		resp.mu.Lock()
+		if resp.closed || resp.done {
+			resp.mu.Unlock()
+			return
+		}
+		if resp.buf.Len() < maxResponseBuffer {
+			resp.buf.Write(data)
+			resp.mu.Unlock()
+			signal(resp.notify)
+			return
+		}
+		resp.mu.Unlock()
+
+		if resp.conn.maxStreams > 1 {
+			_ = resp.abort(ErrResponseOverflow)
+			return
+		}
+		select {
+		case <-resp.drained:
+		case <-resp.ctx.Done():
+			_ = resp.abort(fmt.Errorf("fastcgi: response is not read: %w", context.Cause(resp.ctx)))
+			return
+		}
+	}
+}
+
+// abort fails the request and asks the application to abort it.
+// The stream slot is released at once, while the request ID is kept
+// until the application ends the request.
+func (resp *Response) abort(err error) error {
+	resp.finish(err, 0)
+	var c = resp.conn
+	var client = c.client
+	client.mu.Lock()
+	var registered = c.streams[resp.id] == resp
+	if registered {
+		delete(c.streams, resp.id)
+		c.aborted[resp.id] = struct{}{}
+		client.broadcast()
+	}
+	client.mu.Unlock()
+	if !registered {
+		// the request is already ended, its ID may belong to another request
+		return nil
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	return c.write(ctx, func(wr io.Writer) error {
+		return writeRecord(wr, typeAbortRequest, resp.id, nil)
+	})
+}
+
+func (resp *Response) finish(err error, appStatus uint32) {
+	resp.mu.Lock()
+	if !resp.done {
+		resp.done = true
+		resp.err = err
+		resp.appStatus = appStatus
+	}
+	resp.mu.Unlock()
+	resp.cancelSend(err)
+	signal(resp.notify)
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,350 @@
+package fcgi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_Multiplexing(test *testing.T) {
+	var app = &testApp{
+		maxReqs: 8,
+		handle: func(_ context.Context, params map[string]string, stdout io.Writer) {
+			_, _ = fmt.Fprintf(stdout, "%s %s", params["NAME"], params["STDIN"])
+		},
+	}
+	var client = app.client(test, -1)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var name = fmt.Sprintf("req-%d", i)
+			var got, errDo = do(client, name, name+"-body")
+			if errDo != nil {
+				test.Errorf("%s: unexpected error: %v", name, errDo)
+				return
+			}
+			if expected := name + " " + name + "-body"; got != expected {
+				test.Errorf("expected %q, got %q", expected, got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := app.conns(); n != 1 {
+		test.Errorf("expected 1 connection, got %d", n)
+	}
+	if n := app.maxActive(); n < 2 || n > 8 {
+		test.Errorf("expected from 2 to 8 multiplexed requests, got %d", n)
+	}
+}
+
+func TestClient_NotMultiplexedByDefault(test *testing.T) {
+	var app = &testApp{
+		maxReqs: 8,
+		handle: func(_ context.Context, params map[string]string, stdout io.Writer) {
+			time.Sleep(time.Millisecond)
+			_, _ = io.WriteString(stdout, params["NAME"])
+		},
+	}
+	var client = app.client(test, 0)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, errDo := do(client, fmt.Sprint(i), ""); errDo != nil {
+				test.Errorf("unexpected error: %v", errDo)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := app.maxActive(); n != 1 {
+		test.Errorf("expected requests not to be multiplexed, got %d simultaneous requests", n)
+	}
+}
+
+func TestClient_Abort(test *testing.T) {
+	var release = make(chan struct{})
+	var app = &testApp{
+		maxReqs: 1,
+		handle: func(_ context.Context, params map[string]string, stdout io.Writer) {
+			if params["NAME"] == "stuck" {
+				// the application ignores aborts and never ends the request
+				<-release
+				return
+			}
+			_, _ = io.WriteString(stdout, params["NAME"])
+		},
+	}
+	defer close(release)
+	var client = app.client(test, -1)
+
+	var resp, errDo = client.Do(context.Background(), []string{"NAME=stuck"}, nil, nil)
+	if errDo != nil {
+		test.Fatal(errDo)
+	}
+	if errClose := resp.Close(); errClose != nil {
+		test.Fatal(errClose)
+	}
+	select {
+	case id := <-app.aborted:
+		if id != resp.id {
+			test.Fatalf("expected request %d to be aborted, got %d", resp.id, id)
+		}
+	case <-time.After(time.Second):
+		test.Fatal("request is not aborted")
+	}
+
+	// the slot of the aborted request is free, while its ID is not reused
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var next, errNext = client.Do(ctx, []string{"NAME=next"}, nil, nil)
+	if errNext != nil {
+		test.Fatalf("stream slot is not released: %v", errNext)
+	}
+	defer func() { _ = next.Close() }()
+	if next.id == resp.id {
+		test.Fatalf("ID %d of the aborted request is reused", next.id)
+	}
+	var body, errRead = io.ReadAll(next)
+	if errRead != nil || string(body) != "next" {
+		test.Fatalf("unexpected response %q, %v", body, errRead)
+	}
+}
+
+func TestClient_ResponseOverflow(test *testing.T) {
+	var app = &testApp{
+		maxReqs: 2,
+		handle: func(_ context.Context, params map[string]string, stdout io.Writer) {
+			if params["NAME"] == "large" {
+				_, _ = stdout.Write(bytes.Repeat([]byte("a"), 2*maxResponseBuffer))
+				return
+			}
+			_, _ = io.WriteString(stdout, params["NAME"])
+		},
+	}
+	var client = app.client(test, 2)
+
+	// the large response is never read
+	var large, errLarge = client.Do(context.Background(), []string{"NAME=large"}, nil, nil)
+	if errLarge != nil {
+		test.Fatal(errLarge)
+	}
+	defer func() { _ = large.Close() }()
+
+	var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var small, errSmall = client.Do(ctx, []string{"NAME=small"}, nil, nil)
+	if errSmall != nil {
+		test.Fatal(errSmall)
+	}
+	defer func() { _ = small.Close() }()
+	var body, errRead = io.ReadAll(small)
+	if errRead != nil || string(body) != "small" {
+		test.Fatalf("multiplexed request is stalled: %q, %v", body, errRead)
+	}
+
+	select {
+	case id := <-app.aborted:
+		if id != large.id {
+			test.Fatalf("expected request %d to be aborted, got %d", large.id, id)
+		}
+	case <-time.After(time.Second):
+		test.Fatal("overflowing request is not aborted")
+	}
+	var _, errOverflow = io.Copy(io.Discard, large)
+	if !errors.Is(errOverflow, ErrResponseOverflow) {
+		test.Fatalf("expected %v, got %v", ErrResponseOverflow, errOverflow)
+	}
+}
+
+func TestClient_ResponseBeforeStdin(test *testing.T) {
+	var stdin = bytes.Repeat([]byte("i"), 4*maxResponseBuffer)
+	var stdout = bytes.Repeat([]byte("o"), 4*maxResponseBuffer)
+	var client = &Client{
+		Dial: func(context.Context) (net.Conn, error) {
+			var clientConn, appConn = net.Pipe()
+			go func() {
+				defer func() { _ = appConn.Close() }()
+				var re = bufio.NewReader(appConn)
+				// the application responds before reading stdin
+				for {
+					var rec, errRead = readRecord(re)
+					if errRead != nil {
+						return
+					}
+					if rec.Type == typeParams && len(rec.content) == 0 {
+						break
+					}
+				}
+				_ = writeStream(appConn, typeStdout, 1, stdout)
+				var read int
+				for {
+					var rec, errRead = readRecord(re)
+					if errRead != nil {
+						return
+					}
+					read += len(rec.content)
+					if rec.Type == typeStdin && len(rec.content) == 0 {
+						break
+					}
+				}
+				_ = writeRecord(appConn, typeStdout, 1, nil)
+				if read != len(stdin) {
+					_ = writeRecord(appConn, typeStderr, 1, []byte("stdin is truncated"))
+				}
+				_ = writeRecord(appConn, typeEndRequest, 1, make([]byte, 8))
+			}()
+			return clientConn, nil
+		},
+	}
+	test.Cleanup(func() { _ = client.Close() })
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var stderr bytes.Buffer
+	var resp, errDo = client.Do(ctx, nil, bytes.NewReader(stdin), &stderr)
+	if errDo != nil {
+		test.Fatal(errDo)
+	}
+	defer func() { _ = resp.Close() }()
+	var body, errRead = io.ReadAll(resp)
+	if errRead != nil {
+		test.Fatal(errRead)
+	}
+	if !bytes.Equal(body, stdout) || stderr.Len() > 0 {
+		test.Fatalf("unexpected response: %d bytes, stderr %q", len(body), stderr.String())
+	}
+}
+
+func do(client *Client, name, stdin string) (string, error) {
+	var resp, errDo = client.Do(context.Background(), []string{"NAME=" + name}, bytes.NewBufferString(stdin), nil)
+	if errDo != nil {
+		return "", errDo
+	}
+	defer func() { _ = resp.Close() }()
+	var body, errRead = io.ReadAll(resp)
+	return string(body), errRead
+}
+
+// testApp is a multiplexing FastCGI responder.
+// Request stdin is passed to the handler as STDIN param.
+type testApp struct {
+	maxReqs int
+	handle  func(ctx context.Context, params map[string]string, stdout io.Writer)
+	aborted chan uint16
+
+	mu      sync.Mutex
+	nConns  int
+	active  int
+	maxSeen int
+}
+
+func (app *testApp) client(t *testing.T, maxStreams int) *Client {
+	app.aborted = make(chan uint16, 16)
+	var client = &Client{
+		MaxConns:   1,
+		MaxStreams: maxStreams,
+		Dial: func(context.Context) (net.Conn, error) {
+			var clientConn, appConn = net.Pipe()
+			app.mu.Lock()
+			app.nConns++
+			app.mu.Unlock()
+			go app.serve(appConn)
+			return clientConn, nil
+		},
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func (app *testApp) conns() int {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.nConns
+}
+
+func (app *testApp) maxActive() int {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.maxSeen
+}
+
+func (app *testApp) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	var wmu sync.Mutex
+	var write = func(typ recordType, id uint16, content []byte) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		_ = writeStream(conn, typ, id, content)
+		if len(content) == 0 {
+			_ = writeRecord(conn, typ, id, nil)
+		}
+	}
+	type request struct {
+		params bytes.Buffer
+		stdin  bytes.Buffer
+	}
+	var requests = map[uint16]*request{}
+	var re = bufio.NewReader(conn)
+	for {
+		var rec, errRead = readRecord(re)
+		if errRead != nil {
+			return
+		}
+		var req = requests[rec.RequestID]
+		switch rec.Type {
+		case typeGetValues:
+			write(typeGetValuesResult, 0, encodeParams([]string{
+				varMpxsConns + "=1",
+				fmt.Sprintf("%s=%d", varMaxReqs, app.maxReqs),
+			}))
+		case typeBeginRequest:
+			requests[rec.RequestID] = &request{}
+		case typeParams:
+			req.params.Write(rec.content)
+		case typeAbortRequest:
+			app.aborted <- rec.RequestID
+		case typeStdin:
+			if len(rec.content) > 0 {
+				req.stdin.Write(rec.content)
+				continue
+			}
+			delete(requests, rec.RequestID)
+			var params, _ = decodeParams(req.params.Bytes())
+			params["STDIN"] = req.stdin.String()
+			go app.respond(rec.RequestID, params, write)
+		}
+	}
+}
+
+func (app *testApp) respond(id uint16, params map[string]string, write func(typ recordType, id uint16, content []byte)) {
+	app.mu.Lock()
+	app.active++
+	app.maxSeen = max(app.maxSeen, app.active)
+	app.mu.Unlock()
+
+	var stdout bytes.Buffer
+	app.handle(context.Background(), params, &stdout)
+	if stdout.Len() > 0 {
+		write(typeStdout, id, stdout.Bytes())
+	}
+	write(typeStdout, id, nil)
+
+	app.mu.Lock()
+	app.active--
+	app.mu.Unlock()
+	write(typeEndRequest, id, make([]byte, 8))
+}
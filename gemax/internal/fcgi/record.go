@@ -0,0 +1,187 @@
+// Package fcgi provides a FastCGI responder client.
+// Requests are multiplexed on persistent connections, if the application supports it.
+// Specification: https://fastcgi-archives.github.io/FastCGI_Specification.html
+package fcgi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const version1 = 1
+
+type recordType uint8
+
+const (
+	typeBeginRequest    recordType = 1
+	typeAbortRequest    recordType = 2
+	typeEndRequest      recordType = 3
+	typeParams          recordType = 4
+	typeStdin           recordType = 5
+	typeStdout          recordType = 6
+	typeStderr          recordType = 7
+	typeGetValues       recordType = 9
+	typeGetValuesResult recordType = 10
+)
+
+const (
+	roleResponder = 1
+	flagKeepConn  = 1
+)
+
+// Protocol statuses of FCGI_END_REQUEST records.
+const (
+	statusRequestComplete = 0
+	statusCantMultiplex   = 1
+	statusOverloaded      = 2
+	statusUnknownRole     = 3
+)
+
+// Management variables.
+const (
+	varMaxReqs    = "FCGI_MAX_REQS"
+	varMpxsConns  = "FCGI_MPXS_CONNS"
+	maxContentLen = 65535
+	headerLen     = 8
+)
+
+var (
+	// ErrCantMultiplex means that the application rejected a multiplexed request.
+	ErrCantMultiplex = errors.New("fastcgi: application can't multiplex connections")
+	// ErrOverloaded means that the application is out of resources.
+	ErrOverloaded = errors.New("fastcgi: application is overloaded")
+	// ErrUnknownRole means that the application doesn't support the responder role.
+	ErrUnknownRole = errors.New("fastcgi: unknown role")
+
+	errMalformedRecord = errors.New("fastcgi: malformed record")
+)
+
+type header struct {
+	Version       uint8
+	Type          recordType
+	RequestID     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+type record struct {
+	header
+	content []byte
+}
+
+// writeRecord writes a single record. Content must not exceed maxContentLen bytes.
+func writeRecord(wr io.Writer, typ recordType, id uint16, content []byte) error {
+	var padding = uint8(-len(content) & 7)
+	var buf = make([]byte, headerLen, headerLen+len(content)+int(padding))
+	buf[0] = version1
+	buf[1] = byte(typ)
+	binary.BigEndian.PutUint16(buf[2:], id)
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(content)))
+	buf[6] = padding
+	buf = append(buf, content...)
+	buf = append(buf, make([]byte, padding)...)
+	var _, errWrite = wr.Write(buf)
+	return errWrite
+}
+
+// writeStream writes data as a sequence of records.
+// Stream is not terminated: an empty record must be written by caller.
+func writeStream(wr io.Writer, typ recordType, id uint16, data []byte) error {
+	for len(data) > 0 {
+		var n = min(len(data), maxContentLen)
+		if err := writeRecord(wr, typ, id, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func readRecord(re *bufio.Reader) (record, error) {
+	var rec record
+	if err := binary.Read(re, binary.BigEndian, &rec.header); err != nil {
+		return rec, err
+	}
+	if rec.Version != version1 {
+		return rec, fmt.Errorf("%w: unsupported version %d", errMalformedRecord, rec.Version)
+	}
+	var content = make([]byte, int(rec.ContentLength)+int(rec.PaddingLength))
+	if _, err := io.ReadFull(re, content); err != nil {
+		return rec, fmt.Errorf("%w: %w", errMalformedRecord, err)
+	}
+	rec.content = content[:rec.ContentLength]
+	return rec, nil
+}
+
+func beginRequestBody(role uint16, flags uint8) []byte {
+	var body = make([]byte, 8)
+	binary.BigEndian.PutUint16(body, role)
+	body[2] = flags
+	return body
+}
+
+// parseEndRequest returns application and protocol statuses.
+func parseEndRequest(content []byte) (uint32, uint8, error) {
+	if len(content) < 5 {
+		return 0, 0, fmt.Errorf("%w: short end request body", errMalformedRecord)
+	}
+	return binary.BigEndian.Uint32(content), content[4], nil
+}
+
+// encodeParams encodes name-value pairs. Pairs are expected in form of "NAME=value".
+func encodeParams(pairs []string) []byte {
+	var buf []byte
+	for _, pair := range pairs {
+		var name, value, _ = strings.Cut(pair, "=")
+		buf = appendLength(buf, len(name))
+		buf = appendLength(buf, len(value))
+		buf = append(buf, name...)
+		buf = append(buf, value...)
+	}
+	return buf
+}
+
+func appendLength(buf []byte, n int) []byte {
+	if n < 128 {
+		return append(buf, byte(n))
+	}
+	return binary.BigEndian.AppendUint32(buf, uint32(n)|1<<31)
+}
+
+func decodeParams(data []byte) (map[string]string, error) {
+	var params = map[string]string{}
+	for len(data) > 0 {
+		var nameLen, valueLen int
+		var ok bool
+		if nameLen, data, ok = readLength(data); !ok {
+			return nil, errMalformedRecord
+		}
+		if valueLen, data, ok = readLength(data); !ok {
+			return nil, errMalformedRecord
+		}
+		if len(data) < nameLen+valueLen {
+			return nil, errMalformedRecord
+		}
+		params[string(data[:nameLen])] = string(data[nameLen : nameLen+valueLen])
+		data = data[nameLen+valueLen:]
+	}
+	return params, nil
+}
+
+func readLength(data []byte) (int, []byte, bool) {
+	switch {
+	case len(data) == 0:
+		return 0, data, false
+	case data[0] < 128:
+		return int(data[0]), data[1:], true
+	case len(data) < 4:
+		return 0, data, false
+	default:
+		return int(binary.BigEndian.Uint32(data) &^ (1 << 31)), data[4:], true
+	}
+}
@@ -0,0 +1,97 @@
+package fcgi
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecord(test *testing.T) {
+	var tc = func(name string, content []byte) {
+		test.Run(name, func(test *testing.T) {
+			var buf bytes.Buffer
+			if err := writeRecord(&buf, typeStdout, 42, content); err != nil {
+				test.Fatal(err)
+			}
+			if buf.Len()%8 != 0 {
+				test.Fatalf("record must be padded to 8 bytes, got %d bytes", buf.Len())
+			}
+			var rec, errRead = readRecord(bufio.NewReader(&buf))
+			if errRead != nil {
+				test.Fatal(errRead)
+			}
+			if rec.Type != typeStdout || rec.RequestID != 42 || !bytes.Equal(rec.content, content) {
+				test.Fatalf("unexpected record %+v", rec)
+			}
+			if buf.Len() != 0 {
+				test.Fatalf("%d bytes are not read", buf.Len())
+			}
+		})
+	}
+	tc("empty", nil)
+	tc("padded", []byte("hello"))
+	tc("aligned", []byte("12345678"))
+	tc("max length", bytes.Repeat([]byte("a"), maxContentLen))
+}
+
+func TestRecord_Malformed(test *testing.T) {
+	var tc = func(name, data string) {
+		test.Run(name, func(test *testing.T) {
+			var _, errRead = readRecord(bufio.NewReader(strings.NewReader(data)))
+			if errRead == nil {
+				test.Fatal("error is expected")
+			}
+		})
+	}
+	tc("unsupported version", "\x02\x06\x00\x01\x00\x00\x00\x00")
+	tc("short content", "\x01\x06\x00\x01\x00\x10\x00\x00abc")
+	tc("short header", "\x01\x06")
+}
+
+func TestWriteStream(test *testing.T) {
+	var data = bytes.Repeat([]byte("0123456789"), 15000)
+	var buf bytes.Buffer
+	if err := writeStream(&buf, typeStdin, 1, data); err != nil {
+		test.Fatal(err)
+	}
+	var re = bufio.NewReader(&buf)
+	var got []byte
+	var records int
+	for buf.Len() > 0 || re.Buffered() > 0 {
+		var rec, errRead = readRecord(re)
+		if errRead != nil {
+			test.Fatal(errRead)
+		}
+		if len(rec.content) > maxContentLen {
+			test.Fatalf("record content is too long: %d bytes", len(rec.content))
+		}
+		got = append(got, rec.content...)
+		records++
+	}
+	if records != 3 || !bytes.Equal(got, data) {
+		test.Fatalf("unexpected stream: %d records, %d bytes", records, len(got))
+	}
+}
+
+func TestParams(test *testing.T) {
+	var long = strings.Repeat("x", 300)
+	var encoded = encodeParams([]string{"SHORT=value", "EMPTY=", "LONG=" + long, long + "=long name"})
+	var params, errDecode = decodeParams(encoded)
+	if errDecode != nil {
+		test.Fatal(errDecode)
+	}
+	var expected = map[string]string{"SHORT": "value", "EMPTY": "", "LONG": long, long: "long name"}
+	if len(params) != len(expected) {
+		test.Fatalf("expected %d params, got %d", len(expected), len(params))
+	}
+	for name, value := range expected {
+		if params[name] != value {
+			test.Errorf("%.10s: expected %.10q, got %.10q", name, value, params[name])
+		}
+	}
+
+	if _, err := decodeParams(encoded[:len(encoded)-1]); err == nil {
+		test.Fatal("error is expected for truncated params")
+	}
+}
@@ -0,0 +1,22 @@
+// Package header provides helpers shared by gemini-style response writers.
+package header
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrAlreadyClosed is returned by response writers, which are closed more than once.
+var ErrAlreadyClosed = errors.New("already closed")
+
+var metaSanitizer = strings.NewReplacer(
+	"\r\n", "\t",
+	"\n", "\t",
+	"\r", "\t",
+)
+
+// SanitizeMeta replaces line breaks in the response meta with tabs,
+// so the meta can't break the response header.
+func SanitizeMeta(meta string) string {
+	return metaSanitizer.Replace(meta)
+}
@@ -0,0 +1,31 @@
+// Package hostset provides matching of request URLs against expected server hosts.
+package hostset
+
+import "net/url"
+
+// Set contains expected server hosts.
+// Hosts can be specified with or without port.
+type Set map[string]struct{}
+
+// New creates a set of provided hosts.
+func New(hosts []string) Set {
+	var set = make(Set, len(hosts))
+	for _, host := range hosts {
+		set[host] = struct{}{}
+	}
+	return set
+}
+
+// Match reports whether the URL host is expected.
+// URLs without host never match, empty set matches any other URL.
+func (set Set) Match(u *url.URL) bool {
+	if u.Host == "" {
+		return false
+	}
+	if len(set) == 0 {
+		return true
+	}
+	var _, hostOk = set[u.Host]
+	var _, hostnameOk = set[u.Hostname()]
+	return hostOk || hostnameOk
+}
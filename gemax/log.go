@@ -0,0 +1,130 @@
+package gemax
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// logSink writes each log event both to a structured logger and to a printf-style logger.
+// Any of them can be nil.
+type logSink struct {
+	logger *slog.Logger
+	logf   func(format string, args ...any)
+}
+
+// logLine is a printf-style logger line of an event.
+// Events, which were logged with Logf before structured logging was added,
+// carry their original lines, so Logf output keeps its format.
+type logLine string
+
+func logfLine(format string, args ...any) logLine {
+	return logLine(fmt.Sprintf(format, args...))
+}
+
+// log writes an event with key-value attributes in the slog.Logger.Log form.
+// If the first argument is a logLine, then it's written to the printf-style logger as is
+// regardless of the level, otherwise the record is formatted by logfHandler.
+func (sink logSink) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	var line, hasLine = logLine(""), false
+	if len(args) > 0 {
+		line, hasLine = args[0].(logLine)
+	}
+	if hasLine {
+		args = args[1:]
+	}
+	if sink.logger != nil {
+		sink.logger.Log(ctx, level, msg, args...)
+	}
+	switch {
+	case sink.logf == nil:
+		return
+	case hasLine:
+		sink.logf("%s", line)
+	default:
+		slog.New(&logfHandler{logf: sink.logf}).Log(ctx, level, msg, args...)
+	}
+}
+
+// logfHandler formats records as "LEVEL: message key=value ..." lines for Logf loggers.
+// It keeps the ERRO, WARN and INFO prefixes of plain Logf lines and drops debug records.
+type logfHandler struct {
+	logf   func(format string, args ...any)
+	attrs  []slog.Attr
+	prefix string
+}
+
+var _ slog.Handler = new(logfHandler)
+
+func (handler *logfHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (handler *logfHandler) Handle(_ context.Context, record slog.Record) error {
+	var line strings.Builder
+	line.WriteString(logfLevel(record.Level))
+	line.WriteString(": ")
+	line.WriteString(record.Message)
+	for _, attr := range handler.attrs {
+		appendLogfAttr(&line, "", attr)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		appendLogfAttr(&line, handler.prefix, attr)
+		return true
+	})
+	handler.logf("%s", line.String())
+	return nil
+}
+
+func (handler *logfHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var clone = *handler
+	clone.attrs = make([]slog.Attr, 0, len(handler.attrs)+len(attrs))
+	clone.attrs = append(clone.attrs, handler.attrs...)
+	for _, attr := range attrs {
+		attr.Key = handler.prefix + attr.Key
+		clone.attrs = append(clone.attrs, attr)
+	}
+	return &clone
+}
+
+func (handler *logfHandler) WithGroup(name string) slog.Handler {
+	var clone = *handler
+	clone.prefix += name + "."
+	return &clone
+}
+
+func logfLevel(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "ERRO"
+	case level >= slog.LevelWarn:
+		return "WARN"
+	case level >= slog.LevelInfo:
+		return "INFO"
+	default:
+		return "DEBU"
+	}
+}
+
+func appendLogfAttr(line *strings.Builder, prefix string, attr slog.Attr) {
+	var value = attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		for _, nested := range value.Group() {
+			appendLogfAttr(line, prefix+attr.Key+".", nested)
+		}
+		return
+	}
+	if attr.Key == "" {
+		return
+	}
+	var text = value.String()
+	if text == "" || strings.ContainsAny(text, " \t\r\n\"=") {
+		text = strconv.Quote(text)
+	}
+	line.WriteByte(' ')
+	line.WriteString(prefix + attr.Key)
+	line.WriteByte('=')
+	line.WriteString(text)
+}
@@ -0,0 +1,36 @@
+package gemax
+
+import (
+	"time"
+
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// Metrics receives server events. Methods are called synchronously
+// from connection goroutines, so implementations must be fast and safe for concurrent use.
+// See package gemax/metrics for an implementation in Prometheus text format.
+type Metrics interface {
+	// ConnAccepted is called for each accepted connection.
+	ConnAccepted()
+	// ConnClosed is called after an accepted connection is closed.
+	ConnClosed()
+	// HandshakeFailed is called, when TLS handshake fails.
+	HandshakeFailed(err error)
+	// RequestParsed is called after the request line is read.
+	RequestParsed(req IncomingRequest)
+	// ResponseComplete is called after the response is closed.
+	// Duration is measured since the request is parsed.
+	// Request is nil for malformed requests. Code is 0, if no response header was sent.
+	ResponseComplete(req IncomingRequest, code status.Code, bytes int64, duration time.Duration)
+	// Panicked is called, when handler panics. ResponseComplete is called as well.
+	Panicked(req IncomingRequest, recovered any)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ConnAccepted()                                                       {}
+func (nopMetrics) ConnClosed()                                                         {}
+func (nopMetrics) HandshakeFailed(error)                                               {}
+func (nopMetrics) RequestParsed(IncomingRequest)                                       {}
+func (nopMetrics) ResponseComplete(IncomingRequest, status.Code, int64, time.Duration) {}
+func (nopMetrics) Panicked(IncomingRequest, any)                                       {}
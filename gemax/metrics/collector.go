@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// DefaultBuckets are the default upper bounds of request duration histogram buckets in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// ContentType of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector counts server events. It's safe for concurrent use.
+// Exported metrics:
+//
+//	gemax_connections_accepted_total - counter of accepted connections
+//	gemax_connections_active - gauge of open connections
+//	gemax_connections_max - gauge of MaxConnections
+//	gemax_handshake_failures_total - counter of failed TLS handshakes
+//	gemax_requests_in_flight - gauge of requests being served
+//	gemax_responses_total{status="20"} - counter of responses by status code
+//	gemax_response_bytes_total - counter of response body bytes
+//	gemax_handler_panics_total - counter of recovered handler panics
+//	gemax_request_duration_seconds - histogram of request durations
+type Collector struct {
+	// Connection limit of the server. It's exported to compare it with active connections.
+	//	<=0 - gemax_connections_max is not exported
+	MaxConnections int
+	// Upper bounds of request duration histogram buckets in seconds, sorted in increasing order.
+	// It must not be modified after the first request.
+	//	nil - DefaultBuckets
+	Buckets []float64
+
+	mu                sync.Mutex
+	accepted          uint64
+	active            int64
+	handshakeFailures uint64
+	inFlight          int64
+	responses         map[status.Code]uint64
+	bytes             uint64
+	panics            uint64
+	durationCounts    []uint64
+	durationSum       float64
+	durationCount     uint64
+}
+
+var (
+	_ gemax.Metrics = new(Collector)
+	_ http.Handler  = new(Collector)
+	_ io.WriterTo   = new(Collector)
+)
+
+// ConnAccepted implements gemax.Metrics.
+func (collector *Collector) ConnAccepted() {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.accepted++
+	collector.active++
+}
+
+// ConnClosed implements gemax.Metrics.
+func (collector *Collector) ConnClosed() {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.active--
+}
+
+// HandshakeFailed implements gemax.Metrics.
+func (collector *Collector) HandshakeFailed(error) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.handshakeFailures++
+}
+
+// RequestParsed implements gemax.Metrics.
+func (collector *Collector) RequestParsed(gemax.IncomingRequest) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.inFlight++
+}
+
+// ResponseComplete implements gemax.Metrics.
+func (collector *Collector) ResponseComplete(req gemax.IncomingRequest, code status.Code, bytes int64, duration time.Duration) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if collector.responses == nil {
+		collector.responses = map[status.Code]uint64{}
+	}
+	collector.responses[code]++
+	collector.bytes += uint64(max(bytes, 0))
+	if req == nil {
+		// malformed request, it was not counted as parsed
+		return
+	}
+	collector.inFlight--
+
+	var buckets = collector.buckets()
+	if collector.durationCounts == nil {
+		collector.durationCounts = make([]uint64, len(buckets))
+	}
+	var seconds = duration.Seconds()
+	if i, _ := slices.BinarySearch(buckets, seconds); i < len(buckets) {
+		collector.durationCounts[i]++
+	}
+	collector.durationSum += seconds
+	collector.durationCount++
+}
+
+// Panicked implements gemax.Metrics.
+func (collector *Collector) Panicked(gemax.IncomingRequest, any) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.panics++
+}
+
+// WriteTo writes metrics in the Prometheus text exposition format.
+func (collector *Collector) WriteTo(wr io.Writer) (int64, error) {
+	var buf = &bytes.Buffer{}
+	collector.render(buf)
+	return buf.WriteTo(wr)
+}
+
+// ServeHTTP serves metrics in the Prometheus text exposition format.
+func (collector *Collector) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", ContentType)
+	_, _ = collector.WriteTo(rw)
+}
+
+func (collector *Collector) render(buf *bytes.Buffer) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	var metric = func(name, typ, help string, value any) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+	}
+	metric("gemax_connections_accepted_total", "counter", "Total number of accepted connections.", collector.accepted)
+	metric("gemax_connections_active", "gauge", "Number of open connections.", collector.active)
+	if collector.MaxConnections > 0 {
+		metric("gemax_connections_max", "gauge", "Maximum number of simultaneous connections.", collector.MaxConnections)
+	}
+	metric("gemax_handshake_failures_total", "counter", "Total number of failed TLS handshakes.", collector.handshakeFailures)
+	metric("gemax_requests_in_flight", "gauge", "Number of requests being served.", collector.inFlight)
+
+	buf.WriteString("# HELP gemax_responses_total Total number of responses by status code.\n")
+	buf.WriteString("# TYPE gemax_responses_total counter\n")
+	var codes = make([]status.Code, 0, len(collector.responses))
+	for code := range collector.responses {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	for _, code := range codes {
+		fmt.Fprintf(buf, "gemax_responses_total{status=\"%d\"} %d\n", code, collector.responses[code])
+	}
+
+	metric("gemax_response_bytes_total", "counter", "Total number of response body bytes.", collector.bytes)
+	metric("gemax_handler_panics_total", "counter", "Total number of recovered handler panics.", collector.panics)
+
+	const duration = "gemax_request_duration_seconds"
+	buf.WriteString("# HELP " + duration + " Request handling duration in seconds.\n")
+	buf.WriteString("# TYPE " + duration + " histogram\n")
+	var cumulative uint64
+	for i, bound := range collector.buckets() {
+		if i < len(collector.durationCounts) {
+			cumulative += collector.durationCounts[i]
+		}
+		fmt.Fprintf(buf, "%s_bucket{le=\"%s\"} %d\n", duration, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(buf, "%s_bucket{le=\"+Inf\"} %d\n", duration, collector.durationCount)
+	fmt.Fprintf(buf, "%s_sum %s\n", duration, strconv.FormatFloat(collector.durationSum, 'g', -1, 64))
+	fmt.Fprintf(buf, "%s_count %d\n", duration, collector.durationCount)
+}
+
+func (collector *Collector) buckets() []float64 {
+	if collector.Buckets != nil {
+		return collector.Buckets
+	}
+	return DefaultBuckets
+}
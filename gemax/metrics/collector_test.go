@@ -0,0 +1,61 @@
+package metrics_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ninedraft/gemax/gemax/metrics"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+func TestCollector(test *testing.T) {
+	var collector = &metrics.Collector{MaxConnections: 8, Buckets: []float64{0.1, 1}}
+	collector.ConnAccepted()
+	collector.ConnAccepted()
+	collector.ConnAccepted()
+	collector.ConnClosed()
+	collector.HandshakeFailed(errors.New("bad certificate"))
+	collector.RequestParsed(nil)
+	collector.ResponseComplete(nil, status.BadRequest, 0, 0)
+
+	var expected = `# HELP gemax_connections_accepted_total Total number of accepted connections.
+# TYPE gemax_connections_accepted_total counter
+gemax_connections_accepted_total 3
+# HELP gemax_connections_active Number of open connections.
+# TYPE gemax_connections_active gauge
+gemax_connections_active 2
+# HELP gemax_connections_max Maximum number of simultaneous connections.
+# TYPE gemax_connections_max gauge
+gemax_connections_max 8
+# HELP gemax_handshake_failures_total Total number of failed TLS handshakes.
+# TYPE gemax_handshake_failures_total counter
+gemax_handshake_failures_total 1
+# HELP gemax_requests_in_flight Number of requests being served.
+# TYPE gemax_requests_in_flight gauge
+gemax_requests_in_flight 1
+# HELP gemax_responses_total Total number of responses by status code.
+# TYPE gemax_responses_total counter
+gemax_responses_total{status="59"} 1
+# HELP gemax_response_bytes_total Total number of response body bytes.
+# TYPE gemax_response_bytes_total counter
+gemax_response_bytes_total 0
+# HELP gemax_handler_panics_total Total number of recovered handler panics.
+# TYPE gemax_handler_panics_total counter
+gemax_handler_panics_total 0
+# HELP gemax_request_duration_seconds Request handling duration in seconds.
+# TYPE gemax_request_duration_seconds histogram
+gemax_request_duration_seconds_bucket{le="0.1"} 0
+gemax_request_duration_seconds_bucket{le="1"} 0
+gemax_request_duration_seconds_bucket{le="+Inf"} 0
+gemax_request_duration_seconds_sum 0
+gemax_request_duration_seconds_count 0
+`
+	var got = &strings.Builder{}
+	if _, err := collector.WriteTo(got); err != nil {
+		test.Fatal(err)
+	}
+	if got.String() != expected {
+		test.Errorf("unexpected output:\n%s", got)
+	}
+}
@@ -0,0 +1,10 @@
+// Package metrics implements gemax.Metrics, which renders server metrics
+// in the Prometheus text exposition format. It has no dependencies,
+// so the metrics can be served by any HTTP server:
+//
+//	var collector = &metrics.Collector{MaxConnections: gemax.DefaultMaxConnections}
+//	var server = &gemax.Server{Metrics: collector, ...}
+//	http.Handle("/metrics", collector)
+//
+// Format specification: https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
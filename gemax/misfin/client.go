@@ -0,0 +1,83 @@
+package misfin
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// ErrMessageTooLarge means that the message doesn't fit into MaxRequestSize.
+var ErrMessageTooLarge = errors.New("message is too large")
+
+// Client sends misfin messages.
+type Client struct {
+	// Certificate is the sender identity.
+	// Its subject UID, DNS name and common name describe the sender, see IdentityFromCertificate.
+	Certificate tls.Certificate
+	Dial        func(ctx context.Context, host string, cfg *tls.Config) (net.Conn, error)
+}
+
+// Response contains parsed server response.
+// On success Meta contains the recipient certificate fingerprint.
+type Response struct {
+	Status status.Code
+	Meta   string
+}
+
+// Send delivers message to the recipient mailbox.
+func (client *Client) Send(ctx context.Context, to, message string) (*Response, error) {
+	var addr, errAddr = ParseAddress(to)
+	if errAddr != nil {
+		return nil, errAddr
+	}
+	var request = "misfin://" + addr.String() + " " + message + "\r\n"
+	if len(request) > MaxRequestSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	var host = net.JoinHostPort(addr.Host, strconv.Itoa(DefaultPort))
+	var conn, errDial = client.dial(ctx, host, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{client.Certificate},
+		ServerName:   addr.Host,
+		//nolint:gosec // misfin servers usually use self-signed certificates, server domain is verified below
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return gemax.VerifyDomain(&cs, addr.Host)
+		},
+	})
+	if errDial != nil {
+		return nil, fmt.Errorf("connecting to the server %q: %w", host, errDial)
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, errWrite := conn.Write([]byte(request)); errWrite != nil {
+		return nil, fmt.Errorf("sending request: %w", errWrite)
+	}
+	var code, meta, errHeader = gemax.ParseResponseHeader(bufio.NewReader(conn))
+	if errHeader != nil {
+		return nil, errHeader
+	}
+	return &Response{Status: code, Meta: meta}, nil
+}
+
+func (client *Client) dial(ctx context.Context, host string, cfg *tls.Config) (net.Conn, error) {
+	if client.Dial != nil {
+		return client.Dial(ctx, host, cfg)
+	}
+	var tlsDialer = &tls.Dialer{
+		NetDialer: &net.Dialer{},
+		Config:    cfg,
+	}
+	return tlsDialer.DialContext(ctx, "tcp", host)
+}
@@ -0,0 +1,5 @@
+// Package misfin provides a Misfin mail protocol server and client.
+// Misfin is gemini-style mail over TLS, where client certificates
+// are used as sender identities.
+// Misfin specification: gemini://misfin.org/specification.gmi
+package misfin
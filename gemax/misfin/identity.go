@@ -0,0 +1,77 @@
+package misfin
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax"
+)
+
+// DefaultPort is a standard misfin port.
+const DefaultPort = 1958
+
+// ErrInvalidAddress means that mailbox address is malformed.
+var ErrInvalidAddress = errors.New("invalid mailbox address")
+
+// ErrInvalidIdentity means that the certificate doesn't describe a misfin identity.
+var ErrInvalidIdentity = errors.New("invalid misfin identity certificate")
+
+// oidUserID is a certificate subject attribute holding the mailbox name.
+var oidUserID = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}
+
+// Address is a misfin mailbox address in form of mailbox@host.
+type Address struct {
+	Mailbox string
+	Host    string
+}
+
+// ParseAddress parses mailbox address in form of mailbox@host.
+func ParseAddress(addr string) (Address, error) {
+	var mailbox, host, ok = strings.Cut(addr, "@")
+	if !ok || mailbox == "" || host == "" || strings.ContainsAny(addr, " \t\r\n") {
+		return Address{}, fmt.Errorf("%w: %q", ErrInvalidAddress, addr)
+	}
+	return Address{Mailbox: mailbox, Host: host}, nil
+}
+
+func (addr Address) String() string {
+	return addr.Mailbox + "@" + addr.Host
+}
+
+// Identity describes a misfin sender or recipient.
+type Identity struct {
+	Address Address
+	// Name is a display name.
+	Name string
+	// Fingerprint is a hex encoded SHA256 hash of the identity certificate.
+	Fingerprint string
+}
+
+// IdentityFromCertificate extracts misfin identity from a certificate.
+// Certificate subject UID is used as mailbox, the first DNS name is used as host
+// and common name is used as display name.
+func IdentityFromCertificate(cert *x509.Certificate) (Identity, error) {
+	var mailbox string
+	for _, name := range cert.Subject.Names {
+		if value, ok := name.Value.(string); ok && name.Type.Equal(oidUserID) {
+			mailbox = value
+		}
+	}
+	if mailbox == "" || len(cert.DNSNames) == 0 {
+		return Identity{}, fmt.Errorf("%w: missing UID or DNS name", ErrInvalidIdentity)
+	}
+	return Identity{
+		Address:     Address{Mailbox: mailbox, Host: cert.DNSNames[0]},
+		Name:        cert.Subject.CommonName,
+		Fingerprint: gemax.CertificateFingerprint(cert),
+	}, nil
+}
+
+func validNow(cert *x509.Certificate) bool {
+	var now = time.Now()
+	return now.After(cert.NotBefore) && now.Before(cert.NotAfter)
+}
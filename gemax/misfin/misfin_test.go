@@ -0,0 +1,198 @@
+package misfin_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/misfin"
+	"github.com/ninedraft/gemax/gemax/status"
+
+	"github.com/ninedraft/gemax/vend/tailscale.com/net/memnet"
+)
+
+func TestServer(test *testing.T) {
+	var store = &misfin.MemoryStore{}
+	store.AddMailbox("alice")
+	var server = &misfin.Server{
+		Hosts:              []string{"example.com"},
+		Store:              store,
+		Fingerprint:        "server-fingerprint",
+		Logf:               test.Logf,
+		HandshakeTimeout:   100 * time.Millisecond,
+		ReadRequestTimeout: 100 * time.Millisecond,
+	}
+	var listener = memnet.Listen(test.Name())
+	var serverCerts = map[string]tls.Certificate{
+		"example.com": identityCert(test, "server", "example.com", "Server"),
+		"other.com":   identityCert(test, "server", "other.com", "Server"),
+	}
+	var tlsListener = tls.NewListener(listener, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			var cert, ok = serverCerts[hello.ServerName]
+			if !ok {
+				cert = serverCerts["example.com"]
+			}
+			return &cert, nil
+		},
+		ClientAuth: tls.RequestClientCert,
+	})
+	var ctx = test.Context()
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		var errServe = server.Serve(ctx, tlsListener)
+		if !errors.Is(errServe, net.ErrClosed) {
+			test.Errorf("unexpected serve error: %v", errServe)
+		}
+	}()
+	defer func() {
+		_ = tlsListener.Close()
+		<-done
+	}()
+
+	var dial = func(ctx context.Context, _ string, cfg *tls.Config) (net.Conn, error) {
+		var conn, errDial = listener.Dial(ctx, "tcp", test.Name())
+		if errDial != nil {
+			return nil, errDial
+		}
+		return tls.Client(conn, cfg), nil
+	}
+
+	var tc = func(name string, client *misfin.Client, to string, expected status.Code) {
+		test.Run(name, func(test *testing.T) {
+			var resp, errSend = client.Send(ctx, to, "# Hello\nhow are you?")
+			if errSend != nil {
+				test.Fatalf("unexpected send error: %v", errSend)
+			}
+			if resp.Status != expected {
+				test.Fatalf("expected %s, got %s %q", expected, resp.Status, resp.Meta)
+			}
+		})
+	}
+	var bob = &misfin.Client{
+		Certificate: identityCert(test, "bob", "bob.net", "Bob"),
+		Dial:        dial,
+	}
+	tc("delivered", bob, "alice@example.com", status.Success)
+	tc("unknown mailbox", bob, "carol@example.com", status.NotFound)
+	tc("unknown domain", bob, "alice@other.com", misfin.DomainNotServiced)
+	tc("no certificate", &misfin.Client{Dial: dial}, "alice@example.com", status.ClientCertificateRequired)
+	tc("not an identity", &misfin.Client{
+		Certificate: identityCert(test, "", "", "Anonymous"),
+		Dial:        dial,
+	}, "alice@example.com", status.ClientCertificateNotValid)
+
+	var expectClosed = func(test *testing.T, conn net.Conn) {
+		test.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var data, errRead = io.ReadAll(conn)
+		if len(data) != 0 || errors.Is(errRead, os.ErrDeadlineExceeded) {
+			test.Fatalf("expected connection to be closed without response, got %q, %v", data, errRead)
+		}
+	}
+	test.Run("handshake timeout", func(test *testing.T) {
+		var conn, errDial = listener.Dial(ctx, "tcp", listener.Addr().String())
+		if errDial != nil {
+			test.Fatal(errDial)
+		}
+		defer func() { _ = conn.Close() }()
+		// client never sends ClientHello
+		expectClosed(test, conn)
+	})
+	test.Run("read timeout", func(test *testing.T) {
+		var conn, errDial = dial(ctx, "", &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			//nolint:gosec // test server uses self-signed certificate
+			InsecureSkipVerify: true,
+		})
+		if errDial != nil {
+			test.Fatal(errDial)
+		}
+		defer func() { _ = conn.Close() }()
+		if err := conn.(*tls.Conn).HandshakeContext(ctx); err != nil {
+			test.Fatal(err)
+		}
+		// client never sends a request
+		expectClosed(test, conn)
+	})
+
+	test.Run("wrong server certificate", func(test *testing.T) {
+		var _, errSend = bob.Send(ctx, "alice@evil.com", "hello")
+		if !errors.Is(errSend, gemax.ErrInvalidServerName) {
+			test.Fatalf("expected %v, got %v", gemax.ErrInvalidServerName, errSend)
+		}
+	})
+
+	var messages = store.Messages("alice")
+	if len(messages) != 1 {
+		test.Fatalf("expected 1 message, got %d", len(messages))
+	}
+	var msg = messages[0]
+	if msg.From.Address.String() != "bob@bob.net" || msg.From.Name != "Bob" {
+		test.Errorf("unexpected sender %+v", msg.From)
+	}
+	if msg.Body != "# Hello\nhow are you?" {
+		test.Errorf("unexpected message body %q", msg.Body)
+	}
+}
+
+func TestParseAddress(test *testing.T) {
+	var addr, errParse = misfin.ParseAddress("alice@example.com")
+	if errParse != nil {
+		test.Fatalf("unexpected error: %v", errParse)
+	}
+	if addr.Mailbox != "alice" || addr.Host != "example.com" {
+		test.Fatalf("unexpected address %+v", addr)
+	}
+	for _, invalid := range []string{"", "alice", "@example.com", "alice@", "al ice@example.com"} {
+		if _, err := misfin.ParseAddress(invalid); !errors.Is(err, misfin.ErrInvalidAddress) {
+			test.Errorf("%q: expected %v, got %v", invalid, misfin.ErrInvalidAddress, err)
+		}
+	}
+}
+
+func identityCert(t *testing.T, mailbox, host, name string) tls.Certificate {
+	t.Helper()
+	var key, errKey = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if errKey != nil {
+		t.Fatal(errKey)
+	}
+	var subject = pkix.Name{CommonName: name}
+	if mailbox != "" {
+		subject.ExtraNames = []pkix.AttributeTypeAndValue{{
+			Type:  asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1},
+			Value: mailbox,
+		}}
+	}
+	var template = &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if host != "" {
+		template.DNSNames = []string{host}
+	}
+	var der, errCert = x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if errCert != nil {
+		t.Fatal(errCert)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
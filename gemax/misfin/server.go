@@ -0,0 +1,240 @@
+package misfin
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/internal/connserver"
+	"github.com/ninedraft/gemax/gemax/internal/hostset"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// MaxRequestSize is the maximum request size in bytes, including the message.
+const MaxRequestSize = 2048
+
+// Misfin specific status codes. Other codes are shared with gemini, see package status.
+const (
+	// MailboxFull means that the mailbox can't accept messages now.
+	MailboxFull status.Code = 45
+	// DomainNotServiced means that the server doesn't serve mailboxes of requested host.
+	DomainNotServiced status.Code = 53
+)
+
+// Server is a misfin mail server.
+// It accepts "misfin://mailbox@host message\r\n" requests, verifies sender
+// certificates and delivers messages to the Store.
+//
+// Sender certificates are only checked to be currently valid and to contain
+// a misfin identity, see IdentityFromCertificate. The identity is not verified
+// against the sender host or previously seen certificates, so stores, which
+// need it, should check Message.From themselves.
+type Server struct {
+	Addr string
+	// Hosts served by server.
+	// If empty, then every host will be valid.
+	Hosts []string
+	Store Store
+	// Fingerprint of the server certificate reported to senders on success.
+	// If empty, then ListenAndServe computes it from the TLS config.
+	Fingerprint string
+	// Optional text logger.
+	Logf func(format string, args ...any)
+
+	// Maximum number of simultaneous connections served by Server.
+	//	0 - connserver.DefaultMaxConnections
+	//	<0 - no limitation
+	MaxConnections int
+	// Time limit of TLS handshake.
+	//	0 - gemax.DefaultHandshakeTimeout
+	//	<0 - no limitation
+	HandshakeTimeout time.Duration
+	// Time limit of reading request after the handshake.
+	// Connections, which don't send a request in time, are closed without response.
+	//	0 - connserver.DefaultReadRequestTimeout
+	//	<0 - no limitation
+	ReadRequestTimeout time.Duration
+
+	srv   connserver.Server
+	once  sync.Once
+	hosts hostset.Set
+}
+
+// ListenAndServe starts a TLS misfin server at server address.
+// Client certificates are requested, but verified by the server itself,
+// so senders without certificates get a proper status response.
+// It will block until context is canceled.
+func (server *Server) ListenAndServe(ctx context.Context, tlsCfg *tls.Config) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tlsCfg = tlsCfg.Clone()
+	if tlsCfg.ClientAuth == tls.NoClientCert {
+		tlsCfg.ClientAuth = tls.RequestClientCert
+	}
+	if server.Fingerprint == "" && len(tlsCfg.Certificates) > 0 {
+		if cert, err := x509.ParseCertificate(tlsCfg.Certificates[0].Certificate[0]); err == nil {
+			server.Fingerprint = gemax.CertificateFingerprint(cert)
+		}
+	}
+	server.srv.MaxConnections = server.MaxConnections
+	var listener, errListen = server.srv.Listen(ctx, "tcp", server.Addr)
+	if errListen != nil {
+		return errListen
+	}
+	var tlsListener = tls.NewListener(listener, tlsCfg)
+	defer func() { _ = tlsListener.Close() }()
+	return server.Serve(ctx, tlsListener)
+}
+
+// Serve starts server on provided TLS listener.
+// Serve will await all running handlers to end.
+func (server *Server) Serve(ctx context.Context, listener net.Listener) error {
+	var errServe = server.srv.Serve(ctx, listener, server.handle)
+	return fmt.Errorf("misfin server: %w", errServe)
+}
+
+// Stop closes all listeners and connections.
+func (server *Server) Stop() {
+	server.srv.Stop()
+}
+
+func (server *Server) handle(ctx context.Context, conn net.Conn) {
+	if !server.handshake(ctx, conn) {
+		return
+	}
+	var code, meta = server.receive(ctx, conn)
+	if code == status.Undefined {
+		return
+	}
+	_, _ = fmt.Fprintf(conn, "%d %s\r\n", code, meta)
+}
+
+// handshake performs TLS handshake within HandshakeTimeout. Returns false if it fails.
+func (server *Server) handshake(ctx context.Context, conn net.Conn) bool {
+	var tlsConn, isTLS = conn.(*tls.Conn)
+	if !isTLS {
+		return true
+	}
+	_ = conn.SetDeadline(connserver.Deadline(ctx, server.HandshakeTimeout, gemax.DefaultHandshakeTimeout))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		server.logf("WARN: handshake with %q failed: %v", conn.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
+
+// receive reads, verifies and delivers a message. Returns response status and meta.
+func (server *Server) receive(ctx context.Context, conn net.Conn) (status.Code, string) {
+	var deadline, _ = ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+	_ = conn.SetReadDeadline(connserver.ReadDeadline(ctx, server.ReadRequestTimeout))
+	var to, body, errRequest = readRequest(bufio.NewReaderSize(conn, MaxRequestSize))
+	if errors.Is(errRequest, os.ErrDeadlineExceeded) {
+		server.logf("WARN: reading request: remote_addr=%s: timeout", conn.RemoteAddr())
+		return status.Undefined, ""
+	}
+	_ = conn.SetReadDeadline(deadline)
+	if errRequest != nil {
+		server.logf("WARN: bad request: remote_addr=%s: %v", conn.RemoteAddr(), errRequest)
+		return status.BadRequest, "bad request"
+	}
+	if !server.validHost(to.Host) {
+		return DomainNotServiced, "domain not serviced"
+	}
+
+	var sender, code, meta = senderIdentity(conn)
+	if code != status.Success {
+		return code, meta
+	}
+	return server.deliver(ctx, &Message{
+		To:       to,
+		From:     sender,
+		Body:     body,
+		Received: time.Now(),
+	})
+}
+
+// senderIdentity verifies the sender certificate.
+// Returns status.Success or the failure status and meta.
+func senderIdentity(conn net.Conn) (Identity, status.Code, string) {
+	var certs []*x509.Certificate
+	if tlsConn, isTLS := conn.(*tls.Conn); isTLS {
+		certs = tlsConn.ConnectionState().PeerCertificates
+	}
+	if len(certs) == 0 {
+		return Identity{}, status.ClientCertificateRequired, "sender certificate is required"
+	}
+	if !validNow(certs[0]) {
+		return Identity{}, status.ClientCertificateNotValid, "sender certificate is expired or not yet valid"
+	}
+	var sender, errIdentity = IdentityFromCertificate(certs[0])
+	if errIdentity != nil {
+		return Identity{}, status.ClientCertificateNotValid, errIdentity.Error()
+	}
+	return sender, status.Success, ""
+}
+
+// deliver stores the message and maps store errors to response status and meta.
+func (server *Server) deliver(ctx context.Context, msg *Message) (status.Code, string) {
+	var errDeliver = server.Store.Deliver(ctx, msg)
+	switch {
+	case errDeliver == nil:
+		server.logf("INFO: message from %s to %s is delivered", msg.From.Address, msg.To)
+		return status.Success, server.Fingerprint
+	case errors.Is(errDeliver, ErrMailboxNotFound):
+		return status.NotFound, "mailbox not found"
+	case errors.Is(errDeliver, ErrMailboxFull):
+		return MailboxFull, "mailbox is full"
+	case errors.Is(errDeliver, ErrSenderNotAuthorized):
+		return status.CertificateNotAuthorized, "sender is not authorized"
+	default:
+		server.logf("ERROR: delivering message from %s to %s: %v", msg.From.Address, msg.To, errDeliver)
+		return status.TemporaryFailure, "temporary failure"
+	}
+}
+
+// readRequest reads request in form of "misfin://mailbox@host message\r\n".
+// Message can contain bare LF line breaks.
+func readRequest(re *bufio.Reader) (Address, string, error) {
+	var line []byte
+	for !strings.HasSuffix(string(line), "\r\n") {
+		if len(line) >= MaxRequestSize {
+			return Address{}, "", errors.New("request is too large")
+		}
+		var b, errRead = re.ReadByte()
+		if errRead != nil {
+			return Address{}, "", errRead
+		}
+		line = append(line, b)
+	}
+	var request, ok = strings.CutPrefix(strings.TrimSuffix(string(line), "\r\n"), "misfin://")
+	if !ok {
+		return Address{}, "", errors.New("missing misfin scheme")
+	}
+	var rawAddr, message, _ = strings.Cut(request, " ")
+	var addr, errAddr = ParseAddress(rawAddr)
+	return addr, message, errAddr
+}
+
+func (server *Server) validHost(host string) bool {
+	server.once.Do(func() {
+		server.hosts = hostset.New(server.Hosts)
+	})
+	return server.hosts.Match(&url.URL{Host: host})
+}
+
+func (server *Server) logf(format string, args ...any) {
+	if server.Logf != nil {
+		server.Logf(format, args...)
+	}
+}
@@ -0,0 +1,73 @@
+package misfin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Store errors mapped to misfin status codes.
+var (
+	// ErrMailboxNotFound means that the recipient mailbox doesn't exist.
+	ErrMailboxNotFound = errors.New("mailbox not found")
+	// ErrMailboxFull means that the recipient mailbox can't accept messages now.
+	ErrMailboxFull = errors.New("mailbox is full")
+	// ErrSenderNotAuthorized means that the recipient doesn't accept messages from the sender.
+	ErrSenderNotAuthorized = errors.New("sender is not authorized")
+)
+
+// Message is a delivered misfin message.
+type Message struct {
+	To       Address
+	From     Identity
+	Body     string
+	Received time.Time
+}
+
+// Store is a pluggable mailbox storage.
+// Deliver should return ErrMailboxNotFound, ErrMailboxFull or ErrSenderNotAuthorized
+// to report corresponding misfin statuses. Other errors are reported as temporary failures.
+type Store interface {
+	Deliver(ctx context.Context, msg *Message) error
+}
+
+// MemoryStore is an in-memory Store.
+// Messages are accepted only for mailboxes created with AddMailbox.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	mailboxes map[string][]*Message
+}
+
+var _ Store = new(MemoryStore)
+
+// AddMailbox creates an empty mailbox.
+func (store *MemoryStore) AddMailbox(mailbox string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.mailboxes == nil {
+		store.mailboxes = map[string][]*Message{}
+	}
+	if _, ok := store.mailboxes[mailbox]; !ok {
+		store.mailboxes[mailbox] = []*Message{}
+	}
+}
+
+// Deliver stores message in the recipient mailbox.
+func (store *MemoryStore) Deliver(_ context.Context, msg *Message) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var messages, ok = store.mailboxes[msg.To.Mailbox]
+	if !ok {
+		return ErrMailboxNotFound
+	}
+	store.mailboxes[msg.To.Mailbox] = append(messages, msg)
+	return nil
+}
+
+// Messages returns messages delivered to the mailbox, oldest first.
+func (store *MemoryStore) Messages(mailbox string) []*Message {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return append([]*Message(nil), store.mailboxes[mailbox]...)
+}
@@ -0,0 +1,104 @@
+package gemax
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	urlpkg "net/url"
+	"slices"
+	"sync"
+
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// Proxy is a gemini proxy handler.
+// It forwards requests for foreign hosts and other schemes to upstream servers
+// and streams responses back to the client.
+// Proxy can be used as Server.ProxyHandler.
+type Proxy struct {
+	// Client is used to fetch upstream resources.
+	// Non-gemini schemes can be fetched only if Client.Proxy routes them to a gateway.
+	// To pass redirects to downstream clients as is, Client.CheckRedirect
+	// must return ErrUseLastResponse.
+	// Custom clients should use HostAllowlist.Dial, so host names can't be resolved
+	// to internal addresses, and must not follow redirects to not allowed hosts.
+	// If nil, then a client, which doesn't follow redirects and dials with HostAllowlist.Dial, is used.
+	Client *Client
+	// Allowed upstream hosts. See HostAllowlist.
+	// Use AnyPublicHost to proxy requests to any public host.
+	// If empty, then all requests are refused.
+	Hosts []string
+	// Allowed upstream URL schemes.
+	// If empty, then only "gemini" is allowed.
+	Schemes []string
+	// Optional text logger.
+	// Messages are formatted as "LEVEL: message key=value ..." lines.
+	Logf func(format string, args ...any)
+	// Optional structured logger. It can be used along with Logf.
+	Logger *slog.Logger
+
+	once   sync.Once
+	client *Client
+}
+
+var _ Handler = new(Proxy).Serve
+
+// Serve forwards request to the upstream server.
+// Failed upstream transactions are reported as status.ProxyError,
+// requests to not allowed hosts and schemes are refused with status.ProxyRequestRefused.
+func (proxy *Proxy) Serve(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
+	proxy.init()
+	var target = req.URL()
+	if !proxy.allowed(target) {
+		proxy.log(ctx, slog.LevelWarn, "proxy request is refused", "url", target.String())
+		rw.WriteStatus(status.ProxyRequestRefused, "proxy request refused")
+		return
+	}
+
+	var resp, errFetch = proxy.client.Fetch(ctx, target.String())
+	var errScheme *UnsupportedSchemeError
+	switch {
+	case errors.As(errFetch, &errScheme), errors.Is(errFetch, ErrHostNotAllowed):
+		proxy.log(ctx, slog.LevelWarn, "proxy request is refused", "url", target.String(), "error", errFetch)
+		rw.WriteStatus(status.ProxyRequestRefused, "proxy request refused")
+		return
+	case errFetch != nil:
+		proxy.log(ctx, slog.LevelError, "proxying", "url", target.String(), "error", errFetch)
+		rw.WriteStatus(status.ProxyError, status.ProxyError.String())
+		return
+	}
+	defer func() { _ = resp.Close() }()
+
+	rw.WriteStatus(resp.Status, resp.Meta)
+	if resp.Status != status.Success {
+		return
+	}
+	if _, errCopy := io.Copy(rw, resp); errCopy != nil {
+		proxy.log(ctx, slog.LevelError, "proxying: streaming response", "url", target.String(), "error", errCopy)
+	}
+}
+
+func (proxy *Proxy) init() {
+	proxy.once.Do(func() {
+		proxy.client = proxy.Client
+		if proxy.client == nil {
+			proxy.client = &Client{
+				Dial: HostAllowlist(proxy.Hosts).Dial,
+				CheckRedirect: func(context.Context, *urlpkg.URL, []RedirectedRequest) error {
+					return ErrUseLastResponse
+				},
+			}
+		}
+	})
+}
+
+func (proxy *Proxy) allowed(target *urlpkg.URL) bool {
+	var schemeOK = slices.Contains(proxy.Schemes, target.Scheme) ||
+		len(proxy.Schemes) == 0 && target.Scheme == "gemini"
+	return schemeOK && HostAllowlist(proxy.Hosts).Allowed(target)
+}
+
+func (proxy *Proxy) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	logSink{logger: proxy.Logger, logf: proxy.Logf}.log(ctx, level, msg, args...)
+}
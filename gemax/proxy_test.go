@@ -0,0 +1,178 @@
+package gemax_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	urlpkg "net/url"
+	"strings"
+	"testing"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+func TestProxy(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{"20 text/plain\r\nhello"},
+	}
+	var proxy = &gemax.Proxy{
+		Client: &gemax.Client{Dial: dialer.Dial},
+		Hosts:  []string{"upstream.com"},
+		Logf:   test.Logf,
+	}
+	var rw = &responseRecorder{}
+	var req = &request{
+		remoteAddr: test.Name(),
+		url:        "gemini://upstream.com/page",
+	}
+
+	proxy.Serve(context.Background(), rw, req)
+
+	if rw.status != status.Success || rw.meta != "text/plain" {
+		test.Fatalf("unexpected response header %s %q", rw.status, rw.meta)
+	}
+	if rw.String() != "hello" {
+		test.Fatalf("unexpected response body %q", rw.String())
+	}
+	if dialer.requests[0] != "gemini://upstream.com/page\r\n" {
+		test.Fatalf("unexpected upstream request %q", dialer.requests[0])
+	}
+}
+
+func TestProxy_Refused(test *testing.T) {
+	test.Parallel()
+	var proxy = &gemax.Proxy{
+		Client: &gemax.Client{Dial: (&scriptedDialer{}).Dial},
+		Hosts:  []string{"upstream.com"},
+	}
+	var tc = func(name, url string) {
+		test.Run(name, func(test *testing.T) {
+			var rw = &responseRecorder{}
+			proxy.Serve(context.Background(), rw, &request{url: url})
+			if rw.status != status.ProxyRequestRefused {
+				test.Fatalf("expected %s, got %s", status.ProxyRequestRefused, rw.status)
+			}
+		})
+	}
+	tc("unknown host", "gemini://other.com/")
+	tc("unknown scheme", "https://upstream.com/")
+}
+
+func TestProxy_DefaultDeny(test *testing.T) {
+	test.Parallel()
+	var dialer = &scriptedDialer{
+		responses: []string{"20 text/plain\r\nhello"},
+	}
+	var proxy = &gemax.Proxy{
+		Client: &gemax.Client{Dial: dialer.Dial},
+	}
+	var rw = &responseRecorder{}
+	proxy.Serve(context.Background(), rw, &request{url: "gemini://upstream.com/"})
+	if rw.status != status.ProxyRequestRefused {
+		test.Fatalf("expected %s, got %s", status.ProxyRequestRefused, rw.status)
+	}
+	if len(dialer.requests) != 0 {
+		test.Fatalf("unexpected upstream requests %q", dialer.requests)
+	}
+}
+
+func TestHostAllowlist(test *testing.T) {
+	test.Parallel()
+	var tc = func(hosts gemax.HostAllowlist, url string, expected bool) {
+		test.Helper()
+		var u, errParse = urlpkg.Parse(url)
+		if errParse != nil {
+			test.Fatal(errParse)
+		}
+		if got := hosts.Allowed(u); got != expected {
+			test.Errorf("%q: %s: expected %v, got %v", hosts, url, expected, got)
+		}
+	}
+
+	tc(nil, "gemini://example.com/", false)
+	tc(gemax.HostAllowlist{"example.com"}, "gemini://example.com/", true)
+	tc(gemax.HostAllowlist{"example.com:1966"}, "gemini://example.com:1966/", true)
+	tc(gemax.HostAllowlist{"example.com:1966"}, "gemini://example.com/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://example.com/", true)
+	tc(gemax.HostAllowlist{"*"}, "gemini://93.184.215.14/", true)
+	tc(gemax.HostAllowlist{"*"}, "gemini://localhost/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://a.localhost/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://127.0.0.1:1965/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://10.0.0.1/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://169.254.169.254/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://[::1]/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://[::ffff:127.0.0.1]/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://0.0.0.0/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://0.1.2.3/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://100.64.0.1/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://192.0.2.1/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://224.0.0.1/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://255.255.255.255/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://[::]/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://[::ffff:10.0.0.1]/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://[64:ff9b::a00:1]/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://[64:ff9b::5db8:d70e]/", true)
+	tc(gemax.HostAllowlist{"*"}, "gemini://[2002:c0a8:1::1]/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://[fd00::1]/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://[2001:db8::1]/", false)
+	tc(gemax.HostAllowlist{"*"}, "gemini://[2606:4700::1]/", true)
+	tc(gemax.HostAllowlist{"*", "127.0.0.1"}, "gemini://127.0.0.1/", true)
+	tc(gemax.HostAllowlist{"*"}, "gemini:///", false)
+}
+
+func TestHostAllowlist_Dial(test *testing.T) {
+	test.Parallel()
+	var hosts = gemax.HostAllowlist{"*"}
+	var _, errDial = hosts.Dial(context.Background(), "localhost:1965", &tls.Config{})
+	if !errors.Is(errDial, gemax.ErrHostNotAllowed) {
+		test.Fatalf("expected %v, got %v", gemax.ErrHostNotAllowed, errDial)
+	}
+	_, errDial = gemax.HostAllowlist{}.Dial(context.Background(), "example.com:1965", &tls.Config{})
+	if !errors.Is(errDial, gemax.ErrHostNotAllowed) {
+		test.Fatalf("expected %v, got %v", gemax.ErrHostNotAllowed, errDial)
+	}
+}
+
+func TestProxy_UpstreamError(test *testing.T) {
+	test.Parallel()
+	var proxy = &gemax.Proxy{
+		Client: &gemax.Client{
+			Dial: func(context.Context, string, *tls.Config) (net.Conn, error) {
+				return nil, errors.New("connection refused")
+			},
+		},
+		Hosts: []string{"upstream.com"},
+	}
+	var rw = &responseRecorder{}
+	proxy.Serve(context.Background(), rw, &request{url: "gemini://upstream.com/"})
+	if rw.status != status.ProxyError {
+		test.Fatalf("expected %s, got %s", status.ProxyError, rw.status)
+	}
+}
+
+func TestServer_ProxyHandler(test *testing.T) {
+	var listener, server = setupEchoServer(test)
+	server.Hosts = []string{"example.com"}
+	server.ProxyHandler = func(_ context.Context, rw gemax.ResponseWriter, req gemax.IncomingRequest) {
+		rw.WriteStatus(status.Success, "text/plain")
+		_, _ = rw.Write([]byte("proxied " + req.URL().String()))
+	}
+	defer func() { _ = listener.Close() }()
+	var ctx, cancel = context.WithCancel(context.Background())
+	test.Cleanup(cancel)
+	runTask(test, func() {
+		var err = server.Serve(ctx, listener)
+		if err != nil {
+			test.Logf("test server: Serve: %v", err)
+		}
+	})
+
+	var resp = dialAndWrite(test, ctx, listener, "gemini://another.com/path\r\n")
+	expectResponse(test, strings.NewReader(resp), "20 text/plain\r\nproxied gemini://another.com/path")
+
+	resp = dialAndWrite(test, ctx, listener, "gemini://example.com/path\r\n")
+	expectResponse(test, strings.NewReader(resp), "20 text/gemini\r\ngemini://example.com/path")
+}
@@ -0,0 +1,5 @@
+// Package proxyproto implements the HAProxy PROXY protocol versions 1 and 2.
+// It's used to pass original client addresses and client certificate fingerprints
+// from a TLS terminating proxy to upstream servers.
+// Specification: https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+package proxyproto
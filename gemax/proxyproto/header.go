@@ -0,0 +1,175 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Version of the PROXY protocol.
+type Version int
+
+const (
+	// V1 is the human-readable text format. It doesn't support TLVs.
+	V1 Version = 1
+	// V2 is the binary format.
+	V2 Version = 2
+)
+
+// TLVCertFingerprint is a custom TLV type, which carries the hex encoded
+// SHA-256 fingerprint of the client certificate.
+const TLVCertFingerprint = 0xE0
+
+// ErrInvalidHeader means that the PROXY header is malformed.
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// TLV is a type-length-value extension of the version 2 header.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header is a PROXY protocol header.
+type Header struct {
+	Version Version
+	// Local is true for connections established by the proxy itself (e.g. health checks).
+	// Addresses of local connections must be ignored.
+	Local bool
+	// Original client address. If it's not a TCP address, then the address family is unknown.
+	Source net.Addr
+	// Address the client connected to.
+	// If it's unknown, then an unspecified address of the source family is used.
+	Destination net.Addr
+	// Extensions. Ignored by V1.
+	TLVs []TLV
+}
+
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	commandLocal = 0x20
+	commandProxy = 0x21
+
+	familyUnspec = 0x00
+	familyTCP4   = 0x11
+	familyTCP6   = 0x21
+)
+
+// WriteTo writes the header to wr.
+func (header *Header) WriteTo(wr io.Writer) (int64, error) {
+	var data []byte
+	switch header.Version {
+	case V1:
+		data = header.appendV1(nil)
+	case V2:
+		var errEncode error
+		data, errEncode = header.appendV2(nil)
+		if errEncode != nil {
+			return 0, errEncode
+		}
+	default:
+		return 0, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, header.Version)
+	}
+	var n, errWrite = wr.Write(data)
+	return int64(n), errWrite
+}
+
+func (header *Header) appendV1(buf []byte) []byte {
+	var src, dst, family = header.tcpAddrs()
+	switch {
+	case header.Local || family == familyUnspec:
+		return append(buf, "PROXY UNKNOWN\r\n"...)
+	case family == familyTCP4:
+		buf = append(buf, "PROXY TCP4 "...)
+	default:
+		buf = append(buf, "PROXY TCP6 "...)
+	}
+	buf = append(buf, src.IP.String()...)
+	buf = append(buf, ' ')
+	buf = append(buf, dst.IP.String()...)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(src.Port), 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(dst.Port), 10)
+	return append(buf, "\r\n"...)
+}
+
+func (header *Header) appendV2(buf []byte) ([]byte, error) {
+	var src, dst, family = header.tcpAddrs()
+	var command byte = commandProxy
+	if header.Local {
+		command, family = commandLocal, familyUnspec
+	}
+
+	var payload bytes.Buffer
+	switch family {
+	case familyTCP4:
+		payload.Write(src.IP.To4())
+		payload.Write(dst.IP.To4())
+	case familyTCP6:
+		payload.Write(src.IP.To16())
+		payload.Write(dst.IP.To16())
+	}
+	if family != familyUnspec {
+		_ = binary.Write(&payload, binary.BigEndian, uint16(src.Port))
+		_ = binary.Write(&payload, binary.BigEndian, uint16(dst.Port))
+	}
+	for _, tlv := range header.TLVs {
+		if len(tlv.Value) > 0xFFFF {
+			return nil, fmt.Errorf("%w: TLV 0x%02x is too large", ErrInvalidHeader, tlv.Type)
+		}
+		payload.WriteByte(tlv.Type)
+		_ = binary.Write(&payload, binary.BigEndian, uint16(len(tlv.Value)))
+		payload.Write(tlv.Value)
+	}
+	if payload.Len() > 0xFFFF {
+		return nil, fmt.Errorf("%w: header is too large", ErrInvalidHeader)
+	}
+
+	buf = append(buf, signatureV2...)
+	buf = append(buf, command, family)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(payload.Len()))
+	return append(buf, payload.Bytes()...), nil
+}
+
+// tcpAddrs returns source and destination addresses of the same family.
+func (header *Header) tcpAddrs() (*net.TCPAddr, *net.TCPAddr, byte) {
+	var src, dst = tcpAddr(header.Source), tcpAddr(header.Destination)
+	if src == nil {
+		return nil, nil, familyUnspec
+	}
+	if dst == nil {
+		dst = &net.TCPAddr{IP: net.IPv6unspecified}
+		if src.IP.To4() != nil {
+			dst.IP = net.IPv4zero
+		}
+	}
+	switch {
+	case src.IP.To4() != nil && dst.IP.To4() != nil:
+		return src, dst, familyTCP4
+	case src.IP.To16() != nil && dst.IP.To16() != nil:
+		return src, dst, familyTCP6
+	default:
+		return nil, nil, familyUnspec
+	}
+}
+
+// tcpAddr returns nil for addresses of other types.
+func tcpAddr(addr net.Addr) *net.TCPAddr {
+	var tcp, _ = addr.(*net.TCPAddr)
+	return tcp
+}
+
+// TLV returns value of the first extension with provided type.
+func (header *Header) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range header.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,145 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout is the default time limit of reading a PROXY header.
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Listener parses PROXY headers of accepted connections.
+// Headers are parsed lazily on the first Read or RemoteAddr call,
+// so Accept is not blocked by slow clients.
+//
+// Connections from trusted sources must start with a PROXY header,
+// otherwise reads fail. Connections from other sources are passed as is.
+// Wrap Listener with tls.NewListener to serve TLS behind a proxy.
+type Listener struct {
+	net.Listener
+	// Trusted proxy networks.
+	// If empty, then no source is trusted and all connections are passed as is.
+	Trusted []netip.Prefix
+	// Time limit of reading a PROXY header.
+	//	0 - DefaultHeaderTimeout
+	HeaderTimeout time.Duration
+}
+
+// Accept waits for the next connection.
+func (listener *Listener) Accept() (net.Conn, error) {
+	var conn, errAccept = listener.Listener.Accept()
+	if errAccept != nil {
+		return nil, errAccept
+	}
+	if !listener.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	var timeout = listener.HeaderTimeout
+	if timeout <= 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &Conn{
+		Conn:    conn,
+		re:      bufio.NewReader(conn),
+		timeout: timeout,
+	}, nil
+}
+
+func (listener *Listener) trusted(addr net.Addr) bool {
+	var addrPort, errParse = netip.ParseAddrPort(addr.String())
+	if errParse != nil {
+		return false
+	}
+	var ip = addrPort.Addr().Unmap()
+	for _, prefix := range listener.Trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted proxy.
+// It reports addresses from the PROXY header.
+type Conn struct {
+	net.Conn
+	re      *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+// ProxyHeader returns the parsed PROXY header.
+// It blocks until the header is read.
+func (conn *Conn) ProxyHeader() (*Header, error) {
+	conn.once.Do(conn.readHeader)
+	return conn.header, conn.err
+}
+
+func (conn *Conn) readHeader() {
+	conn.mu.Lock()
+	var deadline = conn.readDeadline
+	conn.mu.Unlock()
+	var headerDeadline = time.Now().Add(conn.timeout)
+	if deadline.IsZero() || headerDeadline.Before(deadline) {
+		_ = conn.Conn.SetReadDeadline(headerDeadline)
+	}
+	conn.header, conn.err = ReadHeader(conn.re)
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	_ = conn.Conn.SetReadDeadline(conn.readDeadline)
+}
+
+// SetDeadline sets read and write deadlines.
+func (conn *Conn) SetDeadline(deadline time.Time) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.readDeadline = deadline
+	return conn.Conn.SetDeadline(deadline)
+}
+
+// SetReadDeadline sets read deadline.
+// The PROXY header is read within the earliest of the deadline and header timeout.
+func (conn *Conn) SetReadDeadline(deadline time.Time) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.readDeadline = deadline
+	return conn.Conn.SetReadDeadline(deadline)
+}
+
+// Read reads data following the PROXY header.
+func (conn *Conn) Read(data []byte) (int, error) {
+	if _, err := conn.ProxyHeader(); err != nil {
+		return 0, err
+	}
+	return conn.re.Read(data)
+}
+
+// RemoteAddr returns the original client address.
+// If the header is malformed or describes a local connection,
+// then the proxy address is returned.
+func (conn *Conn) RemoteAddr() net.Addr {
+	var header, err = conn.ProxyHeader()
+	if err != nil || header.Local || header.Source == nil {
+		return conn.Conn.RemoteAddr()
+	}
+	return header.Source
+}
+
+// LocalAddr returns the address the original client connected to.
+func (conn *Conn) LocalAddr() net.Addr {
+	var header, err = conn.ProxyHeader()
+	if err != nil || header.Local || header.Destination == nil {
+		return conn.Conn.LocalAddr()
+	}
+	return header.Destination
+}
@@ -0,0 +1,131 @@
+package proxyproto_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ninedraft/gemax/gemax/proxyproto"
+)
+
+func TestHeaderRoundTrip(test *testing.T) {
+	var tc = func(name string, header *proxyproto.Header) {
+		test.Run(name, func(test *testing.T) {
+			var buf bytes.Buffer
+			if _, err := header.WriteTo(&buf); err != nil {
+				test.Fatalf("writing header: %v", err)
+			}
+			test.Logf("header: %q", buf.String())
+			buf.WriteString("payload")
+
+			var re = bufio.NewReader(&buf)
+			var got, errRead = proxyproto.ReadHeader(re)
+			if errRead != nil {
+				test.Fatalf("reading header: %v", errRead)
+			}
+			if got.Version != header.Version || got.Local != header.Local {
+				test.Errorf("expected %+v, got %+v", header, got)
+			}
+			if addrString(got.Source) != addrString(header.Source) ||
+				addrString(got.Destination) != addrString(header.Destination) {
+				test.Errorf("expected addresses %v -> %v, got %v -> %v",
+					header.Source, header.Destination, got.Source, got.Destination)
+			}
+			if header.Version == proxyproto.V2 && !reflect.DeepEqual(got.TLVs, header.TLVs) {
+				test.Errorf("expected TLVs %v, got %v", header.TLVs, got.TLVs)
+			}
+			if rest, _ := io.ReadAll(re); string(rest) != "payload" {
+				test.Errorf("unexpected data after header: %q", rest)
+			}
+		})
+	}
+
+	var src4, dst4 = tcpAddr("192.0.2.1:4000"), tcpAddr("198.51.100.1:1965")
+	var src6, dst6 = tcpAddr("[2001:db8::1]:4000"), tcpAddr("[2001:db8::2]:1965")
+	var tlvs = []proxyproto.TLV{{Type: proxyproto.TLVCertFingerprint, Value: []byte("abcdef")}}
+
+	tc("v1 tcp4", &proxyproto.Header{Version: proxyproto.V1, Source: src4, Destination: dst4})
+	tc("v1 tcp6", &proxyproto.Header{Version: proxyproto.V1, Source: src6, Destination: dst6})
+	tc("v1 unknown", &proxyproto.Header{Version: proxyproto.V1})
+	tc("v2 tcp4", &proxyproto.Header{Version: proxyproto.V2, Source: src4, Destination: dst4, TLVs: tlvs})
+	tc("v2 tcp6", &proxyproto.Header{Version: proxyproto.V2, Source: src6, Destination: dst6})
+	tc("v2 local", &proxyproto.Header{Version: proxyproto.V2, Local: true})
+}
+
+func TestReadHeader_Invalid(test *testing.T) {
+	var tc = func(name, input string, expected error) {
+		test.Run(name, func(test *testing.T) {
+			var _, err = proxyproto.ReadHeader(bufio.NewReader(strings.NewReader(input)))
+			if !errors.Is(err, expected) {
+				test.Fatalf("expected %v, got %v", expected, err)
+			}
+		})
+	}
+
+	tc("no header", "gemini://example.com/\r\n", proxyproto.ErrNoHeader)
+	tc("unterminated v1", "PROXY TCP4 192.0.2.1 198.51.100.1 4000 1965", proxyproto.ErrInvalidHeader)
+	tc("bad v1 address", "PROXY TCP4 192.0.2 198.51.100.1 4000 1965\r\n", proxyproto.ErrInvalidHeader)
+	tc("truncated v2", "\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c\x01", proxyproto.ErrInvalidHeader)
+}
+
+func TestListener(test *testing.T) {
+	var tc = func(name string, trusted []netip.Prefix, expectedRemote string) {
+		test.Run(name, func(test *testing.T) {
+			var raw, errListen = net.Listen("tcp", "127.0.0.1:0")
+			if errListen != nil {
+				test.Fatal(errListen)
+			}
+			var listener = &proxyproto.Listener{Listener: raw, Trusted: trusted}
+			defer func() { _ = listener.Close() }()
+
+			go func() {
+				var conn, errDial = net.Dial("tcp", raw.Addr().String())
+				if errDial != nil {
+					test.Error(errDial)
+					return
+				}
+				defer func() { _ = conn.Close() }()
+				_, _ = io.WriteString(conn, "PROXY TCP4 192.0.2.1 198.51.100.1 4000 1965\r\nhello")
+			}()
+
+			var conn, errAccept = listener.Accept()
+			if errAccept != nil {
+				test.Fatal(errAccept)
+			}
+			defer func() { _ = conn.Close() }()
+			var remote = conn.RemoteAddr().String()
+			if expectedRemote != "" && remote != expectedRemote {
+				test.Errorf("expected remote address %s, got %s", expectedRemote, remote)
+			}
+			var data, _ = io.ReadAll(conn)
+			var expectedData = "hello"
+			if expectedRemote == "" {
+				expectedData = "PROXY TCP4 192.0.2.1 198.51.100.1 4000 1965\r\nhello"
+			}
+			if string(data) != expectedData {
+				test.Errorf("expected %q, got %q", expectedData, data)
+			}
+		})
+	}
+
+	tc("trust nothing by default", nil, "")
+	tc("trusted source", []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, "192.0.2.1:4000")
+	tc("untrusted source", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "")
+}
+
+func tcpAddr(addr string) *net.TCPAddr {
+	return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr))
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
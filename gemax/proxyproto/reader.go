@@ -0,0 +1,177 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ErrNoHeader means that the connection doesn't start with a PROXY header.
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// maxHeaderV1 is the maximum length of the version 1 header including CRLF.
+const maxHeaderV1 = 107
+
+// ReadHeader reads a version 1 or version 2 PROXY header.
+// Returns ErrNoHeader if the stream doesn't start with a header signature.
+func ReadHeader(re *bufio.Reader) (*Header, error) {
+	var first, errPeek = re.Peek(1)
+	if errPeek != nil {
+		return nil, errPeek
+	}
+	switch first[0] {
+	case 'P':
+		return readV1(re)
+	case signatureV2[0]:
+		return readV2(re)
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+func readV1(re *bufio.Reader) (*Header, error) {
+	if prefix, _ := re.Peek(6); string(prefix) != "PROXY " {
+		return nil, ErrNoHeader
+	}
+	var line, errLine = readV1Line(re)
+	if errLine != nil {
+		return nil, errLine
+	}
+
+	var fields = strings.Fields(string(line))
+	var header = &Header{Version: V1}
+	switch {
+	case len(fields) >= 2 && fields[1] == "UNKNOWN":
+		return header, nil
+	case len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6":
+		return nil, fmt.Errorf("%w: malformed v1 header %q", ErrInvalidHeader, line)
+	}
+	var src, errSrc = parseV1Addr(fields[2], fields[4])
+	var dst, errDst = parseV1Addr(fields[3], fields[5])
+	if err := errors.Join(errSrc, errDst); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	header.Source, header.Destination = src, dst
+	return header, nil
+}
+
+// readV1Line reads v1 header line including line terminator.
+func readV1Line(re *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for len(line) < maxHeaderV1 {
+		var b, errRead = re.ReadByte()
+		if errRead != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, errRead)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 header is not terminated", ErrInvalidHeader)
+	}
+	return line, nil
+}
+
+func parseV1Addr(ip, port string) (*net.TCPAddr, error) {
+	var addr, errAddr = netip.ParseAddr(ip)
+	if errAddr != nil {
+		return nil, errAddr
+	}
+	var p, errPort = strconv.ParseUint(port, 10, 16)
+	if errPort != nil {
+		return nil, errPort
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+func readV2(re *bufio.Reader) (*Header, error) {
+	if signature, _ := re.Peek(len(signatureV2)); !bytes.Equal(signature, signatureV2) {
+		return nil, ErrNoHeader
+	}
+	var head = make([]byte, len(signatureV2)+4)
+	if _, err := io.ReadFull(re, head); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	var verCmd, family = head[12], head[13]
+	var payload = make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(re, payload); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, verCmd>>4)
+	}
+
+	var header = &Header{Version: V2}
+	switch verCmd {
+	case commandLocal:
+		header.Local = true
+	case commandProxy:
+	default:
+		return nil, fmt.Errorf("%w: unknown command 0x%02x", ErrInvalidHeader, verCmd)
+	}
+
+	var tlvs, errAddrs = header.parseV2Addrs(family, payload)
+	if errAddrs != nil {
+		return nil, errAddrs
+	}
+	var errTLVs error
+	header.TLVs, errTLVs = parseTLVs(tlvs)
+	if errTLVs != nil {
+		return nil, errTLVs
+	}
+	return header, nil
+}
+
+// parseV2Addrs parses TCP addresses of the address block and returns TLVs following it.
+// Addresses of other families are skipped.
+func (header *Header) parseV2Addrs(family byte, payload []byte) ([]byte, error) {
+	var addrLen int
+	switch family >> 4 {
+	case 0x1:
+		addrLen = 2*net.IPv4len + 4
+	case 0x2:
+		addrLen = 2*net.IPv6len + 4
+	case 0x3:
+		addrLen = 2 * 108
+	}
+	if len(payload) < addrLen {
+		return nil, fmt.Errorf("%w: short address block", ErrInvalidHeader)
+	}
+	if family == familyTCP4 || family == familyTCP6 {
+		var ipLen = (addrLen - 4) / 2
+		header.Source = &net.TCPAddr{
+			IP:   net.IP(bytes.Clone(payload[:ipLen])),
+			Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+		}
+		header.Destination = &net.TCPAddr{
+			IP:   net.IP(bytes.Clone(payload[ipLen : 2*ipLen])),
+			Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+		}
+	}
+	return payload[addrLen:], nil
+}
+
+func parseTLVs(tlvs []byte) ([]TLV, error) {
+	var parsed []TLV
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrInvalidHeader)
+		}
+		var size = int(binary.BigEndian.Uint16(tlvs[1:]))
+		if len(tlvs) < 3+size {
+			return nil, fmt.Errorf("%w: truncated TLV 0x%02x", ErrInvalidHeader, tlvs[0])
+		}
+		parsed = append(parsed, TLV{Type: tlvs[0], Value: bytes.Clone(tlvs[3 : 3+size])})
+		tlvs = tlvs[3+size:]
+	}
+	return parsed, nil
+}
@@ -0,0 +1,172 @@
+package gemax
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+const (
+	// DefaultRateLimit is the default number of requests per second allowed for a single client.
+	DefaultRateLimit = 1.0
+	// DefaultRateBurst is the default number of requests, which can be made at once.
+	DefaultRateBurst = 10
+	// DefaultRateLimitClients is the default number of tracked clients.
+	DefaultRateLimitClients = 10_000
+)
+
+// RateLimiter limits request rate of each client with a token bucket.
+// Use a separate RateLimiter for each route to configure per-route limits.
+type RateLimiter struct {
+	// Requests per second allowed for a single client.
+	//	0 - DefaultRateLimit
+	Rate float64
+	// Maximum number of requests, which can be made at once.
+	//	0 - DefaultRateBurst
+	Burst int
+	// Key identifies a client. If nil, then RemoteIPKey is used.
+	// See CertificateKey.
+	Key func(req IncomingRequest) string
+	// Maximum number of tracked clients.
+	// Least recently seen clients are forgotten, when the limit is reached.
+	// Clients are also forgotten, when their buckets are refilled.
+	//	0 - DefaultRateLimitClients
+	MaxClients int
+	// Optional text logger.
+	Logf func(format string, args ...any)
+
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	lru     list.List
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// RateLimit wraps handler and responds status.SlowDown to clients, which exceed the limit.
+// Response meta contains number of seconds to wait before the next request.
+//
+// Example:
+//
+//	RateLimit(&RateLimiter{Rate: 0.5, Burst: 5}, search)
+func RateLimit(limiter *RateLimiter, handler Handler) Handler {
+	return func(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
+		var key = limiter.key(req)
+		var wait = limiter.reserve(key, time.Now())
+		if wait > 0 {
+			var seconds = int(math.Ceil(wait.Seconds()))
+			limiter.logf("WARN: rate limit: %s is over the limit, retry in %ds", key, seconds)
+			rw.WriteStatus(status.SlowDown, strconv.Itoa(seconds))
+			return
+		}
+		handler(ctx, rw, req)
+	}
+}
+
+// RemoteIPKey identifies clients by remote IP address.
+func RemoteIPKey(req IncomingRequest) string {
+	var host, _, errSplit = net.SplitHostPort(req.RemoteAddr())
+	if errSplit != nil {
+		return req.RemoteAddr()
+	}
+	return host
+}
+
+// CertificateKey identifies clients by client certificate fingerprint.
+// Clients without certificates are identified by remote IP address.
+func CertificateKey(req IncomingRequest) string {
+	if certs := req.Certificates(); len(certs) > 0 {
+		return "cert:" + CertificateFingerprint(certs[0])
+	}
+	return RemoteIPKey(req)
+}
+
+// reserve takes a token from the client bucket.
+// Returns time to wait, if the bucket is empty.
+func (limiter *RateLimiter) reserve(key string, now time.Time) time.Duration {
+	var rate, burst = limiter.rate(), float64(limiter.burst())
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limiter.clients == nil {
+		limiter.clients = map[string]*list.Element{}
+	}
+	limiter.expire(now, time.Duration(burst/rate*float64(time.Second)))
+
+	var bucket *tokenBucket
+	if elem, ok := limiter.clients[key]; ok {
+		bucket = elem.Value.(*tokenBucket)
+		limiter.lru.MoveToFront(elem)
+		bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	} else {
+		bucket = &tokenBucket{key: key, tokens: burst}
+		limiter.clients[key] = limiter.lru.PushFront(bucket)
+		if limiter.lru.Len() > limiter.maxClients() {
+			limiter.remove(limiter.lru.Back())
+		}
+	}
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+}
+
+// expire forgets clients, which buckets are refilled.
+func (limiter *RateLimiter) expire(now time.Time, refill time.Duration) {
+	for elem := limiter.lru.Back(); elem != nil; elem = limiter.lru.Back() {
+		if now.Sub(elem.Value.(*tokenBucket).last) < refill {
+			return
+		}
+		limiter.remove(elem)
+	}
+}
+
+func (limiter *RateLimiter) remove(elem *list.Element) {
+	delete(limiter.clients, elem.Value.(*tokenBucket).key)
+	limiter.lru.Remove(elem)
+}
+
+func (limiter *RateLimiter) key(req IncomingRequest) string {
+	if limiter.Key != nil {
+		return limiter.Key(req)
+	}
+	return RemoteIPKey(req)
+}
+
+func (limiter *RateLimiter) rate() float64 {
+	if limiter.Rate > 0 {
+		return limiter.Rate
+	}
+	return DefaultRateLimit
+}
+
+func (limiter *RateLimiter) burst() int {
+	if limiter.Burst > 0 {
+		return limiter.Burst
+	}
+	return DefaultRateBurst
+}
+
+func (limiter *RateLimiter) maxClients() int {
+	if limiter.MaxClients > 0 {
+		return limiter.MaxClients
+	}
+	return DefaultRateLimitClients
+}
+
+func (limiter *RateLimiter) logf(format string, args ...any) {
+	if limiter.Logf != nil {
+		limiter.Logf(format, args...)
+	}
+}
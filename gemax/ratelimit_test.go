@@ -0,0 +1,59 @@
+package gemax_test
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+func TestRateLimit(test *testing.T) {
+	var ok = gemax.ServeContent(gemax.MIMEGemtext, []byte("ok"))
+	var serve = func(handler gemax.Handler, remoteAddr string, certs ...*x509.Certificate) *responseRecorder {
+		var rw = &responseRecorder{}
+		handler(context.Background(), rw, &request{
+			remoteAddr: remoteAddr,
+			url:        "gemini://example.com/search",
+			certs:      certs,
+		})
+		return rw
+	}
+	var expect = func(test *testing.T, rw *responseRecorder, code status.Code, meta string) {
+		test.Helper()
+		if rw.status != code || code == status.SlowDown && rw.meta != meta {
+			test.Fatalf("expected %s %q, got %s %q", code, meta, rw.status, rw.meta)
+		}
+	}
+
+	test.Run("by remote IP", func(test *testing.T) {
+		var handler = gemax.RateLimit(&gemax.RateLimiter{Rate: 0.5, Burst: 2, Logf: test.Logf}, ok)
+		expect(test, serve(handler, "192.0.2.1:4000"), status.Success, "")
+		expect(test, serve(handler, "192.0.2.1:4001"), status.Success, "")
+		expect(test, serve(handler, "192.0.2.1:4002"), status.SlowDown, "2")
+		expect(test, serve(handler, "192.0.2.2:4000"), status.Success, "")
+	})
+
+	test.Run("by certificate", func(test *testing.T) {
+		var alice, _ = x509.ParseCertificate(testCert("alice").Certificate[0])
+		var bob, _ = x509.ParseCertificate(testCert("bob").Certificate[0])
+		var handler = gemax.RateLimit(&gemax.RateLimiter{
+			Rate:  0.1,
+			Burst: 1,
+			Key:   gemax.CertificateKey,
+		}, ok)
+		expect(test, serve(handler, "192.0.2.1:4000", alice), status.Success, "")
+		expect(test, serve(handler, "192.0.2.1:4000", alice), status.SlowDown, "10")
+		expect(test, serve(handler, "192.0.2.1:4000", bob), status.Success, "")
+	})
+
+	test.Run("bounded clients", func(test *testing.T) {
+		var handler = gemax.RateLimit(&gemax.RateLimiter{Rate: 0.1, Burst: 1, MaxClients: 1}, ok)
+		expect(test, serve(handler, "192.0.2.1:4000"), status.Success, "")
+		expect(test, serve(handler, "192.0.2.1:4000"), status.SlowDown, "10")
+		expect(test, serve(handler, "192.0.2.2:4000"), status.Success, "")
+		// 192.0.2.1 is forgotten
+		expect(test, serve(handler, "192.0.2.1:4000"), status.Success, "")
+	})
+}
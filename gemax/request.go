@@ -27,6 +27,18 @@ type IncomingRequest interface {
 	Certificates() []*x509.Certificate
 }
 
+// RequestTLS returns the state of the TLS connection, which the request is received on,
+// if the request provides it with a TLS() *tls.ConnectionState method,
+// as requests parsed by Server and ParseIncomingRequest do.
+// Use it to compare the SNI server name with the requested host.
+// Returns nil for non-TLS connections. See also TLSStateFromContext.
+func RequestTLS(req IncomingRequest) *tls.ConnectionState {
+	if withTLS, ok := req.(interface{ TLS() *tls.ConnectionState }); ok {
+		return withTLS.TLS()
+	}
+	return nil
+}
+
 var (
 	errDotPath = errors.New("dots in path are not permitted")
 )
@@ -35,15 +47,67 @@ var ErrBadRequest = errors.New("bad request")
 
 // ParseIncomingRequest constructs an IncomingRequest from bytestream
 // and additional parameters (remote address for now).
+// Titan upload requests are parsed as TitanRequest with uploads limited by DefaultMaxUploadSize.
+// To read titan upload body re must implement io.ByteReader,
+// otherwise bytes following the request line may be lost.
 func ParseIncomingRequest(re io.Reader, remoteAddr string) (IncomingRequest, error) {
-	var certs []*x509.Certificate
-	if tlsConn, ok := re.(*tls.Conn); ok {
-		certs = slices.Clone(tlsConn.ConnectionState().PeerCertificates)
+	return parseIncomingRequest(re, remoteAddr, connState(re), DefaultMaxUploadSize)
+}
+
+// connState returns TLS connection state of *tls.Conn or nil for other values.
+func connState(conn any) *tls.ConnectionState {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		var state = tlsConn.ConnectionState()
+		return &state
+	}
+	return nil
+}
+
+func parseIncomingRequest(re io.Reader, remoteAddr string, state *tls.ConnectionState, maxUpload int64) (IncomingRequest, error) {
+	parsed, errParse := readRequestURL(re)
+	if errParse != nil {
+		return nil, errParse
+	}
+
+	var params TitanParams
+	var isTitan = parsed.Scheme == "titan"
+	if isTitan {
+		var errParams error
+		params, errParams = parseTitanParams(parsed, maxUpload)
+		if errParams != nil {
+			return nil, errParams
+		}
+	}
+
+	if !ValidPath(parsed.Path) {
+		return nil, fmt.Errorf("%w: %w", ErrBadRequest, errDotPath)
 	}
 
-	re = io.LimitReader(re, MaxRequestSize)
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
 
-	line, errLine := readUntil(re, '\n')
+	var req = &incomingRequest{
+		url:        parsed,
+		remoteAddr: remoteAddr,
+		tls:        state,
+	}
+	if state != nil {
+		req.certs = slices.Clone(state.PeerCertificates)
+	}
+	if isTitan {
+		return &titanRequest{
+			incomingRequest: req,
+			params:          params,
+			body:            io.LimitReader(re, params.Size),
+		}, nil
+	}
+	return req, nil
+}
+
+// readRequestURL reads request line and parses the requested URL.
+func readRequestURL(re io.Reader) (*url.URL, error) {
+	line, errLine := readRequestLine(re)
 	if errLine != nil {
 		return nil, errLine
 	}
@@ -62,23 +126,40 @@ func ParseIncomingRequest(re io.Reader, remoteAddr string) (IncomingRequest, err
 	if parsed.Scheme == "" {
 		return nil, fmt.Errorf("%w: missing scheme", ErrBadRequest)
 	}
+	return parsed, nil
+}
 
-	if !isValidPath(parsed.Path) {
-		return nil, fmt.Errorf("%w: %w", ErrBadRequest, errDotPath)
+// readRequestLine reads request line including line terminator.
+// Byte readers are read byte by byte, so no bytes after the line are consumed.
+func readRequestLine(re io.Reader) ([]byte, error) {
+	var br, isByteReader = re.(io.ByteReader)
+	if !isByteReader {
+		return readUntil(io.LimitReader(re, MaxRequestSize), '\n')
 	}
-
-	if parsed.Path == "" {
-		parsed.Path = "/"
+	var line = make([]byte, 0, MaxRequestSize/4)
+	for int64(len(line)) < MaxRequestSize {
+		var b, errRead = br.ReadByte()
+		switch {
+		case errors.Is(errRead, io.EOF):
+			return line, errors.Join(ErrBadRequest, io.ErrUnexpectedEOF)
+		case errRead != nil:
+			return line, errRead
+		}
+		line = append(line, b)
+		if b == '\n' {
+			return line, nil
+		}
 	}
-
-	return &incomingRequest{
-		url:        parsed,
-		remoteAddr: remoteAddr,
-		certs:      certs,
-	}, nil
+	return line, nil
 }
 
-func isValidPath(path string) bool {
+// ValidPath reports whether the request URL path can be safely served:
+// it must not contain "." and ".." elements, empty elements and NUL bytes.
+// Servers reject requests with invalid paths, so handlers, like FileSystem, rely on it.
+func ValidPath(path string) bool {
+	if strings.ContainsRune(path, 0) {
+		return false
+	}
 	path = strings.TrimPrefix(path, "/")
 	path = strings.TrimSuffix(path, "/")
 
@@ -96,6 +177,7 @@ type incomingRequest struct {
 	url        *url.URL
 	remoteAddr string
 	certs      []*x509.Certificate
+	tls        *tls.ConnectionState
 }
 
 func (req *incomingRequest) URL() *url.URL {
@@ -110,6 +192,11 @@ func (req *incomingRequest) Certificates() []*x509.Certificate {
 	return req.certs
 }
 
+// TLS returns the state of the TLS connection or nil for non-TLS connections.
+func (req *incomingRequest) TLS() *tls.ConnectionState {
+	return req.tls
+}
+
 // - found delimiter -> return data[:delimIndex+1], err
 // - found EOF -> return data, err
 // - found error -> return data, err
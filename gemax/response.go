@@ -1,27 +1,55 @@
 package gemax
 
 import (
-	"errors"
 	"fmt"
 	"io"
-	"strings"
 	"sync"
 
 	"github.com/ninedraft/gemax/gemax/internal/bufwriter"
+	"github.com/ninedraft/gemax/gemax/internal/header"
 	"github.com/ninedraft/gemax/gemax/status"
 )
 
 // ResponseWriter describes a server side response writer.
+// Response writers passed to handlers by Server implement ResponseState and Flusher as well.
 type ResponseWriter interface {
 	WriteStatus(code status.Code, meta string)
 	io.WriteCloser
 }
 
+// ResponseState exposes the state of a response to middlewares.
+type ResponseState interface {
+	// Status returns the written status code. It's 0, if the header is not written yet.
+	Status() status.Code
+	// Meta returns the written response meta.
+	Meta() string
+	// BytesWritten returns the number of written response body bytes.
+	BytesWritten() int64
+	// Written reports whether the response header is written.
+	// Following WriteStatus calls are ignored.
+	Written() bool
+}
+
+// Flusher is implemented by response writers, which can send buffered data to the client.
+// Flush writes the status.Success header, if no header is written yet.
+type Flusher interface {
+	Flush() error
+}
+
+var (
+	_ ResponseState = new(responseWriter)
+	_ Flusher       = new(responseWriter)
+)
+
 type responseWriter struct {
 	status        status.Code
+	meta          string
 	statusWritten bool
 	isClosed      bool
+	bytes         int64
 	writer        *bufwriter.Writer
+	// onStatus is called after the header is written.
+	onStatus func(code status.Code, meta string)
 }
 
 func newResponseWriter(wr io.WriteCloser) *responseWriter {
@@ -37,34 +65,48 @@ func (rw *responseWriter) WriteStatus(code status.Code, meta string) {
 	if code == status.Success && meta == "" {
 		meta = MIMEGemtext
 	}
-	meta = metaSanitizer.Replace(meta)
+	meta = header.SanitizeMeta(meta)
 	_, _ = fmt.Fprintf(rw.writer, "%d %s\r\n", code, meta)
 	rw.status = code
+	rw.meta = meta
 	rw.statusWritten = true
+	if rw.onStatus != nil {
+		rw.onStatus(code, meta)
+	}
 	if code != status.Success {
 		_ = rw.close()
 	}
 }
 
-var metaSanitizer = strings.NewReplacer(
-	"\r\n", "\t",
-	"\n", "\t",
-	"\r", "\t",
-)
-
 func (rw *responseWriter) Write(data []byte) (int, error) {
 	if rw.isClosed {
 		return 0, io.ErrNoProgress
 	}
 	rw.WriteStatus(status.Success, MIMEGemtext)
-	return rw.writer.Write(data)
+	var n, errWrite = rw.writer.Write(data)
+	rw.bytes += int64(n)
+	return n, errWrite
 }
 
-var errAlreadyClosed = errors.New("already closed")
+func (rw *responseWriter) Flush() error {
+	if rw.isClosed {
+		return header.ErrAlreadyClosed
+	}
+	rw.WriteStatus(status.Success, MIMEGemtext)
+	return rw.writer.Flush()
+}
+
+func (rw *responseWriter) Status() status.Code { return rw.status }
+
+func (rw *responseWriter) Meta() string { return rw.meta }
+
+func (rw *responseWriter) BytesWritten() int64 { return rw.bytes }
+
+func (rw *responseWriter) Written() bool { return rw.statusWritten }
 
 func (rw *responseWriter) Close() error {
 	if rw.isClosed {
-		return errAlreadyClosed
+		return header.ErrAlreadyClosed
 	}
 	rw.WriteStatus(status.Success, MIMEGemtext)
 	return rw.close()
@@ -72,7 +114,7 @@ func (rw *responseWriter) Close() error {
 
 func (rw *responseWriter) close() error {
 	if rw.isClosed {
-		return errAlreadyClosed
+		return header.ErrAlreadyClosed
 	}
 
 	rw.isClosed = true
@@ -82,6 +124,96 @@ func (rw *responseWriter) close() error {
 	return errClose
 }
 
+// ResponseWriterWrapper wraps a ResponseWriter and tracks the state of the response,
+// which passes through it. Middlewares can embed it and override some of the methods.
+// Unlike the server response writer, the wrapper doesn't buffer data.
+//
+// Example:
+//
+//	type gzipWriter struct {
+//		*gemax.ResponseWriterWrapper
+//		zw *gzip.Writer
+//	}
+//
+//	func (rw *gzipWriter) Write(data []byte) (int, error) { ... }
+type ResponseWriterWrapper struct {
+	ResponseWriter
+	status  status.Code
+	meta    string
+	bytes   int64
+	written bool
+}
+
+var (
+	_ ResponseState = new(ResponseWriterWrapper)
+	_ Flusher       = new(ResponseWriterWrapper)
+)
+
+// WrapResponseWriter returns a new wrapper of rw.
+func WrapResponseWriter(rw ResponseWriter) *ResponseWriterWrapper {
+	return &ResponseWriterWrapper{ResponseWriter: rw}
+}
+
+// WriteStatus writes the response header. Only the first call is recorded.
+func (rw *ResponseWriterWrapper) WriteStatus(code status.Code, meta string) {
+	if !rw.written {
+		if code == status.Success && meta == "" {
+			meta = MIMEGemtext
+		}
+		rw.status, rw.meta, rw.written = code, meta, true
+	}
+	rw.ResponseWriter.WriteStatus(code, meta)
+}
+
+// Write writes response body. The status.Success header is written implicitly,
+// if no header is written yet.
+func (rw *ResponseWriterWrapper) Write(data []byte) (int, error) {
+	rw.implicitStatus()
+	var n, errWrite = rw.ResponseWriter.Write(data)
+	rw.bytes += int64(n)
+	return n, errWrite
+}
+
+// Close closes the response. The status.Success header is written implicitly,
+// if no header is written yet.
+func (rw *ResponseWriterWrapper) Close() error {
+	rw.implicitStatus()
+	return rw.ResponseWriter.Close()
+}
+
+// Flush flushes the wrapped writer, if it implements Flusher.
+func (rw *ResponseWriterWrapper) Flush() error {
+	var flusher, ok = rw.ResponseWriter.(Flusher)
+	if !ok {
+		return nil
+	}
+	rw.implicitStatus()
+	return flusher.Flush()
+}
+
+// Unwrap returns the wrapped ResponseWriter.
+func (rw *ResponseWriterWrapper) Unwrap() ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Status implements ResponseState.
+func (rw *ResponseWriterWrapper) Status() status.Code { return rw.status }
+
+// Meta implements ResponseState.
+func (rw *ResponseWriterWrapper) Meta() string { return rw.meta }
+
+// BytesWritten implements ResponseState.
+func (rw *ResponseWriterWrapper) BytesWritten() int64 { return rw.bytes }
+
+// Written implements ResponseState.
+func (rw *ResponseWriterWrapper) Written() bool { return rw.written }
+
+func (rw *ResponseWriterWrapper) implicitStatus() {
+	if !rw.written {
+		rw.status, rw.meta, rw.written = status.Success, MIMEGemtext, true
+	}
+}
+
 const writeBufferSize = 4 * 1024
 
 var bufioWriterPool = &sync.Pool{
@@ -0,0 +1,73 @@
+package gemax_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+func TestResponseWriterWrapper(test *testing.T) {
+	test.Run("implicit status", func(test *testing.T) {
+		var rw = gemax.WrapResponseWriter(&responseRecorder{})
+		if rw.Written() {
+			test.Fatal("expected unwritten response")
+		}
+		_, _ = io.WriteString(rw, "hello")
+		rw.WriteStatus(status.NotFound, "ignored")
+		if !rw.Written() || rw.Status() != status.Success || rw.Meta() != gemax.MIMEGemtext || rw.BytesWritten() != 5 {
+			test.Fatalf("unexpected state %s %q %d", rw.Status(), rw.Meta(), rw.BytesWritten())
+		}
+	})
+
+	test.Run("explicit status", func(test *testing.T) {
+		var recorder = &responseRecorder{}
+		var rw = gemax.WrapResponseWriter(recorder)
+		rw.WriteStatus(status.Redirect, "/next")
+		rw.WriteStatus(status.Success, "")
+		if rw.Status() != status.Redirect || rw.Meta() != "/next" || recorder.status != status.Redirect {
+			test.Fatalf("unexpected state %s %q", rw.Status(), rw.Meta())
+		}
+		if rw.Unwrap() != gemax.ResponseWriter(recorder) {
+			test.Fatal("unexpected wrapped writer")
+		}
+	})
+}
+
+func TestServer_ResponseState(test *testing.T) {
+	test.Parallel()
+	type responseState struct {
+		code  status.Code
+		meta  string
+		bytes int64
+	}
+	var states = make(chan responseState, 1)
+	var listener, server = setupServer(test, func(_ context.Context, rw gemax.ResponseWriter, _ gemax.IncomingRequest) {
+		var state = rw.(gemax.ResponseState)
+		var flusher = rw.(gemax.Flusher)
+		if state.Written() {
+			test.Errorf("unexpected written response")
+		}
+		_, _ = io.WriteString(rw, "hello")
+		if err := flusher.Flush(); err != nil {
+			test.Errorf("flush: %v", err)
+		}
+		rw.WriteStatus(status.NotFound, "ignored")
+		states <- responseState{state.Status(), state.Meta(), state.BytesWritten()}
+	})
+	var ctx = test.Context()
+	runTask(test, func() {
+		_ = server.Serve(ctx, listener)
+	})
+	test.Cleanup(func() { _ = listener.Close() })
+
+	var resp = dialAndWrite(test, ctx, listener, "gemini://example.com/\r\n")
+	expectResponse(test, strings.NewReader(resp), "20 text/gemini\r\nhello")
+	var expected = responseState{status.Success, gemax.MIMEGemtext, 5}
+	if got := <-states; got != expected {
+		test.Errorf("expected state %+v, got %+v", expected, got)
+	}
+}
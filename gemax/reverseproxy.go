@@ -0,0 +1,401 @@
+package gemax
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax/internal/bufreader"
+	"github.com/ninedraft/gemax/gemax/proxyproto"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// DefaultHealthCheckInterval is the default interval between upstream health checks.
+const DefaultHealthCheckInterval = 10 * time.Second
+
+const healthCheckTimeout = 5 * time.Second
+
+// Balancing is an upstream selection strategy of ReverseProxy.
+type Balancing int
+
+const (
+	// RoundRobin selects upstreams in turn.
+	RoundRobin Balancing = iota
+	// LeastConnections selects the upstream with the least number of active requests.
+	LeastConnections
+)
+
+// ReverseProxy forwards requests to one of several upstream gemini servers.
+// It can be used to run gemax as a TLS terminator and load balancer.
+//
+// Client certificates can't be forwarded, because their private keys are unknown
+// to the proxy, so the client certificate fingerprint is passed in the PROXY protocol
+// version 2 header as proxyproto.TLVCertFingerprint extension.
+// Upstream handlers get it with ForwardedCertFingerprint. Requests with client
+// certificates are refused, unless ProxyProtocol is proxyproto.V2.
+//
+// Unhealthy upstreams are skipped until they pass a health check
+// or a request to them succeeds.
+// If all upstreams are unavailable, then status.ProxyError is served.
+//
+// Health checks run in a background goroutine, which is started by the first Serve call.
+// Call Close to stop it, when the proxy is not used anymore.
+type ReverseProxy struct {
+	// Upstream server addresses in form of host:port.
+	Upstreams []string
+	// Upstream selection strategy. Default is RoundRobin.
+	Balancing Balancing
+	// PROXY protocol version to be sent to upstreams.
+	// Only proxyproto.V2 carries client certificate fingerprints.
+	//	0 - no PROXY header
+	ProxyProtocol proxyproto.Version
+	// TLS config for upstream connections. It's cloned for each upstream
+	// and ServerName is set to the upstream host, if it's empty.
+	// If nil, then upstream certificates are not verified,
+	// as it's usual for internal gemini servers.
+	TLSConfig *tls.Config
+	// Interval between health checks of upstreams.
+	// Health check is a gemini request of the upstream root,
+	// any valid response means that the upstream is healthy.
+	//	0 - DefaultHealthCheckInterval
+	//	<0 - only failed requests mark upstreams as unhealthy
+	HealthCheckInterval time.Duration
+	// Time limit of connecting to an upstream and of upstream inactivity:
+	// the response header and each chunk of the response body must arrive within the timeout.
+	// Long responses are not interrupted, while the upstream keeps sending data.
+	// The handler context deadline is respected as well.
+	//	0 - DefaultCGITimeout
+	//	<0 - no limitation
+	Timeout time.Duration
+	// Optional custom dialer. It must return a plain connection,
+	// TLS handshake is performed by ReverseProxy. If nil, then net.Dialer is used.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Optional text logger.
+	Logf func(format string, args ...any)
+
+	once      sync.Once
+	upstreams []*upstream
+	next      atomic.Uint64
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+type upstream struct {
+	addr      string
+	tlsConfig *tls.Config
+	active    atomic.Int64
+	unhealthy atomic.Bool
+}
+
+var _ Handler = new(ReverseProxy).Serve
+
+// Serve forwards request to an upstream server and streams its response to the client.
+func (rp *ReverseProxy) Serve(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
+	rp.init()
+	if len(req.Certificates()) > 0 && rp.ProxyProtocol != proxyproto.V2 {
+		rp.logf("ERROR: reverse proxy: client certificate of %s can't be forwarded without PROXY protocol v2", req.RemoteAddr())
+		rw.WriteStatus(status.ProxyError, status.ProxyError.String())
+		return
+	}
+
+	var connectCtx, cancelConnect = backendContext(ctx, rp.Timeout)
+	var up, conn = rp.connect(connectCtx, req)
+	cancelConnect()
+	if conn == nil {
+		rw.WriteStatus(status.ProxyError, status.ProxyError.String())
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	up.active.Add(1)
+	defer up.active.Add(-1)
+	var stop = context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	rp.forward(rw, req, up, rp.timeoutConn(ctx, conn))
+}
+
+// timeoutConn limits the upstream connection by the handler deadline and by Timeout of inactivity.
+func (rp *ReverseProxy) timeoutConn(ctx context.Context, conn net.Conn) *timeoutConn {
+	var deadline, _ = ctx.Deadline()
+	var idle = max(rp.Timeout, 0)
+	if rp.Timeout == 0 {
+		idle = DefaultCGITimeout
+	}
+	return &timeoutConn{
+		Conn:          conn,
+		idle:          idle,
+		readDeadline:  deadline,
+		writeDeadline: deadline,
+	}
+}
+
+// forward sends the request to the upstream and streams its response to the client.
+func (rp *ReverseProxy) forward(rw ResponseWriter, req IncomingRequest, up *upstream, tc *timeoutConn) {
+	if errWrite := writeUpstreamRequest(tc, req); errWrite != nil {
+		rp.logf("ERROR: reverse proxy %s: sending request: %v", up.addr, errWrite)
+		rw.WriteStatus(status.ProxyError, status.ProxyError.String())
+		return
+	}
+	var re = bufreader.New(tc, readerBufSize)
+	var code, meta, errHeader = ParseResponseHeader(re)
+	if errHeader == nil && !validStatus(code) {
+		errHeader = fmt.Errorf("%w: unexpected status code %d", ErrInvalidResponse, code)
+	}
+	if errHeader != nil {
+		rp.logf("ERROR: reverse proxy %s: reading response: %v", up.addr, errHeader)
+		rw.WriteStatus(status.ProxyError, status.ProxyError.String())
+		return
+	}
+	rw.WriteStatus(code, meta)
+	if code != status.Success {
+		return
+	}
+	if _, errCopy := io.Copy(rw, re); errCopy != nil {
+		rp.logf("ERROR: reverse proxy %s: streaming response: %v", up.addr, errCopy)
+	}
+}
+
+// Close stops health checks.
+func (rp *ReverseProxy) Close() error {
+	rp.init()
+	rp.stopOnce.Do(func() { close(rp.stop) })
+	return nil
+}
+
+// connect tries upstreams until a connection is established.
+// Returns nil connection, if all upstreams are unavailable.
+func (rp *ReverseProxy) connect(ctx context.Context, req IncomingRequest) (*upstream, net.Conn) {
+	var tried = make([]bool, len(rp.upstreams))
+	for {
+		var i = rp.pick(tried)
+		if i < 0 {
+			rp.logf("ERROR: reverse proxy: no upstream is available for %s", req.URL())
+			return nil, nil
+		}
+		tried[i] = true
+		var up = rp.upstreams[i]
+		var conn, errConn = rp.dialUpstream(ctx, up, rp.proxyHeader(ctx, req))
+		if errConn == nil {
+			up.unhealthy.Store(false)
+			return up, conn
+		}
+		rp.logf("WARN: reverse proxy: upstream %s: %v", up.addr, errConn)
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		up.unhealthy.Store(true)
+	}
+}
+
+// pick returns index of the next upstream, which is not tried yet.
+// Healthy upstreams are preferred. Returns -1 if all upstreams are tried.
+func (rp *ReverseProxy) pick(tried []bool) int {
+	var n = len(rp.upstreams)
+	var offset = int(rp.next.Add(1) % uint64(max(n, 1)))
+	for _, healthy := range []bool{true, false} {
+		var best = -1
+		for j := range n {
+			var i = (offset + j) % n
+			var up = rp.upstreams[i]
+			if tried[i] || up.unhealthy.Load() == healthy {
+				continue
+			}
+			if rp.Balancing != LeastConnections {
+				return i
+			}
+			if best < 0 || up.active.Load() < rp.upstreams[best].active.Load() {
+				best = i
+			}
+		}
+		if best >= 0 {
+			return best
+		}
+	}
+	return -1
+}
+
+// dialUpstream connects to the upstream, sends optional PROXY header and performs TLS handshake.
+func (rp *ReverseProxy) dialUpstream(ctx context.Context, up *upstream, header *proxyproto.Header) (net.Conn, error) {
+	var conn, errDial = rp.dial(ctx, up.addr)
+	if errDial != nil {
+		return nil, errDial
+	}
+	if header != nil {
+		if _, errHeader := header.WriteTo(conn); errHeader != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("sending PROXY header: %w", errHeader)
+		}
+	}
+
+	var tlsConn = tls.Client(conn, up.tlsConfig)
+	if errHandshake := tlsConn.HandshakeContext(ctx); errHandshake != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("TLS handshake: %w", errHandshake)
+	}
+	return tlsConn, nil
+}
+
+// proxyHeader returns PROXY header for the request or nil, if PROXY protocol is not used.
+func (rp *ReverseProxy) proxyHeader(ctx context.Context, req IncomingRequest) *proxyproto.Header {
+	if rp.ProxyProtocol == 0 {
+		return nil
+	}
+	var header = &proxyproto.Header{
+		Version: rp.ProxyProtocol,
+		Source:  tcpAddr(req.RemoteAddr()),
+	}
+	if incoming, ok := ConnFromContext(ctx); ok {
+		// address the client connected to
+		header.Destination = tcpAddr(incoming.LocalAddr().String())
+	}
+	if certs := req.Certificates(); len(certs) > 0 {
+		header.TLVs = append(header.TLVs, proxyproto.TLV{
+			Type:  proxyproto.TLVCertFingerprint,
+			Value: []byte(CertificateFingerprint(certs[0])),
+		})
+	}
+	return header
+}
+
+// tlsConfig returns TLS config for the upstream.
+func (rp *ReverseProxy) tlsConfig(addr string) *tls.Config {
+	var cfg = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		//nolint:gosec // internal gemini servers usually use self-signed certificates
+		InsecureSkipVerify: true,
+	}
+	if rp.TLSConfig != nil {
+		cfg = rp.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = upstreamHost(addr)
+	}
+	return cfg
+}
+
+func upstreamHost(addr string) string {
+	var host, _, errSplit = net.SplitHostPort(addr)
+	if errSplit != nil {
+		return addr
+	}
+	return host
+}
+
+func (rp *ReverseProxy) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if rp.Dial != nil {
+		return rp.Dial(ctx, "tcp", addr)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+func (rp *ReverseProxy) init() {
+	rp.once.Do(func() {
+		rp.stop = make(chan struct{})
+		rp.upstreams = make([]*upstream, 0, len(rp.Upstreams))
+		for _, addr := range rp.Upstreams {
+			rp.upstreams = append(rp.upstreams, &upstream{addr: addr, tlsConfig: rp.tlsConfig(addr)})
+		}
+		if rp.HealthCheckInterval >= 0 && len(rp.upstreams) > 0 {
+			go rp.healthChecks()
+		}
+	})
+}
+
+func (rp *ReverseProxy) healthChecks() {
+	var interval = rp.HealthCheckInterval
+	if interval == 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rp.stop:
+			return
+		case <-ticker.C:
+		}
+		for _, up := range rp.upstreams {
+			var healthy = rp.healthCheck(up)
+			if up.unhealthy.Swap(!healthy) == healthy {
+				rp.logf("INFO: reverse proxy: upstream %s healthy: %v", up.addr, healthy)
+			}
+		}
+	}
+}
+
+// healthCheck requests the upstream root and checks that a valid response header is received.
+func (rp *ReverseProxy) healthCheck(up *upstream) bool {
+	var ctx, cancel = context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	var header *proxyproto.Header
+	if rp.ProxyProtocol != 0 {
+		header = &proxyproto.Header{Version: rp.ProxyProtocol, Local: true}
+	}
+	var conn, errDial = rp.dialUpstream(ctx, up, header)
+	if errDial != nil {
+		return false
+	}
+	defer func() { _ = conn.Close() }()
+	var deadline, _ = ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	var root = (&url.URL{Scheme: "gemini", Host: up.addr, Path: "/"}).String()
+	if _, errWrite := io.WriteString(conn, root+"\r\n"); errWrite != nil {
+		return false
+	}
+	var code, _, errHeader = ParseResponseHeader(bufreader.New(conn, readerBufSize))
+	return errHeader == nil && validStatus(code)
+}
+
+func (rp *ReverseProxy) logf(format string, args ...any) {
+	if rp.Logf != nil {
+		rp.Logf(format, args...)
+	}
+}
+
+// writeUpstreamRequest writes request line. Titan parameters and upload body are forwarded as well.
+// Titan parameter values are percent-encoded, so ";" and "?" in them can't corrupt the request line.
+func writeUpstreamRequest(wr io.Writer, req IncomingRequest) error {
+	var titan, isTitan = req.(TitanRequest)
+	var line = req.URL().String()
+	if isTitan {
+		var u = *req.URL()
+		var params = titan.TitanParams()
+		var query = u.RawQuery
+		u.RawQuery = ""
+		line = u.String() + ";mime=" + url.PathEscape(params.MIME) + ";size=" + strconv.FormatInt(params.Size, 10)
+		if params.Token != "" {
+			line += ";token=" + url.PathEscape(params.Token)
+		}
+		if query != "" {
+			line += "?" + query
+		}
+	}
+	if _, errWrite := io.WriteString(wr, line+"\r\n"); errWrite != nil {
+		return errWrite
+	}
+	if isTitan {
+		var _, errCopy = io.Copy(wr, titan.Body())
+		return errCopy
+	}
+	return nil
+}
+
+// tcpAddr parses remote address. Unparsable addresses are returned as nil,
+// so PROXY header is written with unknown address family.
+func tcpAddr(addr string) net.Addr {
+	var addrPort, errParse = netip.ParseAddrPort(addr)
+	if errParse != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()))
+}
@@ -0,0 +1,431 @@
+package gemax_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/proxyproto"
+	"github.com/ninedraft/gemax/gemax/status"
+
+	"github.com/ninedraft/gemax/vend/tailscale.com/net/memnet"
+)
+
+func TestReverseProxy(test *testing.T) {
+	var upstreams = map[string]*memnet.Listener{}
+	for _, name := range []string{"a", "b"} {
+		var listener = memnet.Listen(name)
+		upstreams[name] = listener
+		go serveUpstream(test, listener, name)
+		test.Cleanup(func() { _ = listener.Close() })
+	}
+	var dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var listener, ok = upstreams[addr]
+		if !ok {
+			return nil, errors.New("connection refused")
+		}
+		return listener.Dial(ctx, network, addr)
+	}
+	var clientCert, _ = x509.ParseCertificate(testCert("client").Certificate[0])
+
+	var serve = func(rp *gemax.ReverseProxy, certs ...*x509.Certificate) *responseRecorder {
+		var rw = &responseRecorder{}
+		var req = &request{
+			remoteAddr: "192.0.2.1:4000",
+			url:        "gemini://example.com/page",
+			certs:      certs,
+		}
+		rp.Serve(context.Background(), rw, req)
+		return rw
+	}
+
+	test.Run("round robin", func(test *testing.T) {
+		var rp = &gemax.ReverseProxy{
+			Upstreams:           []string{"a", "b"},
+			HealthCheckInterval: -1,
+			Dial:                dial,
+			Logf:                test.Logf,
+		}
+		var got []string
+		for range 4 {
+			var rw = serve(rp)
+			if rw.status != status.Success {
+				test.Fatalf("expected %s, got %s", status.Success, rw.status)
+			}
+			got = append(got, strings.Fields(rw.String())[0])
+		}
+		if got[0] == got[1] || got[0] != got[2] || got[1] != got[3] {
+			test.Fatalf("upstreams are not selected in turn: %q", got)
+		}
+	})
+
+	test.Run("upstream is down", func(test *testing.T) {
+		var rp = &gemax.ReverseProxy{
+			Upstreams:           []string{"down", "b"},
+			HealthCheckInterval: -1,
+			Dial:                dial,
+			Logf:                test.Logf,
+		}
+		for range 3 {
+			var rw = serve(rp)
+			if rw.status != status.Success || !strings.HasPrefix(rw.String(), "b ") {
+				test.Fatalf("expected response from b, got %s %q", rw.status, rw.String())
+			}
+		}
+	})
+
+	test.Run("upstream recovers", func(test *testing.T) {
+		// remaining dial failures of upstreams
+		var failures = map[string]int{"flaky": 1, "b": 0}
+		var rp = &gemax.ReverseProxy{
+			Upstreams:           []string{"flaky", "b"},
+			HealthCheckInterval: -1,
+			Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if failures[addr] > 0 {
+					failures[addr]--
+					return nil, errors.New("connection refused")
+				}
+				if addr == "flaky" {
+					addr = "a"
+				}
+				return dial(ctx, network, addr)
+			},
+			Logf: test.Logf,
+		}
+		// flaky is marked as unhealthy
+		for range 2 {
+			if rw := serve(rp); !strings.HasPrefix(rw.String(), "b ") {
+				test.Fatalf("expected response from b, got %s %q", rw.status, rw.String())
+			}
+		}
+		// b is marked as unhealthy, flaky serves the request and becomes healthy again
+		failures["b"] = 1
+		if rw := serve(rp); !strings.HasPrefix(rw.String(), "a ") {
+			test.Fatalf("expected response from flaky, got %s %q", rw.status, rw.String())
+		}
+		for range 3 {
+			if rw := serve(rp); !strings.HasPrefix(rw.String(), "a ") {
+				test.Fatalf("expected response from healthy flaky, got %s %q", rw.status, rw.String())
+			}
+		}
+	})
+
+	test.Run("titan parameters", func(test *testing.T) {
+		var rp = &gemax.ReverseProxy{
+			Upstreams:           []string{"a"},
+			HealthCheckInterval: -1,
+			Dial:                dial,
+			Logf:                test.Logf,
+		}
+		var req, errParse = gemax.ParseIncomingRequest(strings.NewReader(
+			"titan://example.com/page;mime=text/plain%3B%20charset=utf-8;size=0;token=a%3Bb%3Fc?q\r\n"), "192.0.2.1:4000")
+		if errParse != nil {
+			test.Fatal(errParse)
+		}
+		var rw = &responseRecorder{}
+		rp.Serve(context.Background(), rw, req)
+		var expected = "a titan://example.com/page;mime=text%2Fplain%3B%20charset=utf-8;size=0;token=a%3Bb%3Fc?q"
+		if rw.String() != expected {
+			test.Fatalf("expected %q, got %q", expected, rw.String())
+		}
+	})
+
+	test.Run("all upstreams are down", func(test *testing.T) {
+		var rp = &gemax.ReverseProxy{
+			Upstreams:           []string{"down"},
+			HealthCheckInterval: -1,
+			Dial:                dial,
+			Logf:                test.Logf,
+		}
+		var rw = serve(rp)
+		if rw.status != status.ProxyError {
+			test.Fatalf("expected %s, got %s", status.ProxyError, rw.status)
+		}
+	})
+
+	test.Run("PROXY header", func(test *testing.T) {
+		var rp = &gemax.ReverseProxy{
+			Upstreams:           []string{"a"},
+			ProxyProtocol:       proxyproto.V2,
+			HealthCheckInterval: -1,
+			Dial:                dial,
+			Logf:                test.Logf,
+		}
+		var rw = serve(rp, clientCert)
+		var expected = "a gemini://example.com/page 192.0.2.1:4000 " + gemax.CertificateFingerprint(clientCert)
+		if rw.String() != expected {
+			test.Fatalf("expected %q, got %q", expected, rw.String())
+		}
+	})
+
+	test.Run("certificate without PROXY v2", func(test *testing.T) {
+		var rp = &gemax.ReverseProxy{
+			Upstreams:           []string{"a"},
+			ProxyProtocol:       proxyproto.V1,
+			HealthCheckInterval: -1,
+			Dial:                dial,
+			Logf:                test.Logf,
+		}
+		var rw = serve(rp, clientCert)
+		if rw.status != status.ProxyError {
+			test.Fatalf("expected %s, got %s", status.ProxyError, rw.status)
+		}
+	})
+}
+
+func TestReverseProxy_ForwardedCertFingerprint(test *testing.T) {
+	var cert, errCert = tls.LoadX509KeyPair("testdata/cert.pem", "testdata/key.pem")
+	if errCert != nil {
+		test.Fatal(errCert)
+	}
+	var tlsConfig = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+	}
+	var tcpConns = func(src, dst string) func(string, string, int) (memnet.Conn, memnet.Conn) {
+		return func(_, _ string, maxBuf int) (memnet.Conn, memnet.Conn) {
+			return memnet.NewTCPConn(netip.MustParseAddrPort(src), netip.MustParseAddrPort(dst), maxBuf)
+		}
+	}
+	var ctx = test.Context()
+
+	var upstreamListener = memnet.Listen("upstream")
+	upstreamListener.NewConn = tcpConns("10.0.0.1:40000", "10.0.0.2:1965")
+	var upstream = &gemax.Server{
+		Logf: test.Logf,
+		Handler: func(ctx context.Context, rw gemax.ResponseWriter, req gemax.IncomingRequest) {
+			var fingerprint, _ = gemax.ForwardedCertFingerprint(ctx)
+			var conn, _ = gemax.ConnFromContext(ctx)
+			_, _ = fmt.Fprintf(rw, "%s %s %s", req.RemoteAddr(), conn.LocalAddr(), fingerprint)
+		},
+	}
+	runTask(test, func() {
+		_ = upstream.Serve(ctx, tls.NewListener(&proxyproto.Listener{
+			Listener: upstreamListener,
+			Trusted:  []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")},
+		}, tlsConfig))
+	})
+	test.Cleanup(func() { _ = upstreamListener.Close() })
+
+	var rp = &gemax.ReverseProxy{
+		Upstreams:           []string{"upstream"},
+		ProxyProtocol:       proxyproto.V2,
+		HealthCheckInterval: -1,
+		Dial:                upstreamListener.Dial,
+		Logf:                test.Logf,
+	}
+	var frontListener = memnet.Listen("front")
+	frontListener.NewConn = tcpConns("192.0.2.1:4000", "198.51.100.1:1965")
+	var front = &gemax.Server{Handler: rp.Serve, Logf: test.Logf}
+	runTask(test, func() {
+		_ = front.Serve(ctx, tls.NewListener(frontListener, tlsConfig))
+	})
+	test.Cleanup(func() { _ = frontListener.Close() })
+
+	var conn, errDial = frontListener.Dial(ctx, "tcp", "front")
+	if errDial != nil {
+		test.Fatal(errDial)
+	}
+	var clientCert = testCert("client")
+	var tlsConn = tls.Client(conn, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{clientCert},
+		//nolint:gosec // test server uses self-signed certificate
+		InsecureSkipVerify: true,
+	})
+	defer func() { _ = tlsConn.Close() }()
+	_, _ = io.WriteString(tlsConn, "gemini://example.com/\r\n")
+
+	var x509Cert, _ = x509.ParseCertificate(clientCert.Certificate[0])
+	expectResponse(test, tlsConn, "20 text/gemini\r\n192.0.2.1:4000 198.51.100.1:1965 "+gemax.CertificateFingerprint(x509Cert))
+}
+
+func TestReverseProxy_IdleTimeout(test *testing.T) {
+	var cert, errCert = tls.LoadX509KeyPair("testdata/cert.pem", "testdata/key.pem")
+	if errCert != nil {
+		test.Fatal(errCert)
+	}
+	const interval = 50 * time.Millisecond
+	var listener = memnet.Listen("slow")
+	test.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		var conn, errAccept = listener.Accept()
+		if errAccept != nil {
+			return
+		}
+		var tlsConn = tls.Server(conn, &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		})
+		defer func() { _ = tlsConn.Close() }()
+		if _, errLine := bufio.NewReader(tlsConn).ReadString('\n'); errLine != nil {
+			return
+		}
+		_, _ = io.WriteString(tlsConn, "20 text/plain\r\n")
+		for range 5 {
+			time.Sleep(interval)
+			_, _ = io.WriteString(tlsConn, "chunk\n")
+		}
+	}()
+
+	// the whole response takes longer than the timeout, but the upstream is never idle for that long
+	var rp = &gemax.ReverseProxy{
+		Upstreams:           []string{"slow"},
+		HealthCheckInterval: -1,
+		Timeout:             3 * interval,
+		Dial:                listener.Dial,
+		Logf:                test.Logf,
+	}
+	var rw = &responseRecorder{}
+	rp.Serve(context.Background(), rw, &request{url: "gemini://example.com/"})
+	if rw.status != status.Success || rw.String() != strings.Repeat("chunk\n", 5) {
+		test.Fatalf("unexpected response %s %q", rw.status, rw.String())
+	}
+}
+
+func TestReverseProxy_HealthCheck(test *testing.T) {
+	var cert, errCert = tls.LoadX509KeyPair("testdata/cert.pem", "testdata/key.pem")
+	if errCert != nil {
+		test.Fatal(errCert)
+	}
+	type healthRequest struct{ serverName, line string }
+	var requests = make(chan healthRequest, 16)
+	var listener = memnet.Listen("upstream.local:1965")
+	test.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			var conn, errAccept = listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			var tlsConn = tls.Server(conn, &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{cert},
+			})
+			var line, errLine = bufio.NewReader(tlsConn).ReadString('\n')
+			if errLine == nil {
+				_, _ = io.WriteString(tlsConn, "53 host not served\r\n")
+				requests <- healthRequest{serverName: tlsConn.ConnectionState().ServerName, line: line}
+			}
+			_ = tlsConn.Close()
+		}
+	}()
+
+	var tlsConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		//nolint:gosec // test certificate is self-signed
+		InsecureSkipVerify: true,
+	}
+	var rp = &gemax.ReverseProxy{
+		Upstreams:           []string{"upstream.local:1965"},
+		TLSConfig:           tlsConfig,
+		HealthCheckInterval: 10 * time.Millisecond,
+		Dial:                listener.Dial,
+		Logf:                test.Logf,
+	}
+	rp.Serve(context.Background(), &responseRecorder{}, &request{url: "gemini://example.com/"})
+	defer func() { _ = rp.Close() }()
+
+	var timeout = time.After(5 * time.Second)
+	for {
+		var req healthRequest
+		select {
+		case req = <-requests:
+		case <-timeout:
+			test.Fatal("upstream is not checked")
+		}
+		if req.serverName != "upstream.local" {
+			test.Errorf("expected server name %q, got %q", "upstream.local", req.serverName)
+		}
+		if req.line == "gemini://upstream.local:1965/\r\n" {
+			break
+		}
+	}
+	if tlsConfig.ServerName != "" {
+		test.Errorf("shared TLS config is modified")
+	}
+}
+
+// serveUpstream serves gemini requests, which are optionally prefixed with PROXY v2 header.
+// Response contains upstream name, request URL, source address and certificate fingerprint.
+func serveUpstream(t *testing.T, listener net.Listener, name string) {
+	var cert, errCert = tls.LoadX509KeyPair("testdata/cert.pem", "testdata/key.pem")
+	if errCert != nil {
+		t.Error(errCert)
+		return
+	}
+	for {
+		var conn, errAccept = listener.Accept()
+		if errAccept != nil {
+			return
+		}
+		go func() {
+			defer func() { _ = conn.Close() }()
+			var re = bufio.NewReader(conn)
+			var source, fingerprint, errHeader = readProxyV2(re)
+			if errHeader != nil {
+				t.Errorf("reading PROXY header: %v", errHeader)
+				return
+			}
+			var tlsConn = tls.Server(&bufferedConn{Conn: conn, re: re}, &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{cert},
+			})
+			var line, errLine = bufio.NewReader(tlsConn).ReadString('\n')
+			if errLine != nil {
+				return
+			}
+			var response = strings.TrimSpace(name + " " + strings.TrimSpace(line) + " " + source + " " + fingerprint)
+			_, _ = fmt.Fprintf(tlsConn, "20 text/gemini\r\n%s", response)
+			_ = tlsConn.Close()
+		}()
+	}
+}
+
+func readProxyV2(re *bufio.Reader) (source, fingerprint string, err error) {
+	var signature, _ = re.Peek(12)
+	if string(signature) != "\r\n\r\n\x00\r\nQUIT\n" {
+		return "", "", nil
+	}
+	var head = make([]byte, 16)
+	if _, err := io.ReadFull(re, head); err != nil {
+		return "", "", err
+	}
+	var payload = make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(re, payload); err != nil {
+		return "", "", err
+	}
+	if head[13] == 0x11 {
+		source = fmt.Sprintf("%s:%d", net.IP(payload[:4]), binary.BigEndian.Uint16(payload[8:]))
+		payload = payload[12:]
+	}
+	for len(payload) >= 3 {
+		var size = int(binary.BigEndian.Uint16(payload[1:]))
+		if payload[0] == proxyproto.TLVCertFingerprint {
+			fingerprint = string(payload[3 : 3+size])
+		}
+		payload = payload[3+size:]
+	}
+	return source, fingerprint, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	re *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(data []byte) (int, error) {
+	return conn.re.Read(data)
+}
@@ -0,0 +1,220 @@
+package gemax
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// DefaultSCGIMaxConnections is the default limit of simultaneous SCGI backend connections.
+const DefaultSCGIMaxConnections = 32
+
+// SCGI forwards requests to a long-running SCGI backend.
+// The backend gets the same variables as CGI scripts in the netstring encoded header block
+// and writes a complete gemini response, header included.
+//
+// SCGI connections are single-use by specification, so SCGI pools connection slots:
+// at most MaxConnections backend connections are open at the same time,
+// and requests wait for a free slot until the handler context is done.
+//
+// Unreachable backends are reported as status.ProxyError,
+// malformed backend responses and request variables containing NUL bytes as status.CGIError.
+type SCGI struct {
+	// Backend network: "tcp" or "unix". Default is "tcp".
+	Network string
+	// Backend address: host:port or a unix socket path.
+	Addr string
+	// URL path of the application. It's used to compute SCRIPT_NAME and PATH_INFO.
+	ScriptName string
+	// Additional variables in form of "KEY=value".
+	Env []string
+	// Maximum number of simultaneous backend connections.
+	//	0 - DefaultSCGIMaxConnections
+	//	<0 - no limitation
+	MaxConnections int
+	// Time limit of a single backend transaction.
+	// The earliest of the handler context deadline and the timeout is used.
+	//	0 - DefaultCGITimeout
+	//	<0 - no limitation
+	Timeout time.Duration
+	// Optional custom dialer. If nil, then net.Dialer is used.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Optional text logger.
+	Logf func(format string, args ...any)
+
+	once  sync.Once
+	slots chan struct{}
+}
+
+var _ Handler = new(SCGI).Serve
+
+// Serve forwards request to the SCGI backend and streams its response to the client.
+func (scgi *SCGI) Serve(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
+	scgi.init()
+	ctx, cancel := backendContext(ctx, scgi.Timeout)
+	defer cancel()
+
+	var headers, body, errHeaders = scgi.requestHeaders(req)
+	switch {
+	case errors.Is(errHeaders, errInvalidSCGIHeader):
+		scgi.logf("ERROR: scgi %s: %v", scgi.Addr, errHeaders)
+		rw.WriteStatus(status.CGIError, status.CGIError.String())
+		return
+	case errHeaders != nil:
+		scgi.logf("ERROR: scgi %s: sending request: %v", scgi.Addr, errHeaders)
+		rw.WriteStatus(status.ProxyError, status.ProxyError.String())
+		return
+	}
+
+	if scgi.slots != nil {
+		select {
+		case scgi.slots <- struct{}{}:
+			defer func() { <-scgi.slots }()
+		case <-ctx.Done():
+			scgi.logf("ERROR: scgi %s: waiting for connection slot: %v", scgi.Addr, ctx.Err())
+			rw.WriteStatus(status.ProxyError, status.ProxyError.String())
+			return
+		}
+	}
+
+	var conn, errDial = scgi.dial(ctx)
+	if errDial != nil {
+		scgi.logf("ERROR: scgi %s: connecting to backend: %v", scgi.Addr, errDial)
+		rw.WriteStatus(status.ProxyError, status.ProxyError.String())
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	ctxConnDeadline(ctx, conn)
+	var stop = context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	if errWrite := writeSCGIRequest(conn, headers, body); errWrite != nil {
+		scgi.logf("ERROR: scgi %s: sending request: %v", scgi.Addr, errWrite)
+		rw.WriteStatus(status.ProxyError, status.ProxyError.String())
+		return
+	}
+	if errServe := serveCGIResponse(rw, bufio.NewReader(conn)); errServe != nil {
+		scgi.logf("ERROR: scgi %s: %v", scgi.Addr, errServe)
+	}
+}
+
+// requestHeaders encodes request variables as a SCGI header block.
+// Variables are NUL delimited, so a NUL byte in a key or a value,
+// e.g. in a client certificate subject, could forge other variables and is rejected.
+func (scgi *SCGI) requestHeaders(req IncomingRequest) ([]byte, io.Reader, error) {
+	var body, size, errBody = backendBody(req)
+	if errBody != nil {
+		return nil, nil, errBody
+	}
+	var headers bytes.Buffer
+	var header = func(key, value string) {
+		headers.WriteString(key)
+		headers.WriteByte(0)
+		headers.WriteString(value)
+		headers.WriteByte(0)
+	}
+	header("CONTENT_LENGTH", strconv.FormatInt(size, 10))
+	header("SCGI", "1")
+	for _, kv := range append(cgiEnv(req, scgi.ScriptName), scgi.Env...) {
+		var key, value, _ = strings.Cut(kv, "=")
+		if key == "CONTENT_LENGTH" {
+			continue
+		}
+		if strings.IndexByte(kv, 0) >= 0 {
+			return nil, nil, fmt.Errorf("%w: variable %q contains NUL byte", errInvalidSCGIHeader, key)
+		}
+		header(key, value)
+	}
+	return headers.Bytes(), body, nil
+}
+
+var errInvalidSCGIHeader = errors.New("invalid SCGI header")
+
+func writeSCGIRequest(conn net.Conn, headers []byte, body io.Reader) error {
+	var wr = bufio.NewWriter(conn)
+	_, _ = fmt.Fprintf(wr, "%d:", len(headers))
+	_, _ = wr.Write(headers)
+	_ = wr.WriteByte(',')
+	if body != nil {
+		if _, errCopy := io.Copy(wr, body); errCopy != nil {
+			return fmt.Errorf("sending body: %w", errCopy)
+		}
+	}
+	return wr.Flush()
+}
+
+func (scgi *SCGI) dial(ctx context.Context) (net.Conn, error) {
+	var network = scgi.Network
+	if network == "" {
+		network = "tcp"
+	}
+	if scgi.Dial != nil {
+		return scgi.Dial(ctx, network, scgi.Addr)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, scgi.Addr)
+}
+
+func (scgi *SCGI) init() {
+	scgi.once.Do(func() {
+		var n = scgi.MaxConnections
+		if n == 0 {
+			n = DefaultSCGIMaxConnections
+		}
+		if n > 0 {
+			scgi.slots = make(chan struct{}, n)
+		}
+	})
+}
+
+func (scgi *SCGI) logf(format string, args ...any) {
+	if scgi.Logf != nil {
+		scgi.Logf(format, args...)
+	}
+}
+
+// backendContext limits backend transaction with timeout.
+//
+//	0 - DefaultCGITimeout
+//	<0 - no limitation
+func backendContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	switch {
+	case timeout < 0:
+		return context.WithCancel(ctx)
+	case timeout == 0:
+		timeout = DefaultCGITimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+var errBodyTooLarge = errors.New("request body is too large")
+
+// backendBody returns request body and its size for backends, which require content length.
+// Bodies of unknown size are read into memory, they are expected to be limited by the server.
+func backendBody(req IncomingRequest) (io.Reader, int64, error) {
+	if titan, ok := req.(TitanRequest); ok {
+		return titan.Body(), titan.TitanParams().Size, nil
+	}
+	var body = RequestBody(req)
+	if body == nil {
+		return nil, 0, nil
+	}
+	var data, errRead = io.ReadAll(io.LimitReader(body, DefaultMaxUploadSize+1))
+	switch {
+	case errRead != nil:
+		return nil, 0, fmt.Errorf("reading request body: %w", errRead)
+	case len(data) > DefaultMaxUploadSize:
+		return nil, 0, errBodyTooLarge
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
@@ -0,0 +1,141 @@
+package gemax_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+
+	"github.com/ninedraft/gemax/vend/tailscale.com/net/memnet"
+)
+
+func TestSCGI(test *testing.T) {
+	var listener = memnet.Listen(test.Name())
+	defer func() { _ = listener.Close() }()
+	go serveSCGI(test, listener, func(headers map[string]string, wr io.Writer) {
+		switch headers["PATH_INFO"] {
+		case "/hello":
+			_, _ = fmt.Fprintf(wr, "20 text/plain\r\n%s %s %s", headers["SCGI"], headers["CONTENT_LENGTH"], headers["GEMINI_URL"])
+		default:
+			_, _ = io.WriteString(wr, "oops")
+		}
+	})
+
+	var scgi = &gemax.SCGI{
+		Addr:       test.Name(),
+		ScriptName: "/app",
+		Dial:       listener.Dial,
+		Logf:       test.Logf,
+	}
+	var tc = func(name, path string, expected status.Code, expectedBody string) {
+		test.Run(name, func(test *testing.T) {
+			var rw = &responseRecorder{}
+			var req = &request{
+				remoteAddr: test.Name(),
+				url:        "gemini://example.com/app" + path,
+			}
+
+			scgi.Serve(context.Background(), rw, req)
+
+			if rw.status != expected {
+				test.Fatalf("expected %s, got %s %q", expected, rw.status, rw.meta)
+			}
+			if rw.String() != expectedBody {
+				test.Fatalf("expected body %q, got %q", expectedBody, rw.String())
+			}
+		})
+	}
+
+	tc("success", "/hello", status.Success, "1 0 gemini://example.com/app/hello")
+	tc("malformed response", "/oops", status.CGIError, "")
+
+	test.Run("NUL in certificate subject", func(test *testing.T) {
+		var cert, errCert = x509.ParseCertificate(testCert("x\x00TLS_CLIENT_HASH\x00SHA256:victim").Certificate[0])
+		if errCert != nil {
+			test.Fatal(errCert)
+		}
+		var rw = &responseRecorder{}
+		var req = &request{
+			remoteAddr: test.Name(),
+			url:        "gemini://example.com/app/hello",
+			certs:      []*x509.Certificate{cert},
+		}
+
+		scgi.Serve(context.Background(), rw, req)
+
+		if rw.status != status.CGIError {
+			test.Fatalf("expected %s, got %s %q", status.CGIError, rw.status, rw.meta)
+		}
+		if rw.Len() != 0 {
+			test.Fatalf("unexpected body %q", rw.String())
+		}
+	})
+
+	test.Run("backend unavailable", func(test *testing.T) {
+		var unavailable = &gemax.SCGI{
+			Addr: test.Name(),
+			Dial: func(context.Context, string, string) (net.Conn, error) {
+				return nil, errors.New("connection refused")
+			},
+		}
+		var rw = &responseRecorder{}
+		unavailable.Serve(context.Background(), rw, &request{url: "gemini://example.com/app"})
+		if rw.status != status.ProxyError {
+			test.Fatalf("expected %s, got %s", status.ProxyError, rw.status)
+		}
+	})
+}
+
+func serveSCGI(t *testing.T, listener net.Listener, handle func(headers map[string]string, wr io.Writer)) {
+	for {
+		var conn, errAccept = listener.Accept()
+		if errAccept != nil {
+			return
+		}
+		go func() {
+			defer func() { _ = conn.Close() }()
+			var headers, errHeaders = readNetstringHeaders(bufio.NewReader(conn))
+			if errHeaders != nil {
+				t.Errorf("reading scgi headers: %v", errHeaders)
+				return
+			}
+			handle(headers, conn)
+		}()
+	}
+}
+
+func readNetstringHeaders(re *bufio.Reader) (map[string]string, error) {
+	var rawSize, errSize = re.ReadString(':')
+	if errSize != nil {
+		return nil, errSize
+	}
+	var size, errParse = strconv.Atoi(strings.TrimSuffix(rawSize, ":"))
+	if errParse != nil {
+		return nil, errParse
+	}
+	var block = make([]byte, size+1)
+	if _, err := io.ReadFull(re, block); err != nil {
+		return nil, err
+	}
+	if block[size] != ',' {
+		return nil, errors.New("missing netstring terminator")
+	}
+	var fields = strings.Split(string(block[:size]), "\x00")
+	if len(fields) < 4 || fields[0] != "CONTENT_LENGTH" {
+		return nil, fmt.Errorf("CONTENT_LENGTH must be the first header: %q", fields)
+	}
+	var headers = map[string]string{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		headers[fields[i]] = fields[i+1]
+	}
+	return headers, nil
+}
@@ -1,22 +1,56 @@
 package gemax
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"net/netip"
 	"net/url"
+	"os"
 	"runtime/debug"
 	"sync"
+	"time"
 
+	"github.com/ninedraft/gemax/gemax/internal/connserver"
+	"github.com/ninedraft/gemax/gemax/internal/hostset"
+	"github.com/ninedraft/gemax/gemax/proxyproto"
 	"github.com/ninedraft/gemax/gemax/status"
-	"golang.org/x/net/netutil"
 )
 
 // DefaultMaxConnections default number of maximum connections.
-const DefaultMaxConnections = 256
+const DefaultMaxConnections = connserver.DefaultMaxConnections
+
+const (
+	// DefaultHandshakeTimeout is the default time limit of TLS handshake.
+	DefaultHandshakeTimeout = 10 * time.Second
+	// DefaultReadRequestTimeout is the default time limit of reading request line.
+	DefaultReadRequestTimeout = 10 * time.Second
+	// DefaultUploadTimeout is the default time limit of reading titan upload body.
+	DefaultUploadTimeout = time.Minute
+)
+
+var (
+	// ErrServerClosed is the cause of handler context cancellation,
+	// when the connection is closed by Server.Stop or Server.Shutdown.
+	ErrServerClosed = errors.New("gemini server closed")
+	// ErrHandlerTimeout is the cause of handler context cancellation,
+	// when Server.HandlerTimeout elapses.
+	ErrHandlerTimeout = errors.New("handler timeout")
+)
 
 // Handler describes a gemini protocol handler.
+// Each request gets its own context, which is canceled, when:
+//   - the client closes the connection, the cause is ErrClientDisconnected.
+//     Titan uploads are not watched, because the upload body is read by the handler;
+//   - response write fails, the cause wraps ErrClientDisconnected and the write error;
+//   - the connection is closed by Server.Stop or Server.Shutdown, the cause is ErrServerClosed;
+//   - Server.HandlerTimeout elapses, the cause is ErrHandlerTimeout.
+//
+// Use context.Cause to get the cause and RequestFromContext to get the request.
 type Handler func(ctx context.Context, rw ResponseWriter, req IncomingRequest)
 
 // Server is gemini protocol server.
@@ -24,29 +58,92 @@ type Server struct {
 	Addr string
 	// Hosts expected by server.
 	// If empty, then every host will be valid.
-	Hosts       []string
-	Handler     Handler
+	Hosts   []string
+	Handler Handler
+	// ProxyHandler serves requests for hosts not listed in Hosts
+	// and requests with non-gemini schemes. See Proxy.
+	// If nil, then requests for unknown hosts are rejected.
+	ProxyHandler Handler
+	// ConnContext optionally modifies the context of a new connection.
+	// Provided context contains the server, the connection and TLS state values.
+	// See ServerFromContext, ConnFromContext and TLSStateFromContext.
 	ConnContext func(ctx context.Context, conn net.Conn) context.Context
-	Logf        func(format string, args ...any)
+	// Optional printf-style text logger.
+	// Messages, which were logged before Logger was added, keep their format.
+	// Newer messages are formatted as "LEVEL: message key=value ..." lines.
+	Logf func(format string, args ...any)
+	// Optional structured logger. It can be used along with Logf.
+	// Served requests are logged to Logger only with remote_addr, url, host, status, bytes,
+	// duration and cert_fingerprint attributes.
+	Logger *slog.Logger
+	// Optional metrics receiver. See Metrics.
+	Metrics Metrics
+	// Optional connection tracing hooks. See ServerTrace.
+	Trace *ServerTrace
 
 	// Maximum number of simultaneous connections served by Server.
 	//	0 - DefaultMaxConnections
 	//	<0 - no limitation
 	MaxConnections int
+	// Maximum number of simultaneous connections from a single remote IP.
+	//	0 - no limitation
+	MaxConnectionsPerIP int
+	// DenyList rejects connections from denied networks before the TLS handshake.
+	// It can be modified while the server is running.
+	// If nil, then all connections are accepted.
+	DenyList *DenyList
+	// Time limit of TLS handshake.
+	//	0 - DefaultHandshakeTimeout
+	//	<0 - no limitation
+	HandshakeTimeout time.Duration
+	// Time limit of reading request line after the handshake.
+	// Connections, which don't send a request in time, are closed without response.
+	//	0 - DefaultReadRequestTimeout
+	//	<0 - no limitation
+	ReadRequestTimeout time.Duration
+	// Time limit of handling request and writing response after the request is read,
+	// titan upload body reading included. Handler context deadline is derived from it.
+	//	<=0 - no limitation
+	WriteTimeout time.Duration
+	// Time limit of handler execution. Unlike WriteTimeout, it cancels only the handler context,
+	// so the handler can still respond with an error.
+	//	<=0 - no limitation
+	HandlerTimeout time.Duration
+	// Maximum time of connection inactivity: each read and write must make progress
+	// within the timeout. It's useful for long streaming responses without WriteTimeout.
+	//	<=0 - no limitation
+	IdleTimeout time.Duration
+	// ProxyProtocol enables parsing of PROXY protocol v1 and v2 headers
+	// of connections accepted by ListenAndServe.
+	// Handlers and logs get original client addresses. See proxyproto.Listener.
+	ProxyProtocol bool
+	// TrustedProxies limits sources, which must send PROXY headers.
+	// Connections from other sources are served as direct ones.
+	// It must not be empty, if ProxyProtocol is enabled.
+	TrustedProxies []netip.Prefix
+	// Maximum size of titan uploads in bytes.
+	//	0 - DefaultMaxUploadSize
+	//	<0 - uploads are rejected
+	MaxUploadSize int64
+	// Time limit of reading titan upload body after the request line is read.
+	// Reads of the body fail after the timeout, even if WriteTimeout is not set.
+	//	0 - DefaultUploadTimeout
+	//	<0 - no limitation
+	UploadTimeout time.Duration
 
-	mu        sync.RWMutex
-	conns     map[*connTrack]struct{}
-	listeners map[net.Listener]struct{}
+	srv   connserver.Server
+	mu    sync.Mutex
+	perIP map[netip.Addr]int
 
 	once  sync.Once
-	hosts map[string]struct{}
+	hosts hostset.Set
 }
 
 func (server *Server) init() {
 	server.once.Do(func() {
-		server.conns = map[*connTrack]struct{}{}
-		server.listeners = map[net.Listener]struct{}{}
-		server.buildHosts()
+		server.srv.StopCause = ErrServerClosed
+		server.perIP = map[netip.Addr]int{}
+		server.hosts = hostset.New(server.Hosts)
 	})
 }
 
@@ -56,6 +153,9 @@ func (server *Server) init() {
 // It will await all running handlers to end.
 func (server *Server) ListenAndServe(ctx context.Context, tlsCfg *tls.Config) error {
 	server.init()
+	if server.ProxyProtocol && len(server.TrustedProxies) == 0 {
+		return errors.New("gemini server: ProxyProtocol requires TrustedProxies")
+	}
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	var lc = net.ListenConfig{}
@@ -65,10 +165,14 @@ func (server *Server) ListenAndServe(ctx context.Context, tlsCfg *tls.Config) er
 		return fmt.Errorf("creating listener: %w", errListener)
 	}
 
-	if n := server.maxConnections(); n >= 0 {
-		var limited = netutil.LimitListener(tcpListener, n)
-		server.addListener(limited)
-		tcpListener = limited
+	server.srv.MaxConnections = server.MaxConnections
+	tcpListener = server.srv.Limit(tcpListener)
+
+	if server.ProxyProtocol {
+		tcpListener = &proxyproto.Listener{
+			Listener: tcpListener,
+			Trusted:  server.TrustedProxies,
+		}
 	}
 
 	var listener = tls.NewListener(tcpListener, tlsCfg)
@@ -76,7 +180,6 @@ func (server *Server) ListenAndServe(ctx context.Context, tlsCfg *tls.Config) er
 		<-ctx.Done()
 		_ = listener.Close()
 	}()
-	server.addListener(listener)
 	defer ignoreErr(listener.Close)
 	return server.Serve(ctx, listener)
 }
@@ -85,83 +188,217 @@ func (server *Server) ListenAndServe(ctx context.Context, tlsCfg *tls.Config) er
 // Serve will await all running handlers to end.
 func (server *Server) Serve(ctx context.Context, listener net.Listener) error {
 	server.init()
-	server.addListener(listener)
-	var wg sync.WaitGroup
-	for {
-		var conn, errAccept = listener.Accept()
-		if errAccept != nil {
-			wg.Wait()
-			return fmt.Errorf("gemini server: %w", errAccept)
-		}
-		var track = server.addConn(conn)
-		wg.Go(func() {
-			defer server.removeTrack(track)
+	var errServe = server.srv.Serve(ctx, listener, server.serveConn)
+	return fmt.Errorf("gemini server: %w", errServe)
+}
 
-			if err := handshake(ctx, conn); err != nil {
-				server.logf("WARN: handshake with %q failed: %v", conn.RemoteAddr(), err)
-				return
-			}
+func (server *Server) serveConn(ctx context.Context, conn net.Conn) {
+	var metrics = server.metrics()
+	metrics.ConnAccepted()
+	// trace hooks may call RemoteAddr, which blocks on PROXY header reading
+	server.Trace.accepted(conn)
+	defer metrics.ConnClosed()
+	defer server.Trace.closed(conn)
 
-			server.handle(ctx, conn)
-		})
+	var release, admitted = server.admit(conn)
+	if !admitted {
+		return
+	}
+	defer release()
+
+	var errHandshake = server.handshake(ctx, conn)
+	server.Trace.handshakeDone(conn, errHandshake)
+	if errHandshake != nil {
+		server.log(ctx, slog.LevelWarn, "handshake failed",
+			logfLine("WARN: handshake with %q failed: %v", conn.RemoteAddr(), errHandshake),
+			"remote_addr", conn.RemoteAddr().String(), "error", errHandshake)
+		metrics.HandshakeFailed(errHandshake)
+		return
 	}
+
+	server.handle(ctx, conn)
 }
 
-func (server *Server) maxConnections() int {
-	switch {
-	case server.MaxConnections > 0:
-		return server.MaxConnections
-	case server.MaxConnections == 0:
-		return DefaultMaxConnections
-	default:
-		return -1
+// admit checks the connection against DenyList and MaxConnectionsPerIP.
+// Returned release function must be called after the connection is closed.
+func (server *Server) admit(conn net.Conn) (release func(), admitted bool) {
+	var ip, ipOK = remoteIP(conn.RemoteAddr())
+	if !ipOK {
+		return func() {}, true
+	}
+	if server.DenyList != nil && server.DenyList.Denied(ip) {
+		server.log(context.Background(), slog.LevelWarn, "connection is denied", "remote_addr", ip.String())
+		return nil, false
+	}
+	if server.MaxConnectionsPerIP <= 0 {
+		return func() {}, true
 	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.perIP[ip] >= server.MaxConnectionsPerIP {
+		server.log(context.Background(), slog.LevelWarn, "too many connections", "remote_addr", ip.String())
+		return nil, false
+	}
+	server.perIP[ip]++
+	return func() {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.perIP[ip]--
+		if server.perIP[ip] <= 0 {
+			delete(server.perIP, ip)
+		}
+	}, true
 }
 
-// Stop gracefully shuts down the server: closes all connections.
+// Stop shuts down the server immediately: closes all listeners and connections.
+// Contexts of running handlers are canceled with ErrServerClosed cause.
+// See Shutdown for a graceful shutdown.
 func (server *Server) Stop() {
-	server.closeAll()
+	server.init()
+	server.srv.Stop()
 }
 
-func (server *Server) closeAll() {
-	server.mu.RLock()
-	defer server.mu.RUnlock()
-	for conn := range server.conns {
-		_ = conn.c.Close()
-	}
-	for listener := range server.listeners {
-		_ = listener.Close()
-	}
+// Shutdown gracefully shuts down the server: closes all listeners and waits
+// for open connections to be served. Connections, which are still reading requests,
+// are served as well, so ReadRequestTimeout limits the wait.
+// If ctx is done before, then the remaining connections are closed as with Stop
+// and the context error is returned.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.init()
+	return server.srv.Shutdown(ctx)
 }
 
 func (server *Server) handle(ctx context.Context, conn net.Conn) {
 	defer ignoreErr(conn.Close)
-	if server.ConnContext != nil {
-		ctx = server.ConnContext(ctx, conn)
+	var state = connState(conn)
+	ctx = server.connContext(ctx, conn, state)
+	var deadline, _ = ctx.Deadline()
+	var tc = &timeoutConn{
+		Conn:          conn,
+		idle:          server.IdleTimeout,
+		readDeadline:  earliest(deadline, timeoutDeadline(server.ReadRequestTimeout, DefaultReadRequestTimeout)),
+		writeDeadline: deadline,
+	}
+	var rw = newResponseWriter(tc)
+	if server.Trace != nil && server.Trace.WroteStatus != nil {
+		rw.onStatus = func(code status.Code, meta string) {
+			server.Trace.WroteStatus(conn, code, meta)
+		}
 	}
-	var deadline, deadlineOK = ctx.Deadline()
-	if deadlineOK {
-		_ = conn.SetDeadline(deadline)
+	defer closeResponse(rw)
+	var req, errRead = server.readRequest(ctx, tc, rw, state)
+	if errRead != nil {
+		return
 	}
-	var rw = newResponseWriter(conn)
+	var start = time.Now()
+	var metrics = server.metrics()
+	metrics.RequestParsed(req)
 	defer func() {
-		if !rw.isClosed {
-			_ = rw.Close()
-		}
+		closeResponse(rw)
+		var duration = time.Since(start)
+		server.logRequest(ctx, req, rw, duration)
+		metrics.ResponseComplete(req, rw.status, rw.bytes, duration)
 	}()
-	var req, errParseReq = ParseIncomingRequest(conn, conn.RemoteAddr().String())
-	if errParseReq != nil {
+	var handler = server.route(ctx, rw, req)
+	if handler == nil {
+		return
+	}
+	ctx, cancel := server.requestContext(ctx, tc, req)
+	defer cancel()
+	server.serveRequest(ctx, handler, rw, req)
+}
+
+// connContext returns context with connection values for handlers.
+func (server *Server) connContext(ctx context.Context, conn net.Conn, state *tls.ConnectionState) context.Context {
+	ctx = context.WithValue(ctx, ServerContextKey, server)
+	ctx = context.WithValue(ctx, ConnContextKey, conn)
+	if state != nil {
+		ctx = context.WithValue(ctx, TLSStateContextKey, state)
+	}
+	if header := proxyHeader(conn); header != nil {
+		ctx = context.WithValue(ctx, ProxyHeaderContextKey, header)
+	}
+	if server.ConnContext != nil {
+		ctx = server.ConnContext(ctx, conn)
+	}
+	return ctx
+}
+
+// readRequest reads and parses the request line.
+// Parsing errors are logged and responded to, so the caller just stops serving the connection.
+func (server *Server) readRequest(ctx context.Context, tc *timeoutConn, rw *responseWriter, state *tls.ConnectionState) (IncomingRequest, error) {
+	var conn = tc.Conn
+	var re = bufio.NewReader(tc)
+	var req, errParseReq = parseIncomingRequest(re, conn.RemoteAddr().String(), state, server.maxUploadSize())
+	server.Trace.requestParsed(conn, req, errParseReq)
+	switch {
+	case errors.Is(errParseReq, os.ErrDeadlineExceeded):
+		server.log(ctx, slog.LevelWarn, "reading request: timeout", "remote_addr", conn.RemoteAddr().String())
+		_ = rw.close()
+	case errParseReq != nil:
 		const code = status.BadRequest
-		server.logf("WARN: bad request: remote_addr=%s, code=%s: %v", conn.RemoteAddr(), code, errParseReq)
+		server.log(ctx, slog.LevelWarn, "bad request",
+			logfLine("WARN: bad request: remote_addr=%s, code=%s: %v", conn.RemoteAddr(), code, errParseReq),
+			"remote_addr", conn.RemoteAddr().String(), "status", int(code), "error", errParseReq)
 		rw.WriteStatus(code, status.Text(code))
-		return
+		server.metrics().ResponseComplete(nil, code, 0, 0)
 	}
-	if !server.validHost(req.URL()) {
-		server.logf("WARN: bad request: unknown host %q", req.URL().Host)
+	return req, errParseReq
+}
+
+// route returns handler of the request.
+// Requests to unknown hosts are responded to, and nil handler is returned.
+func (server *Server) route(ctx context.Context, rw ResponseWriter, req IncomingRequest) Handler {
+	switch {
+	case server.ProxyHandler != nil && server.isProxyRequest(req.URL()):
+		return server.ProxyHandler
+	case !server.validHost(req.URL()):
+		server.log(ctx, slog.LevelWarn, "unknown host",
+			logfLine("WARN: bad request: unknown host %q", req.URL().Host),
+			"remote_addr", req.RemoteAddr(), "host", req.URL().Host)
 		rw.WriteStatus(status.PermanentFailure, "host not found")
-		return
+		return nil
+	default:
+		return server.Handler
+	}
+}
+
+// requestContext applies request deadlines to the connection and returns handler context.
+// The context is canceled, when the client disconnects, writes fail or HandlerTimeout elapses.
+func (server *Server) requestContext(ctx context.Context, tc *timeoutConn, req IncomingRequest) (context.Context, context.CancelFunc) {
+	var deadline, _ = ctx.Deadline()
+	if server.WriteTimeout > 0 {
+		deadline = earliest(deadline, time.Now().Add(server.WriteTimeout))
+	}
+	var _, isTitan = req.(TitanRequest)
+	tc.readDeadline, tc.writeDeadline = deadline, deadline
+	if isTitan {
+		tc.readDeadline = earliest(deadline, timeoutDeadline(server.UploadTimeout, DefaultUploadTimeout))
+	}
+	var cancelDeadline context.CancelFunc = func() {}
+	if !deadline.IsZero() {
+		ctx, cancelDeadline = context.WithDeadline(ctx, deadline)
+	}
+	ctx, cancel := context.WithCancelCause(context.WithValue(ctx, RequestContextKey, req))
+	tc.writeFailed = cancel
+	if !isTitan {
+		// titan upload body is read by the handler
+		go watchDisconnect(ctx, tc, cancel)
 	}
+	var cancelTimeout context.CancelFunc = func() {}
+	if server.HandlerTimeout > 0 {
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, server.HandlerTimeout, ErrHandlerTimeout)
+	}
+	return ctx, func() {
+		cancelTimeout()
+		cancel(nil)
+		cancelDeadline()
+	}
+}
 
+// serveRequest calls the handler and recovers its panics.
+func (server *Server) serveRequest(ctx context.Context, handler Handler, rw *responseWriter, req IncomingRequest) {
 	isPanicked := true
 	defer func() {
 		if !isPanicked {
@@ -172,75 +409,158 @@ func (server *Server) handle(ctx context.Context, conn net.Conn) {
 		stack := debug.Stack()
 		recovered := recover()
 
-		server.logf("ERRO: recovered panic: %v\n%s", recovered, stack)
+		server.log(ctx, slog.LevelError, "recovered panic",
+			logfLine("ERRO: recovered panic: %v\n%s", recovered, stack),
+			"remote_addr", req.RemoteAddr(), "url", req.URL().String(),
+			"panic", fmt.Sprint(recovered), "stack", string(stack))
+		server.metrics().Panicked(req, recovered)
 	}()
 
-	server.Handler(ctx, rw, req)
+	handler(ctx, rw, req)
 
 	isPanicked = false
 }
 
-func ignoreErr(fn func() error) {
-	_ = fn()
+func closeResponse(rw *responseWriter) {
+	if !rw.isClosed {
+		_ = rw.Close()
+	}
 }
 
-func (server *Server) addConn(conn net.Conn) *connTrack {
-	server.mu.Lock()
-	defer server.mu.Unlock()
-	var track = &connTrack{c: conn}
-	server.conns[track] = struct{}{}
-	return track
+func ignoreErr(fn func() error) {
+	_ = fn()
 }
 
-func (server *Server) addListener(listener net.Listener) {
-	server.mu.Lock()
-	defer server.mu.Unlock()
-	server.listeners[listener] = struct{}{}
+func (server *Server) metrics() Metrics {
+	if server.Metrics != nil {
+		return server.Metrics
+	}
+	return nopMetrics{}
 }
 
-func (server *Server) removeTrack(track *connTrack) {
-	server.mu.Lock()
-	defer server.mu.Unlock()
-	delete(server.conns, track)
+func (server *Server) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	server.logSink().log(ctx, level, msg, args...)
 }
 
-type connTrack struct {
-	c net.Conn
+func (server *Server) logSink() logSink {
+	return logSink{logger: server.Logger, logf: server.Logf}
 }
 
-func (server *Server) logf(format string, args ...any) {
-	if server.Logf != nil {
-		server.Logf(format, args...)
+// logRequest writes the "request served" record to Logger only,
+// Logf receives just warnings and errors as before.
+func (server *Server) logRequest(ctx context.Context, req IncomingRequest, rw *responseWriter, duration time.Duration) {
+	if server.Logger == nil {
+		return
+	}
+	var attrs = []any{
+		"remote_addr", req.RemoteAddr(),
+		"url", req.URL().String(),
+		"host", req.URL().Host,
+		"status", int(rw.status),
+		"bytes", rw.bytes,
+		"duration", duration,
+	}
+	if certs := req.Certificates(); len(certs) > 0 {
+		attrs = append(attrs, "cert_fingerprint", CertificateFingerprint(certs[0]))
 	}
+	server.Logger.Log(ctx, slog.LevelInfo, "request served", attrs...)
 }
 
 func (server *Server) validHost(u *url.URL) bool {
-	if u.Host == "" {
-		return false
-	}
-	if len(server.hosts) == 0 {
-		return true
-	}
-	var host = u.Host
-	var hostname = u.Hostname()
-	var _, hostOk = server.hosts[host]
-	var _, hostnameOk = server.hosts[hostname]
-	return hostOk || hostnameOk
+	return server.hosts.Match(u)
 }
 
-func (server *Server) buildHosts() {
-	if server.hosts == nil {
-		server.hosts = make(map[string]struct{}, len(server.Hosts))
+func (server *Server) maxUploadSize() int64 {
+	switch {
+	case server.MaxUploadSize > 0:
+		return server.MaxUploadSize
+	case server.MaxUploadSize == 0:
+		return DefaultMaxUploadSize
+	default:
+		return -1
 	}
-	for _, host := range server.Hosts {
-		server.hosts[host] = struct{}{}
+}
+
+func (server *Server) isProxyRequest(u *url.URL) bool {
+	if u.Scheme != "gemini" && u.Scheme != "titan" {
+		return true
 	}
+	return len(server.hosts) > 0 && !server.validHost(u)
 }
 
-func handshake(ctx context.Context, conn net.Conn) error {
+func (server *Server) handshake(ctx context.Context, conn net.Conn) error {
 	if c, ok := conn.(*tls.Conn); ok {
+		if timeout := server.HandshakeTimeout; timeout >= 0 {
+			if timeout == 0 {
+				timeout = DefaultHandshakeTimeout
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
 		return c.HandshakeContext(ctx)
 	}
 
 	return nil
 }
+
+// timeoutConn applies absolute and inactivity deadlines before each read and write.
+type timeoutConn struct {
+	net.Conn
+	idle          time.Duration
+	readDeadline  time.Time
+	writeDeadline time.Time
+	// writeFailed is called with the cause of write failure.
+	writeFailed func(cause error)
+}
+
+func (conn *timeoutConn) Read(data []byte) (int, error) {
+	_ = conn.Conn.SetReadDeadline(conn.deadline(conn.readDeadline))
+	return conn.Conn.Read(data)
+}
+
+func (conn *timeoutConn) Write(data []byte) (int, error) {
+	_ = conn.Conn.SetWriteDeadline(conn.deadline(conn.writeDeadline))
+	var n, errWrite = conn.Conn.Write(data)
+	if errWrite != nil && conn.writeFailed != nil {
+		var cause = errWrite
+		if !errors.Is(errWrite, os.ErrDeadlineExceeded) {
+			cause = fmt.Errorf("%w: %w", ErrClientDisconnected, errWrite)
+		}
+		conn.writeFailed(cause)
+	}
+	return n, errWrite
+}
+
+func (conn *timeoutConn) deadline(limit time.Time) time.Time {
+	if conn.idle <= 0 {
+		return limit
+	}
+	return earliest(limit, time.Now().Add(conn.idle))
+}
+
+// timeoutDeadline returns deadline for the timeout.
+//
+//	0 - defaultTimeout
+//	<0 - no deadline
+func timeoutDeadline(timeout, defaultTimeout time.Duration) time.Time {
+	switch {
+	case timeout < 0:
+		return time.Time{}
+	case timeout == 0:
+		timeout = defaultTimeout
+	}
+	return time.Now().Add(timeout)
+}
+
+// earliest returns the earliest non-zero time.
+func earliest(a, b time.Time) time.Time {
+	switch {
+	case a.IsZero():
+		return b
+	case b.IsZero() || a.Before(b):
+		return a
+	default:
+		return b
+	}
+}
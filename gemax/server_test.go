@@ -5,10 +5,14 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/netip"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,6 +21,8 @@ import (
 
 	"github.com/ninedraft/gemax/gemax"
 	"github.com/ninedraft/gemax/gemax/internal/testaddr"
+	"github.com/ninedraft/gemax/gemax/metrics"
+	"github.com/ninedraft/gemax/gemax/proxyproto"
 	"github.com/ninedraft/gemax/gemax/status"
 
 	"github.com/ninedraft/gemax/vend/tailscale.com/net/memnet"
@@ -653,3 +659,336 @@ func dialAndWriteRaw(
 		}
 	}
 }
+
+func TestListenAndServe_ProxyProtocol(test *testing.T) {
+	test.Parallel()
+	var server = &gemax.Server{
+		Addr:          testaddr.Addr(),
+		Logf:          test.Logf,
+		ProxyProtocol: true,
+		TrustedProxies: []netip.Prefix{
+			netip.MustParsePrefix("127.0.0.0/8"),
+			netip.MustParsePrefix("::1/128"),
+		},
+		Handler: func(_ context.Context, rw gemax.ResponseWriter, req gemax.IncomingRequest) {
+			_, _ = io.WriteString(rw, req.RemoteAddr())
+		},
+	}
+	var conn = startTLSServer(test, server)
+
+	var header = &proxyproto.Header{
+		Version:     proxyproto.V2,
+		Source:      &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000},
+		Destination: &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 1965},
+	}
+	if _, err := header.WriteTo(conn); err != nil {
+		test.Fatal(err)
+	}
+	var tlsConn = tlsClient(conn)
+	_, _ = io.WriteString(tlsConn, "gemini://example.com/\r\n")
+
+	expectResponse(test, tlsConn, "20 text/gemini\r\n192.0.2.1:4000")
+}
+
+func TestListenAndServe_ProxyProtocol_NoTrustedProxies(test *testing.T) {
+	test.Parallel()
+	var server = &gemax.Server{
+		Addr:          testaddr.Addr(),
+		Logf:          test.Logf,
+		ProxyProtocol: true,
+		Handler:       func(context.Context, gemax.ResponseWriter, gemax.IncomingRequest) {},
+	}
+	if err := server.ListenAndServe(test.Context(), &tls.Config{MinVersion: tls.VersionTLS12}); err == nil {
+		test.Fatal("expected an error for PROXY protocol without trusted proxies")
+	}
+}
+
+func TestServer_MaxConnectionsPerIP(test *testing.T) {
+	test.Parallel()
+	var trigger = make(chan struct{})
+	var server = &gemax.Server{
+		Addr:                testaddr.Addr(),
+		Logf:                test.Logf,
+		MaxConnectionsPerIP: 1,
+		Handler: func(_ context.Context, rw gemax.ResponseWriter, _ gemax.IncomingRequest) {
+			<-trigger
+			_, _ = io.WriteString(rw, "example text")
+		},
+	}
+	var first = tlsClient(startTLSServer(test, server))
+	_, _ = io.WriteString(first, "gemini://example.com/\r\n")
+
+	var second = tlsClient(dialServer(test, server.Addr))
+	if err := second.Handshake(); err == nil {
+		test.Errorf("second connection from the same IP is expected to be rejected")
+	}
+	close(trigger)
+	expectResponse(test, first, "20 text/gemini\r\nexample text")
+}
+
+func TestServer_DenyList(test *testing.T) {
+	test.Parallel()
+	var denyList = &gemax.DenyList{}
+	for _, cidr := range []string{"127.0.0.0/8", "::1"} {
+		if err := denyList.AddCIDR(cidr, time.Hour); err != nil {
+			test.Fatal(err)
+		}
+	}
+	var server = &gemax.Server{
+		Addr:     testaddr.Addr(),
+		Logf:     test.Logf,
+		DenyList: denyList,
+		Handler: func(_ context.Context, rw gemax.ResponseWriter, _ gemax.IncomingRequest) {
+			_, _ = io.WriteString(rw, "example text")
+		},
+	}
+	var denied = tlsClient(startTLSServer(test, server))
+	if err := denied.Handshake(); err == nil {
+		test.Errorf("connection from denied network is expected to be rejected")
+	}
+
+	for _, cidr := range []string{"127.0.0.0/8", "::1"} {
+		if err := denyList.RemoveCIDR(cidr); err != nil {
+			test.Fatal(err)
+		}
+	}
+	var allowed = tlsClient(dialServer(test, server.Addr))
+	_, _ = io.WriteString(allowed, "gemini://example.com/\r\n")
+	expectResponse(test, allowed, "20 text/gemini\r\nexample text")
+}
+
+func TestDenyList(test *testing.T) {
+	var list = &gemax.DenyList{}
+	list.Add(netip.MustParsePrefix("192.0.2.0/24"), 0)
+	list.Add(netip.MustParsePrefix("198.51.100.0/24"), time.Nanosecond)
+	list.Add(netip.MustParsePrefix("::ffff:10.0.0.0/104"), 0)
+	list.Add(netip.MustParsePrefix("64:ff9b::/64"), 0)
+	time.Sleep(time.Millisecond)
+
+	var tc = func(addr string, expected bool) {
+		if got := list.Denied(netip.MustParseAddr(addr)); got != expected {
+			test.Errorf("%s: expected denied=%v, got %v", addr, expected, got)
+		}
+	}
+	tc("192.0.2.42", true)
+	tc("::ffff:192.0.2.42", true)
+	tc("198.51.100.1", false)
+	tc("203.0.113.1", false)
+	tc("10.1.2.3", true)
+	tc("::ffff:10.1.2.3", true)
+	tc("11.1.2.3", false)
+	tc("64:ff9b::1", true)
+
+	list.Remove(netip.MustParsePrefix("192.0.2.0/24"))
+	tc("192.0.2.42", false)
+	list.Remove(netip.MustParsePrefix("::ffff:10.0.0.0/104"))
+	tc("10.1.2.3", false)
+
+	var all = &gemax.DenyList{}
+	all.Add(netip.MustParsePrefix("::/0"), 0)
+	if !all.Denied(netip.MustParseAddr("192.0.2.1")) {
+		test.Errorf("::/0 must cover IPv4-mapped addresses")
+	}
+
+	if err := list.AddCIDR("not an address", 0); err == nil {
+		test.Errorf("expected error for invalid CIDR")
+	}
+}
+
+// startTLSServer starts server with ListenAndServe and returns a plain connection to it.
+// Server is stopped at the end of the test.
+func startTLSServer(t *testing.T, server *gemax.Server) net.Conn {
+	t.Helper()
+	var cert, errCert = tls.LoadX509KeyPair("testdata/cert.pem", "testdata/key.pem")
+	if errCert != nil {
+		t.Fatal(errCert)
+	}
+	var ctx, cancel = context.WithCancel(context.Background())
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = server.ListenAndServe(ctx, &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return dialServer(t, server.Addr)
+}
+
+func dialServer(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	for i := 0; ; i++ {
+		var conn, errDial = net.Dial("tcp", addr)
+		if errDial == nil {
+			t.Cleanup(func() { _ = conn.Close() })
+			return conn
+		}
+		if i >= 20 {
+			t.Fatalf("server is not started: %v", errDial)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func tlsClient(conn net.Conn) *tls.Conn {
+	//nolint:gosec // test server uses self-signed certificate
+	return tls.Client(conn, &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: true})
+}
+
+func TestServer_Timeouts(test *testing.T) {
+	test.Parallel()
+	var server = &gemax.Server{
+		Addr:               testaddr.Addr(),
+		Logf:               test.Logf,
+		HandshakeTimeout:   100 * time.Millisecond,
+		ReadRequestTimeout: 100 * time.Millisecond,
+		WriteTimeout:       time.Minute,
+		Handler: func(ctx context.Context, rw gemax.ResponseWriter, _ gemax.IncomingRequest) {
+			var deadline, ok = ctx.Deadline()
+			_, _ = fmt.Fprint(rw, ok && time.Until(deadline) <= time.Minute)
+		},
+	}
+
+	var conn = startTLSServer(test, server)
+
+	test.Run("handshake", func(test *testing.T) {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// client never sends ClientHello
+		var _, errRead = conn.Read(make([]byte, 1))
+		if !errors.Is(errRead, io.EOF) {
+			test.Fatalf("expected connection to be closed, got %v", errRead)
+		}
+	})
+
+	test.Run("read request", func(test *testing.T) {
+		var conn = tlsClient(dialServer(test, server.Addr))
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.Handshake(); err != nil {
+			test.Fatal(err)
+		}
+		// client never sends request line
+		expectResponse(test, conn, "")
+	})
+
+	test.Run("handler deadline", func(test *testing.T) {
+		var conn = tlsClient(dialServer(test, server.Addr))
+		_, _ = io.WriteString(conn, "gemini://example.com/\r\n")
+		expectResponse(test, conn, "20 text/gemini\r\ntrue")
+	})
+}
+
+func TestServer_Logger(test *testing.T) {
+	test.Parallel()
+	var listener, server = setupEchoServer(test)
+	var records = &syncBuffer{}
+	var lines = &syncBuffer{}
+	server.Hosts = []string{"example.com"}
+	server.Logger = slog.New(slog.NewJSONHandler(records, nil))
+	server.Logf = func(format string, args ...any) {
+		_, _ = fmt.Fprintf(lines, format+"\n", args...)
+	}
+	var ctx = test.Context()
+	runTask(test, func() {
+		_ = server.Serve(ctx, listener)
+	})
+	test.Cleanup(func() { _ = listener.Close() })
+
+	var resp = dialAndWrite(test, ctx, listener, "gemini://example.com/path\r\n")
+	expectResponse(test, strings.NewReader(resp), "20 text/gemini\r\ngemini://example.com/path")
+
+	var record struct {
+		Level      string `json:"level"`
+		Msg        string `json:"msg"`
+		RemoteAddr string `json:"remote_addr"`
+		URL        string `json:"url"`
+		Host       string `json:"host"`
+		Status     int    `json:"status"`
+		Bytes      int64  `json:"bytes"`
+	}
+	if err := json.Unmarshal([]byte(records.String()), &record); err != nil {
+		test.Fatalf("parsing log record %q: %v", records, err)
+	}
+	if record.Msg != "request served" || record.URL != "gemini://example.com/path" ||
+		record.Host != "example.com" || record.Status != 20 || record.Bytes != 25 {
+		test.Errorf("unexpected log record %+v", record)
+	}
+	if lines.String() != "" {
+		test.Errorf("served requests must not be logged with Logf, got %q", lines)
+	}
+
+	resp = dialAndWrite(test, ctx, listener, "gemini://other.com/path\r\n")
+	expectResponse(test, strings.NewReader(resp), "50 host not found\r\n")
+	if lines.String() != "WARN: bad request: unknown host \"other.com\"\n" {
+		test.Errorf("unexpected Logf line %q", lines)
+	}
+}
+
+func TestServer_Metrics(test *testing.T) {
+	test.Parallel()
+	var listener, server = setupServer(test, func(_ context.Context, rw gemax.ResponseWriter, req gemax.IncomingRequest) {
+		if req.URL().Path == "/panic" {
+			panic("boom")
+		}
+		_, _ = io.WriteString(rw, "ok")
+	})
+	var collector = &metrics.Collector{MaxConnections: 4}
+	server.Metrics = collector
+	var ctx = test.Context()
+	runTask(test, func() {
+		_ = server.Serve(ctx, listener)
+	})
+	test.Cleanup(func() { _ = listener.Close() })
+
+	_, _ = dialAndWriteRaw(test, ctx, listener, "gemini://example.com/ok\r\n")
+	_, _ = dialAndWriteRaw(test, ctx, listener, "gemini://example.com/panic\r\n")
+	_, _ = dialAndWriteRaw(test, ctx, listener, "\r\n")
+
+	// events are recorded after connections are closed
+	var expected = []string{
+		"gemax_connections_accepted_total 3\n",
+		"gemax_connections_active 0\n",
+		"gemax_connections_max 4\n",
+		"gemax_requests_in_flight 0\n",
+		`gemax_responses_total{status="0"} 1` + "\n",
+		`gemax_responses_total{status="20"} 1` + "\n",
+		`gemax_responses_total{status="59"} 1` + "\n",
+		"gemax_response_bytes_total 2\n",
+		"gemax_handler_panics_total 1\n",
+		`gemax_request_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+	}
+	var output string
+	for range 100 {
+		var buf = &strings.Builder{}
+		_, _ = collector.WriteTo(buf)
+		output = buf.String()
+		var missing = slices.IndexFunc(expected, func(line string) bool {
+			return !strings.Contains(output, line)
+		})
+		if missing < 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Fatalf("unexpected metrics:\n%s", output)
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (buf *syncBuffer) Write(data []byte) (int, error) {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	return buf.buf.Write(data)
+}
+
+func (buf *syncBuffer) String() string {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	return buf.buf.String()
+}
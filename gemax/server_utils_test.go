@@ -125,6 +125,7 @@ func TestRedirect(test *testing.T) {
 type request struct {
 	remoteAddr string
 	url        string
+	certs      []*x509.Certificate
 }
 
 func (req *request) URL() *urlpkg.URL {
@@ -137,7 +138,7 @@ func (req *request) RemoteAddr() string {
 }
 
 func (req *request) Certificates() []*x509.Certificate {
-	return nil
+	return req.certs
 }
 
 type responseRecorder struct {
@@ -0,0 +1,139 @@
+package spartan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	urlpkg "net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// Client is used to fetch spartan resources.
+// Empty client value can be considered as initialized.
+type Client struct {
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Response contains parsed server response.
+// Spartan status is mapped to gemini status code, see Code.Status.
+type Response struct {
+	Status status.Code
+	Code   Code
+	Meta   string
+	io.Reader
+	io.Closer
+}
+
+// Fetch spartan resource.
+// If the URL contains a query, then it's percent-decoded and sent as the data block.
+func (client *Client) Fetch(ctx context.Context, url string) (*Response, error) {
+	var u, errParse = urlpkg.Parse(url)
+	if errParse != nil {
+		return nil, fmt.Errorf("parsing URL: %w", errParse)
+	}
+	var query, errQuery = urlpkg.PathUnescape(u.RawQuery)
+	if errQuery != nil {
+		return nil, fmt.Errorf("parsing URL query: %w", errQuery)
+	}
+	u.RawQuery = ""
+	return client.Upload(ctx, u.String(), strings.NewReader(query), int64(len(query)))
+}
+
+// Upload sends size bytes of data to the spartan resource.
+func (client *Client) Upload(ctx context.Context, url string, data io.Reader, size int64) (*Response, error) {
+	var u, errParse = urlpkg.Parse(url)
+	if errParse != nil {
+		return nil, fmt.Errorf("parsing URL: %w", errParse)
+	}
+	if u.Scheme != "spartan" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	var port = u.Port()
+	if port == "" {
+		port = strconv.Itoa(DefaultPort)
+	}
+	var addr = net.JoinHostPort(u.Hostname(), port)
+	var conn, errDial = client.dial(ctx, addr)
+	if errDial != nil {
+		return nil, fmt.Errorf("connecting to the server %q: %w", addr, errDial)
+	}
+	var closeConn = true
+	defer func() {
+		if closeConn {
+			_ = conn.Close()
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	var path = u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	var request = &bytes.Buffer{}
+	_, _ = fmt.Fprintf(request, "%s %s %d\r\n", u.Hostname(), path, size)
+	if _, errWrite := io.Copy(conn, io.MultiReader(request, io.LimitReader(data, size))); errWrite != nil {
+		return nil, fmt.Errorf("sending request: %w", errWrite)
+	}
+
+	var re = bufio.NewReader(conn)
+	var code, meta, errHeader = readResponseHeader(re)
+	if errHeader != nil {
+		return nil, errHeader
+	}
+	closeConn = false
+	return &Response{
+		Status: code.Status(),
+		Code:   code,
+		Meta:   meta,
+		Reader: re,
+		Closer: conn,
+	}, nil
+}
+
+// readResponseHeader reads and parses the response header.
+func readResponseHeader(re io.ByteReader) (Code, string, error) {
+	var header, errHeader = readHeader(re)
+	if errHeader != nil {
+		return 0, "", errHeader
+	}
+	var codeField, meta, _ = strings.Cut(header, " ")
+	var code, errCode = strconv.Atoi(codeField)
+	if errCode != nil {
+		return 0, "", fmt.Errorf("%w: parsing status code: %w", gemax.ErrInvalidResponse, errCode)
+	}
+	return Code(code), meta, nil
+}
+
+// readHeader reads the response header line without line terminator.
+// Headers longer than gemax.MaxHeaderSize are rejected with gemax.ErrHeaderTooLarge.
+func readHeader(re io.ByteReader) (string, error) {
+	var header strings.Builder
+	for range gemax.MaxHeaderSize {
+		var b, errRead = re.ReadByte()
+		if errRead != nil {
+			return "", fmt.Errorf("%w: %w", gemax.ErrInvalidResponse, errRead)
+		}
+		if b == '\n' {
+			return strings.TrimSuffix(header.String(), "\r"), nil
+		}
+		_ = header.WriteByte(b)
+	}
+	return "", gemax.ErrHeaderTooLarge
+}
+
+func (client *Client) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if client.Dial != nil {
+		return client.Dial(ctx, "tcp", addr)
+	}
+	var dialer = &net.Dialer{}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
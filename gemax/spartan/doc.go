@@ -0,0 +1,6 @@
+// Package spartan provides a Spartan protocol server and client.
+// Spartan is a plaintext sibling of gemini, so the server adapts
+// spartan requests into gemax.Handler calls and one handler tree can be
+// served over both protocols.
+// Spartan specification: gemini://spartan.mozz.us/specification.gmi
+package spartan
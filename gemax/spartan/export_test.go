@@ -0,0 +1,8 @@
+package spartan
+
+import "net/url"
+
+// RedirectPath exposes redirect target conversion for tests.
+func RedirectPath(base *url.URL, target string) string {
+	return redirectPath(base, target)
+}
@@ -0,0 +1,136 @@
+package spartan
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ninedraft/gemax/gemax"
+)
+
+// DefaultPort is a standard spartan port.
+const DefaultPort = 300
+
+// MaxRequestLineSize is the maximum request line size in bytes.
+const MaxRequestLineSize = 1024 + len("\r\n")
+
+// DefaultMaxUploadSize is used if Server.MaxUploadSize is 0.
+const DefaultMaxUploadSize = 1 << 20
+
+// ErrBadRequest means that the request is malformed.
+var ErrBadRequest = errors.New("bad request")
+
+// ErrUploadTooLarge means that the request data block exceeds the upload size limit.
+var ErrUploadTooLarge = errors.New("upload is too large")
+
+// Request is a parsed spartan request.
+// It implements gemax.IncomingRequest, so it can be passed to gemax handlers.
+// The data block is exposed both with Body and as the URL query,
+// because spartan clients send user input as the data block.
+type Request struct {
+	url        *url.URL
+	remoteAddr string
+	data       []byte
+}
+
+var _ gemax.IncomingRequest = new(Request)
+
+// URL returns the request URL in form of spartan://host/path[?data].
+func (req *Request) URL() *url.URL {
+	return req.url
+}
+
+// RemoteAddr returns the client address.
+func (req *Request) RemoteAddr() string {
+	return req.remoteAddr
+}
+
+// Certificates always returns nil: spartan doesn't use TLS.
+func (req *Request) Certificates() []*x509.Certificate {
+	return nil
+}
+
+// Body returns the request data block.
+func (req *Request) Body() io.Reader {
+	return bytes.NewReader(req.data)
+}
+
+// ParseRequest reads a request in form of "<host> <path> <content-length>\r\n<data>".
+// Data block larger than maxUpload bytes is rejected with ErrUploadTooLarge.
+func ParseRequest(re *bufio.Reader, remoteAddr string, maxUpload int64) (*Request, error) {
+	var line, errLine = readLine(re)
+	if errLine != nil {
+		return nil, errLine
+	}
+	var u, length, errParse = parseRequestLine(line, maxUpload)
+	if errParse != nil {
+		return nil, errParse
+	}
+	var data = make([]byte, length)
+	if _, errData := io.ReadFull(re, data); errData != nil {
+		return nil, fmt.Errorf("%w: reading data block: %w", ErrBadRequest, errData)
+	}
+	if length > 0 {
+		u.RawQuery = escapeQuery(string(data))
+	}
+	return &Request{
+		url:        u,
+		remoteAddr: remoteAddr,
+		data:       data,
+	}, nil
+}
+
+// parseRequestLine parses request line without line terminator.
+// Returns the requested URL and the data block length.
+func parseRequestLine(line string, maxUpload int64) (*url.URL, int64, error) {
+	var fields = strings.Split(line, " ")
+	if len(fields) != 3 {
+		return nil, 0, fmt.Errorf("%w: expected 3 fields in request line, got %d", ErrBadRequest, len(fields))
+	}
+	var host, path, lengthField = fields[0], fields[1], fields[2]
+	if host == "" || !strings.HasPrefix(path, "/") {
+		return nil, 0, fmt.Errorf("%w: invalid host or path", ErrBadRequest)
+	}
+	var length, errLength = strconv.ParseInt(lengthField, 10, 64)
+	switch {
+	case errLength != nil, length < 0:
+		return nil, 0, fmt.Errorf("%w: invalid content length %q", ErrBadRequest, lengthField)
+	case length > maxUpload:
+		return nil, 0, fmt.Errorf("%w: %d bytes", ErrUploadTooLarge, length)
+	}
+
+	var u, errURL = url.Parse("spartan://" + host + path)
+	if errURL != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrBadRequest, errURL)
+	}
+	if !gemax.ValidPath(u.Path) {
+		return nil, 0, fmt.Errorf("%w: invalid path %q", ErrBadRequest, u.Path)
+	}
+	return u, length, nil
+}
+
+func readLine(re *bufio.Reader) (string, error) {
+	var line []byte
+	for len(line) < MaxRequestLineSize {
+		var b, errRead = re.ReadByte()
+		if errRead != nil {
+			return "", fmt.Errorf("%w: %w", ErrBadRequest, errRead)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			return strings.TrimRight(string(line), "\r\n"), nil
+		}
+	}
+	return "", fmt.Errorf("%w: request line is too long", ErrBadRequest)
+}
+
+// escapeQuery escapes data as a gemini query. Spaces are encoded as %20.
+func escapeQuery(data string) string {
+	return strings.ReplaceAll(url.QueryEscape(data), "+", "%20")
+}
@@ -0,0 +1,101 @@
+package spartan
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/internal/header"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// responseWriter translates gemini statuses into spartan response headers.
+type responseWriter struct {
+	// request URL, redirect targets are resolved against it
+	base          *url.URL
+	statusWritten bool
+	isClosed      bool
+	writer        *bufio.Writer
+	closer        io.Closer
+}
+
+var _ gemax.ResponseWriter = new(responseWriter)
+
+func newResponseWriter(wr io.WriteCloser) *responseWriter {
+	return &responseWriter{
+		writer: bufio.NewWriter(wr),
+		closer: wr,
+	}
+}
+
+func (rw *responseWriter) WriteStatus(code status.Code, meta string) {
+	if rw.statusWritten || rw.isClosed {
+		return
+	}
+	if code == status.Success && meta == "" {
+		meta = gemax.MIMEGemtext
+	}
+	var spartanCode = FromStatus(code)
+	if spartanCode == Redirect {
+		meta = redirectPath(rw.base, meta)
+	}
+	meta = header.SanitizeMeta(meta)
+	_, _ = fmt.Fprintf(rw.writer, "%d %s\r\n", spartanCode, meta)
+	rw.statusWritten = true
+	if spartanCode != Success {
+		_ = rw.Close()
+	}
+}
+
+// redirectPath resolves redirect target against the request URL and converts it
+// to an absolute path with query: spartan redirects can't change host.
+func redirectPath(base *url.URL, target string) string {
+	var u, errParse = url.Parse(target)
+	if errParse != nil {
+		return target
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	var p = u.EscapedPath()
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return p
+}
+
+func (rw *responseWriter) Write(data []byte) (int, error) {
+	if rw.isClosed {
+		return 0, io.ErrNoProgress
+	}
+	rw.WriteStatus(status.Success, gemax.MIMEGemtext)
+	return rw.writer.Write(data)
+}
+
+func (rw *responseWriter) Close() error {
+	if rw.isClosed {
+		return header.ErrAlreadyClosed
+	}
+	rw.WriteStatus(status.Success, gemax.MIMEGemtext)
+	if rw.isClosed {
+		return nil
+	}
+	rw.isClosed = true
+	return errors.Join(rw.writer.Flush(), rw.closer.Close())
+}
+
+// abort closes the connection without writing buffered data.
+func (rw *responseWriter) abort() {
+	if rw.isClosed {
+		return
+	}
+	rw.isClosed = true
+	_ = rw.closer.Close()
+}
@@ -0,0 +1,142 @@
+package spartan
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/internal/connserver"
+	"github.com/ninedraft/gemax/gemax/internal/hostset"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// Server is spartan protocol server.
+// It serves gemax handlers: gemini statuses are mapped to spartan ones,
+// and request data blocks are passed as URL queries and request bodies.
+type Server struct {
+	Addr string
+	// Hosts expected by server.
+	// If empty, then every host will be valid.
+	Hosts   []string
+	Handler gemax.Handler
+	// Optional text logger.
+	Logf func(format string, args ...any)
+
+	// Maximum number of simultaneous connections served by Server.
+	//	0 - connserver.DefaultMaxConnections
+	//	<0 - no limitation
+	MaxConnections int
+	// Time limit of reading request line and data block.
+	// Connections, which don't send a request in time, are closed without response.
+	//	0 - connserver.DefaultReadRequestTimeout
+	//	<0 - no limitation
+	ReadRequestTimeout time.Duration
+	// Maximum size of request data block in bytes.
+	//	0 - DefaultMaxUploadSize
+	//	<0 - uploads are rejected
+	MaxUploadSize int64
+
+	srv connserver.Server
+
+	once  sync.Once
+	hosts hostset.Set
+}
+
+// ListenAndServe starts a spartan server at server address.
+// It will block until context is canceled.
+// It respects the MaxConnections setting.
+func (server *Server) ListenAndServe(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	server.srv.MaxConnections = server.MaxConnections
+	var listener, errListen = server.srv.Listen(ctx, "tcp", server.Addr)
+	if errListen != nil {
+		return errListen
+	}
+	defer func() { _ = listener.Close() }()
+	return server.Serve(ctx, listener)
+}
+
+// Serve starts server on provided listener. Provided context will be passed to handlers.
+// Serve will await all running handlers to end.
+func (server *Server) Serve(ctx context.Context, listener net.Listener) error {
+	var errServe = server.srv.Serve(ctx, listener, server.handle)
+	return fmt.Errorf("spartan server: %w", errServe)
+}
+
+// Stop closes all listeners and connections.
+func (server *Server) Stop() {
+	server.srv.Stop()
+}
+
+func (server *Server) handle(ctx context.Context, conn net.Conn) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	var deadline, _ = ctx.Deadline()
+	_ = conn.SetReadDeadline(connserver.ReadDeadline(ctx, server.ReadRequestTimeout))
+	var req, errParse = ParseRequest(bufio.NewReader(conn), conn.RemoteAddr().String(), server.maxUploadSize())
+	if errors.Is(errParse, os.ErrDeadlineExceeded) {
+		server.logf("WARN: reading request: remote_addr=%s: timeout", conn.RemoteAddr())
+		return
+	}
+	_ = conn.SetReadDeadline(deadline)
+
+	var rw = newResponseWriter(conn)
+	defer func() { _ = rw.Close() }()
+	if errParse != nil {
+		server.logf("WARN: bad request: remote_addr=%s: %v", conn.RemoteAddr(), errParse)
+		var msg = "bad request"
+		if errors.Is(errParse, ErrUploadTooLarge) {
+			msg = "upload is too large"
+		}
+		rw.WriteStatus(status.BadRequest, msg)
+		return
+	}
+	rw.base = req.URL()
+	if !server.validHost(req.URL()) {
+		server.logf("WARN: bad request: unknown host %q", req.URL().Host)
+		rw.WriteStatus(status.PermanentFailure, "host not found")
+		return
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			rw.abort()
+			server.logf("ERRO: recovered panic: %v\n%s", recovered, debug.Stack())
+		}
+	}()
+	server.Handler(ctx, rw, req)
+}
+
+func (server *Server) maxUploadSize() int64 {
+	switch {
+	case server.MaxUploadSize > 0:
+		return server.MaxUploadSize
+	case server.MaxUploadSize == 0:
+		return DefaultMaxUploadSize
+	default:
+		return 0
+	}
+}
+
+func (server *Server) validHost(u *url.URL) bool {
+	server.once.Do(func() {
+		server.hosts = hostset.New(server.Hosts)
+	})
+	return server.hosts.Match(u)
+}
+
+func (server *Server) logf(format string, args ...any) {
+	if server.Logf != nil {
+		server.Logf(format, args...)
+	}
+}
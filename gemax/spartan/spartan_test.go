@@ -0,0 +1,187 @@
+package spartan_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/spartan"
+	"github.com/ninedraft/gemax/gemax/status"
+
+	"github.com/ninedraft/gemax/vend/tailscale.com/net/memnet"
+)
+
+func TestServer(test *testing.T) {
+	var server = &spartan.Server{
+		Hosts:              []string{"example.com"},
+		MaxUploadSize:      16,
+		ReadRequestTimeout: 100 * time.Millisecond,
+		Logf:               test.Logf,
+		Handler: func(_ context.Context, rw gemax.ResponseWriter, req gemax.IncomingRequest) {
+			switch req.URL().Path {
+			case "/echo":
+				var body, _ = io.ReadAll(req.(*spartan.Request).Body())
+				rw.WriteStatus(status.Success, "text/plain")
+				_, _ = io.WriteString(rw, req.URL().String()+" "+string(body))
+			case "/moved":
+				gemax.Redirect(rw, req, "/target", status.Redirect)
+			case "/input":
+				rw.WriteStatus(status.Input, "your name")
+			default:
+				gemax.NotFound(rw, req)
+			}
+		},
+	}
+	var listener = memnet.Listen(test.Name())
+	var ctx = test.Context()
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		var errServe = server.Serve(ctx, listener)
+		if !errors.Is(errServe, net.ErrClosed) {
+			test.Errorf("unexpected serve error: %v", errServe)
+		}
+	}()
+	defer func() {
+		_ = listener.Close()
+		<-done
+	}()
+
+	var client = &spartan.Client{
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return listener.Dial(ctx, network, test.Name())
+		},
+	}
+	var tc = func(name, url string, code spartan.Code, expected string) {
+		test.Run(name, func(test *testing.T) {
+			var resp, errFetch = client.Fetch(ctx, url)
+			if errFetch != nil {
+				test.Fatalf("unexpected fetch error: %v", errFetch)
+			}
+			defer func() { _ = resp.Close() }()
+			if resp.Code != code || resp.Status != code.Status() {
+				test.Fatalf("expected %s, got %s (%s)", code, resp.Code, resp.Status)
+			}
+			var body, _ = io.ReadAll(resp)
+			var got = resp.Meta + "|" + string(body)
+			if got != expected {
+				test.Fatalf("expected %q, got %q", expected, got)
+			}
+		})
+	}
+
+	tc("data block", "spartan://example.com/echo?hello%20world",
+		spartan.Success, "text/plain|spartan://example.com/echo?hello%20world hello world")
+	tc("redirect", "spartan://example.com/moved",
+		spartan.Redirect, "/target|")
+	tc("input", "spartan://example.com/input",
+		spartan.ClientError, "your name|")
+	tc("not found", "spartan://example.com/missing",
+		spartan.ClientError, "spartan://example.com/missing is not found|")
+	tc("unknown host", "spartan://other.com/echo",
+		spartan.ClientError, "host not found|")
+	tc("upload too large", "spartan://example.com/echo?"+strings.Repeat("a", 17),
+		spartan.ClientError, "upload is too large|")
+
+	test.Run("read timeout", func(test *testing.T) {
+		var conn, errDial = client.Dial(ctx, "tcp", "")
+		if errDial != nil {
+			test.Fatal(errDial)
+		}
+		defer func() { _ = conn.Close() }()
+		// data block is never completed
+		_, _ = io.WriteString(conn, "example.com /echo 5\r\nhe")
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var data, errRead = io.ReadAll(conn)
+		if errRead != nil || len(data) != 0 {
+			test.Fatalf("expected connection to be closed without response, got %q, %v", data, errRead)
+		}
+	})
+}
+
+func TestStatusMapping(test *testing.T) {
+	var tc = func(code status.Code, expected spartan.Code) {
+		if got := spartan.FromStatus(code); got != expected {
+			test.Errorf("%s: expected %s, got %s", code, expected, got)
+		}
+	}
+	tc(status.Input, spartan.ClientError)
+	tc(status.Success, spartan.Success)
+	tc(status.RedirectPermanent, spartan.Redirect)
+	tc(status.SlowDown, spartan.ServerError)
+	tc(status.NotFound, spartan.ClientError)
+	tc(status.ClientCertificateRequired, spartan.ClientError)
+}
+
+func TestParseRequest_Path(test *testing.T) {
+	var tc = func(path string, valid bool) {
+		test.Run(path, func(test *testing.T) {
+			var re = bufio.NewReader(strings.NewReader("example.com " + path + " 0\r\n"))
+			var _, errParse = spartan.ParseRequest(re, "192.0.2.1:4000", 0)
+			switch {
+			case valid && errParse != nil:
+				test.Fatalf("unexpected error: %v", errParse)
+			case !valid && !errors.Is(errParse, spartan.ErrBadRequest):
+				test.Fatalf("expected %v, got %v", spartan.ErrBadRequest, errParse)
+			}
+		})
+	}
+	tc("/", true)
+	tc("/docs/index.gmi", true)
+	tc("/docs/", true)
+	tc("/cgi-bin/../evil.sh", false)
+	tc("/./index.gmi", false)
+	tc("/..", false)
+	tc("/a//b", false)
+	tc("/a%00b", false)
+	tc("docs", false)
+}
+
+func TestRedirectPath(test *testing.T) {
+	var base, _ = url.Parse("spartan://example.com/docs/page?q=1")
+	var tc = func(target, expected string) {
+		test.Run(target, func(test *testing.T) {
+			if got := spartan.RedirectPath(base, target); got != expected {
+				test.Fatalf("expected %q, got %q", expected, got)
+			}
+		})
+	}
+	tc("/target", "/target")
+	tc("other", "/docs/other")
+	tc("../x", "/x")
+	tc("../../x", "/x")
+	tc("./a/../b", "/docs/b")
+	tc("/search?q=hello%20world", "/search?q=hello%20world")
+	tc("?page=2", "/docs/page?page=2")
+	tc("spartan://other.com/path?a=b", "/path?a=b")
+}
+
+func TestClient_HeaderTooLarge(test *testing.T) {
+	var client = &spartan.Client{
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			var clientConn, serverConn = net.Pipe()
+			go func() {
+				defer func() { _ = serverConn.Close() }()
+				_, _ = bufio.NewReader(serverConn).ReadString('\n')
+				// the header line is never terminated
+				for {
+					if _, err := io.WriteString(serverConn, strings.Repeat("2", 512)); err != nil {
+						return
+					}
+				}
+			}()
+			return clientConn, nil
+		},
+	}
+	var _, errFetch = client.Fetch(context.Background(), "spartan://example.com/")
+	if !errors.Is(errFetch, gemax.ErrHeaderTooLarge) {
+		test.Fatalf("expected %v, got %v", gemax.ErrHeaderTooLarge, errFetch)
+	}
+}
@@ -0,0 +1,64 @@
+package spartan
+
+import (
+	"strconv"
+
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// Code is a spartan protocol status code.
+type Code int
+
+// Spartan status codes.
+const (
+	Success     Code = 2
+	Redirect    Code = 3
+	ClientError Code = 4
+	ServerError Code = 5
+)
+
+// String returns a text representation of the code.
+func (code Code) String() string {
+	switch code {
+	case Success:
+		return "SUCCESS"
+	case Redirect:
+		return "REDIRECT"
+	case ClientError:
+		return "CLIENT ERROR"
+	case ServerError:
+		return "SERVER ERROR"
+	default:
+		return "UNKNOWN STATUS CODE " + strconv.Itoa(int(code))
+	}
+}
+
+// FromStatus maps gemini status code to spartan status code.
+// Spartan has no input and certificate statuses, so they are reported as client errors.
+// Gemini temporary failures are server errors and permanent failures are client errors.
+func FromStatus(code status.Code) Code {
+	switch code / 10 {
+	case 2:
+		return Success
+	case 3:
+		return Redirect
+	case 4:
+		return ServerError
+	default:
+		return ClientError
+	}
+}
+
+// Status maps spartan status code to gemini status code.
+func (code Code) Status() status.Code {
+	switch code {
+	case Success:
+		return status.Success
+	case Redirect:
+		return status.Redirect
+	case ServerError:
+		return status.TemporaryFailure
+	default:
+		return status.PermanentFailure
+	}
+}
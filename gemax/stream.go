@@ -0,0 +1,81 @@
+package gemax
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// ErrClientDisconnected is the cause of handler context cancellation,
+// when the connection is reset by the client or the response can't be written.
+// Clients, which only close their side of the connection, still get the whole response.
+// See context.Cause.
+var ErrClientDisconnected = errors.New("client disconnected")
+
+// Streaming wraps handler and flushes each write of the response body to the client immediately.
+// It's useful for long-lived responses, like chats and log tails.
+// Use the handler context to stop streaming, when the client disconnects.
+//
+// Example:
+//
+//	Streaming(func(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
+//		for {
+//			select {
+//			case <-ctx.Done():
+//				return
+//			case line := <-lines:
+//				_, _ = io.WriteString(rw, line)
+//			}
+//		}
+//	})
+func Streaming(handler Handler) Handler {
+	return func(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
+		handler(ctx, &streamWriter{WrapResponseWriter(rw)}, req)
+	}
+}
+
+type streamWriter struct {
+	*ResponseWriterWrapper
+}
+
+func (rw *streamWriter) Write(data []byte) (int, error) {
+	var n, errWrite = rw.ResponseWriterWrapper.Write(data)
+	if errWrite != nil {
+		return n, errWrite
+	}
+	return n, rw.Flush()
+}
+
+// watchDisconnect reads the connection in background and cancels the handler context,
+// when the connection is broken. Gemini clients send nothing after the request line,
+// so unexpected data is discarded. EOF means that the client has closed its side of
+// the connection, but may still read the response, so watching stops without cancellation.
+// Closed connections are detected by failed writes then. It returns after the context is done.
+func watchDisconnect(ctx context.Context, conn *timeoutConn, cancel context.CancelCauseFunc) {
+	var buf [512]byte
+	for {
+		var _, errRead = conn.Read(buf[:])
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errRead == nil:
+			continue
+		case errors.Is(errRead, os.ErrDeadlineExceeded) && !deadlinePassed(conn.readDeadline):
+			// idle timeout is not applied to the background read
+			continue
+		case errors.Is(errRead, os.ErrDeadlineExceeded), errors.Is(errRead, net.ErrClosed),
+			errors.Is(errRead, io.EOF), errors.Is(errRead, io.ErrUnexpectedEOF):
+			return
+		default:
+			cancel(ErrClientDisconnected)
+			return
+		}
+	}
+}
+
+func deadlinePassed(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
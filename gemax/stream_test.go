@@ -0,0 +1,193 @@
+package gemax_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+func TestServer_Streaming(test *testing.T) {
+	test.Parallel()
+	var next = make(chan struct{})
+	var causes = make(chan error, 1)
+	var listener, server = setupServer(test, gemax.Streaming(func(ctx context.Context, rw gemax.ResponseWriter, _ gemax.IncomingRequest) {
+		_, _ = io.WriteString(rw, "first\n")
+		select {
+		case <-next:
+		case <-time.After(5 * time.Second):
+			test.Errorf("client didn't get the first line")
+		}
+		_, _ = io.WriteString(rw, "second\n")
+		// closed connection is detected by the next failed write
+		var ticker = time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				causes <- context.Cause(ctx)
+				return
+			case <-ticker.C:
+				_, _ = io.WriteString(rw, "tick\n")
+			}
+		}
+	}))
+	var ctx = test.Context()
+	runTask(test, func() {
+		_ = server.Serve(ctx, listener)
+	})
+	test.Cleanup(func() { _ = listener.Close() })
+
+	var conn, errDial = listener.Dial(ctx, "tcp", test.Name())
+	if errDial != nil {
+		test.Fatal(errDial)
+	}
+	defer func() { _ = conn.Close() }()
+	_, _ = io.WriteString(conn, "gemini://example.com/\r\n")
+
+	var re = bufio.NewReader(conn)
+	for _, expected := range []string{"20 text/gemini\r\n", "first\n", "second\n"} {
+		var line, errRead = re.ReadString('\n')
+		if errRead != nil || line != expected {
+			test.Fatalf("expected %q, got %q: %v", expected, line, errRead)
+		}
+		if expected == "first\n" {
+			close(next)
+		}
+	}
+
+	_ = conn.Close()
+	select {
+	case cause := <-causes:
+		if !errors.Is(cause, gemax.ErrClientDisconnected) {
+			test.Errorf("expected %v, got %v", gemax.ErrClientDisconnected, cause)
+		}
+	case <-time.After(time.Second):
+		test.Fatal("handler context is not canceled after disconnect")
+	}
+}
+
+func TestServer_Streaming_HalfClose(test *testing.T) {
+	test.Parallel()
+	var server = &gemax.Server{
+		Logf: test.Logf,
+		Handler: func(ctx context.Context, rw gemax.ResponseWriter, _ gemax.IncomingRequest) {
+			_, _ = io.WriteString(rw, "first\n")
+			_ = rw.(gemax.Flusher).Flush()
+			// give the server time to observe the half-close
+			time.Sleep(100 * time.Millisecond)
+			_, _ = fmt.Fprintf(rw, "cause: %v", context.Cause(ctx))
+		},
+	}
+	var listener, errListen = net.Listen("tcp", "127.0.0.1:0")
+	if errListen != nil {
+		test.Fatal(errListen)
+	}
+	var ctx = test.Context()
+	runTask(test, func() {
+		_ = server.Serve(ctx, listener)
+	})
+	test.Cleanup(func() { _ = listener.Close() })
+
+	var conn, errDial = net.Dial("tcp", listener.Addr().String())
+	if errDial != nil {
+		test.Fatal(errDial)
+	}
+	defer func() { _ = conn.Close() }()
+	_, _ = io.WriteString(conn, "gemini://example.com/\r\n")
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		test.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	expectResponse(test, conn, "20 text/gemini\r\nfirst\ncause: <nil>")
+}
+
+func TestServer_HandlerContext(test *testing.T) {
+	test.Parallel()
+	var started = make(chan struct{}, 1)
+	var causes = make(chan error, 1)
+	var listener, server = setupServer(test, func(ctx context.Context, rw gemax.ResponseWriter, req gemax.IncomingRequest) {
+		if fromCtx, ok := gemax.RequestFromContext(ctx); !ok || fromCtx != req {
+			test.Errorf("expected request in context, got %v", fromCtx)
+		}
+		started <- struct{}{}
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+		rw.WriteStatus(status.TemporaryFailure, "canceled")
+	})
+	server.HandlerTimeout = 50 * time.Millisecond
+	var ctx = test.Context()
+	runTask(test, func() {
+		_ = server.Serve(ctx, listener)
+	})
+	test.Cleanup(func() { _ = listener.Close() })
+
+	var resp = dialAndWrite(test, ctx, listener, "gemini://example.com/\r\n")
+	expectResponse(test, strings.NewReader(resp), "40 canceled\r\n")
+	<-started
+	if cause := <-causes; !errors.Is(cause, gemax.ErrHandlerTimeout) {
+		test.Errorf("expected %v, got %v", gemax.ErrHandlerTimeout, cause)
+	}
+
+	// forced shutdown
+	server.HandlerTimeout = 0
+	var conn, errDial = listener.Dial(ctx, "tcp", test.Name())
+	if errDial != nil {
+		test.Fatal(errDial)
+	}
+	defer func() { _ = conn.Close() }()
+	_, _ = io.WriteString(conn, "gemini://example.com/\r\n")
+	<-started
+
+	var shutdownCtx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		test.Errorf("expected forced shutdown, got %v", err)
+	}
+	if cause := <-causes; !errors.Is(cause, gemax.ErrServerClosed) {
+		test.Errorf("expected %v, got %v", gemax.ErrServerClosed, cause)
+	}
+}
+
+func TestServer_Shutdown(test *testing.T) {
+	test.Parallel()
+	var started = make(chan struct{})
+	var release = make(chan struct{})
+	var listener, server = setupServer(test, func(_ context.Context, rw gemax.ResponseWriter, _ gemax.IncomingRequest) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(rw, "done")
+	})
+	var ctx = test.Context()
+	var served = make(chan error, 1)
+	go func() { served <- server.Serve(ctx, listener) }()
+
+	var resp = make(chan string, 1)
+	go func() { resp <- dialAndWrite(test, ctx, listener, "gemini://example.com/\r\n") }()
+	<-started
+
+	var shutdown = make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(ctx) }()
+	select {
+	case err := <-shutdown:
+		test.Fatalf("shutdown returned before the request is served: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if err := <-shutdown; err != nil {
+		test.Errorf("unexpected shutdown error: %v", err)
+	}
+	expectResponse(test, strings.NewReader(<-resp), "20 text/gemini\r\ndone")
+	if err := <-served; !errors.Is(err, net.ErrClosed) {
+		test.Errorf("expected closed listener, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package gemax
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// DefaultMaxUploadSize is the default limit of titan upload size in bytes.
+const DefaultMaxUploadSize = 1 << 20
+
+// ErrUploadTooLarge means that titan upload size exceeds the limit.
+var ErrUploadTooLarge = errors.New("upload is too large")
+
+// ErrUploadsDisabled means that titan uploads are rejected by the server.
+var ErrUploadsDisabled = errors.New("uploads are disabled")
+
+// TitanParams contains titan upload parameters.
+// Titan requests are in form of "titan://host/path;mime=<type>;size=<bytes>;token=<token>".
+type TitanParams struct {
+	// MIME type of uploaded content. Default is "text/gemini".
+	MIME string
+	// Size of uploaded content in bytes.
+	Size int64
+	// Optional authorization token.
+	Token string
+}
+
+// TitanRequest is an incoming titan upload request.
+// Its URL doesn't contain titan parameters,
+// so the request can be routed by path as a usual gemini request.
+type TitanRequest interface {
+	IncomingRequest
+	TitanParams() TitanParams
+	// Body returns uploaded content. It's limited by TitanParams().Size bytes.
+	Body() io.Reader
+}
+
+// RequestBody returns the request body if the request has one
+// (e.g. titan uploads or spartan data blocks), otherwise nil.
+func RequestBody(req IncomingRequest) io.Reader {
+	if withBody, ok := req.(interface{ Body() io.Reader }); ok {
+		return withBody.Body()
+	}
+	return nil
+}
+
+type titanRequest struct {
+	*incomingRequest
+	params TitanParams
+	body   io.Reader
+}
+
+func (req *titanRequest) TitanParams() TitanParams {
+	return req.params
+}
+
+func (req *titanRequest) Body() io.Reader {
+	return req.body
+}
+
+// parseTitanParams extracts titan parameters from the URL path and removes them from the URL.
+// Parameters are split in the escaped path, so percent-encoded ";" can be used in parameter values.
+// Negative maxUpload rejects all uploads with ErrUploadsDisabled.
+func parseTitanParams(u *url.URL, maxUpload int64) (TitanParams, error) {
+	var params = TitanParams{MIME: MIMEGemtext, Size: -1}
+	if maxUpload < 0 {
+		return params, fmt.Errorf("%w: %w", ErrBadRequest, ErrUploadsDisabled)
+	}
+	var p, rawParams, _ = strings.Cut(u.EscapedPath(), ";")
+	var path, errPath = url.PathUnescape(p)
+	if errPath != nil {
+		return params, fmt.Errorf("%w: %w", ErrBadRequest, errPath)
+	}
+	u.Path, u.RawPath = path, ""
+	for _, param := range strings.Split(rawParams, ";") {
+		if errParam := params.set(param); errParam != nil {
+			return params, errParam
+		}
+	}
+	switch {
+	case params.Size < 0:
+		return params, fmt.Errorf("%w: missing titan size parameter", ErrBadRequest)
+	case params.Size > maxUpload:
+		return params, fmt.Errorf("%w: %w: %d bytes", ErrBadRequest, ErrUploadTooLarge, params.Size)
+	}
+	return params, nil
+}
+
+// set parses a single escaped "key=value" titan parameter. Unknown parameters are ignored.
+func (params *TitanParams) set(param string) error {
+	var key, rawValue, _ = strings.Cut(param, "=")
+	var value, errValue = url.PathUnescape(rawValue)
+	if errValue != nil {
+		return fmt.Errorf("%w: invalid titan parameter %q: %w", ErrBadRequest, key, errValue)
+	}
+	switch key {
+	case "mime":
+		params.MIME = value
+	case "token":
+		params.Token = value
+	case "size":
+		var size, errSize = strconv.ParseInt(value, 10, 64)
+		if errSize != nil || size < 0 {
+			return fmt.Errorf("%w: invalid titan size %q", ErrBadRequest, value)
+		}
+		params.Size = size
+	}
+	return nil
+}
+
+// Titan routes titan upload requests to the upload handler
+// and all other requests to the next handler.
+func Titan(upload, next Handler) Handler {
+	return func(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
+		if _, ok := req.(TitanRequest); ok {
+			upload(ctx, rw, req)
+			return
+		}
+		next(ctx, rw, req)
+	}
+}
+
+// RequireCertificate wraps handler and rejects requests without client certificates
+// with status.ClientCertificateRequired.
+// If authorize is not nil, then it's called with the first client certificate
+// and unauthorized requests are rejected with status.CertificateNotAuthorized.
+//
+// Example:
+//
+//	Titan(RequireCertificate(isEditor, wiki.Upload), wiki.Serve)
+func RequireCertificate(authorize func(cert *x509.Certificate) bool, handler Handler) Handler {
+	return func(ctx context.Context, rw ResponseWriter, req IncomingRequest) {
+		var certs = req.Certificates()
+		switch {
+		case len(certs) == 0:
+			rw.WriteStatus(status.ClientCertificateRequired, "client certificate is required")
+		case authorize != nil && !authorize(certs[0]):
+			rw.WriteStatus(status.CertificateNotAuthorized, "certificate is not authorized")
+		default:
+			handler(ctx, rw, req)
+		}
+	}
+}
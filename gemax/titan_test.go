@@ -0,0 +1,170 @@
+package gemax_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+func TestParseIncomingRequest_Titan(test *testing.T) {
+	test.Parallel()
+	var re = strings.NewReader("titan://example.com/wiki/page;mime=text/plain;size=5;token=secret\r\nhello, extra")
+
+	var req, errParse = gemax.ParseIncomingRequest(re, "remote")
+	if errParse != nil {
+		test.Fatalf("unexpected error: %v", errParse)
+	}
+	var titan, ok = req.(gemax.TitanRequest)
+	if !ok {
+		test.Fatalf("titan request is expected, got %T", req)
+	}
+	assertEq(test, titan.URL().String(), "titan://example.com/wiki/page", "url")
+	assertEq(test, titan.TitanParams(), gemax.TitanParams{MIME: "text/plain", Size: 5, Token: "secret"}, "params")
+
+	var body, _ = io.ReadAll(gemax.RequestBody(req))
+	assertEq(test, string(body), "hello", "body")
+}
+
+func TestParseIncomingRequest_TitanEscapedParams(test *testing.T) {
+	test.Parallel()
+	var re = strings.NewReader("titan://example.com/wiki/a%3Bb;mime=text/plain%3B%20charset=utf-8;size=0;token=se%3Bcret%3F\r\n")
+
+	var req, errParse = gemax.ParseIncomingRequest(re, "remote")
+	if errParse != nil {
+		test.Fatalf("unexpected error: %v", errParse)
+	}
+	var titan = req.(gemax.TitanRequest)
+	assertEq(test, titan.URL().Path, "/wiki/a;b", "path")
+	assertEq(test, titan.TitanParams(), gemax.TitanParams{MIME: "text/plain; charset=utf-8", Size: 0, Token: "se;cret?"}, "params")
+}
+
+func TestParseIncomingRequest_TitanErrors(test *testing.T) {
+	test.Parallel()
+	var tc = func(name, input string, target error) {
+		test.Run(name, func(test *testing.T) {
+			var _, errParse = gemax.ParseIncomingRequest(strings.NewReader(input), "remote")
+			if !errors.Is(errParse, target) {
+				test.Fatalf("expected %v, got %v", target, errParse)
+			}
+		})
+	}
+	tc("missing size", "titan://example.com/page;mime=text/plain\r\n", gemax.ErrBadRequest)
+	tc("invalid size", "titan://example.com/page;size=-1\r\n", gemax.ErrBadRequest)
+	tc("too large", "titan://example.com/page;size=9999999999\r\n", gemax.ErrUploadTooLarge)
+}
+
+func TestServer_Titan(test *testing.T) {
+	var upload = func(_ context.Context, rw gemax.ResponseWriter, req gemax.IncomingRequest) {
+		var body, _ = io.ReadAll(gemax.RequestBody(req))
+		rw.WriteStatus(status.Success, "text/plain")
+		_, _ = io.WriteString(rw, req.URL().Path+": "+string(body))
+	}
+	var listener, server = setupEchoServer(test)
+	server.Hosts = []string{"example.com"}
+	server.Handler = gemax.Titan(upload, server.Handler)
+	defer func() { _ = listener.Close() }()
+	var ctx, cancel = context.WithCancel(context.Background())
+	test.Cleanup(cancel)
+	runTask(test, func() {
+		var err = server.Serve(ctx, listener)
+		if err != nil {
+			test.Logf("test server: Serve: %v", err)
+		}
+	})
+
+	var resp = dialAndWrite(test, ctx, listener, "titan://example.com/page;size=5\r\nhello")
+	expectResponse(test, strings.NewReader(resp), "20 text/plain\r\n/page: hello")
+
+	resp = dialAndWrite(test, ctx, listener, "gemini://example.com/page\r\n")
+	expectResponse(test, strings.NewReader(resp), "20 text/gemini\r\ngemini://example.com/page")
+}
+
+func TestServer_TitanUploadsDisabled(test *testing.T) {
+	var upload = func(_ context.Context, rw gemax.ResponseWriter, _ gemax.IncomingRequest) {
+		rw.WriteStatus(status.Success, "text/plain")
+	}
+	var listener, server = setupServer(test, gemax.Titan(upload, nil))
+	server.Hosts = []string{"example.com"}
+	server.MaxUploadSize = -1
+	var ctx = test.Context()
+	runTask(test, func() {
+		_ = server.Serve(ctx, listener)
+	})
+	test.Cleanup(func() { _ = listener.Close() })
+
+	var resp = dialAndWrite(test, ctx, listener, "titan://example.com/page;size=0\r\n")
+	expectResponse(test, strings.NewReader(resp), "59 "+status.Text(status.BadRequest)+"\r\n")
+}
+
+func TestServer_TitanUploadTimeout(test *testing.T) {
+	var upload = func(_ context.Context, rw gemax.ResponseWriter, req gemax.IncomingRequest) {
+		var _, errRead = io.ReadAll(gemax.RequestBody(req))
+		rw.WriteStatus(status.Success, "text/plain")
+		_, _ = io.WriteString(rw, strconv.FormatBool(errors.Is(errRead, os.ErrDeadlineExceeded)))
+	}
+	var listener, server = setupServer(test, gemax.Titan(upload, nil))
+	server.Hosts = []string{"example.com"}
+	server.UploadTimeout = 100 * time.Millisecond
+	var ctx = test.Context()
+	runTask(test, func() {
+		_ = server.Serve(ctx, listener)
+	})
+	test.Cleanup(func() { _ = listener.Close() })
+
+	var conn, errDial = listener.Dial(ctx, "tcp", test.Name())
+	if errDial != nil {
+		test.Fatal(errDial)
+	}
+	defer func() { _ = conn.Close() }()
+	// body is never completed
+	_, _ = io.WriteString(conn, "titan://example.com/page;size=5\r\nhe")
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	expectResponse(test, conn, "20 text/plain\r\ntrue")
+}
+
+func TestRequireCertificate(test *testing.T) {
+	var handler = gemax.RequireCertificate(
+		func(cert *x509.Certificate) bool { return cert.Subject.CommonName == "client" },
+		func(_ context.Context, rw gemax.ResponseWriter, _ gemax.IncomingRequest) {
+			rw.WriteStatus(status.Success, "text/plain")
+		},
+	)
+	var tc = func(name string, certs []*x509.Certificate, expected status.Code) {
+		test.Run(name, func(test *testing.T) {
+			var rw = &responseRecorder{}
+			handler(context.Background(), rw, &certRequest{certs: certs})
+			assertEq(test, rw.status, expected, "status")
+		})
+	}
+	tc("no certificate", nil, status.ClientCertificateRequired)
+	tc("not authorized", []*x509.Certificate{leafCert(test, serverCert)}, status.CertificateNotAuthorized)
+	tc("authorized", []*x509.Certificate{leafCert(test, clientCert)}, status.Success)
+}
+
+func leafCert(t *testing.T, cert tls.Certificate) *x509.Certificate {
+	t.Helper()
+	var leaf, errParse = x509.ParseCertificate(cert.Certificate[0])
+	if errParse != nil {
+		t.Fatalf("parsing certificate: %v", errParse)
+	}
+	return leaf
+}
+
+type certRequest struct {
+	request
+	certs []*x509.Certificate
+}
+
+func (req *certRequest) Certificates() []*x509.Certificate {
+	return req.certs
+}
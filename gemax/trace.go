@@ -0,0 +1,187 @@
+package gemax
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+// ClientTrace is a set of hooks to run at various stages of a client request.
+// Any particular hook may be nil. Hooks are called synchronously from the goroutine
+// calling Client.Fetch. Redirects and SLOW DOWN retries are traced as separate requests.
+// See WithClientTrace.
+type ClientTrace struct {
+	// DNSStart is called before server host name is resolved.
+	// It's not called for IP addresses and custom Client.Dial functions.
+	DNSStart func(host string)
+	// DNSDone is called after server host name is resolved.
+	// Resolved addresses are reported by ConnectStart.
+	DNSDone func(err error)
+	// ConnectStart is called before each connection attempt with the resolved address.
+	// IPv4 and IPv6 addresses may be dialed in parallel, so the hook may be called
+	// from dialer goroutines concurrently. DNSDone is called from them as well.
+	// If Client.Dial is set, then it's called with the server host once.
+	ConnectStart func(network, addr string)
+	// ConnectDone is called once after dialing with the connected address or
+	// with the server host and the dial error.
+	ConnectDone func(network, addr string, err error)
+	// TLSHandshakeStart is called before the TLS handshake.
+	// It's not called for custom Client.Dial functions.
+	TLSHandshakeStart func()
+	// TLSHandshakeDone is called after the TLS handshake with the resulting
+	// connection state, which contains the peer certificates.
+	// For custom Client.Dial functions it's called only if a *tls.Conn is returned.
+	TLSHandshakeDone func(state tls.ConnectionState, err error)
+	// WroteRequest is called after the request line is written.
+	WroteRequest func(err error)
+	// GotFirstResponseByte is called, when the first byte of the response header is read.
+	GotFirstResponseByte func()
+	// GotHeader is called after the response header is parsed.
+	GotHeader func(code status.Code, meta string, err error)
+}
+
+type clientTraceKey struct{}
+
+// WithClientTrace returns a new context, which makes Client to call trace hooks.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns the ClientTrace associated with the context.
+// Returns nil, if there is no trace.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	var trace, _ = ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+func (trace *ClientTrace) dnsStart(host string) {
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(host)
+	}
+}
+
+func (trace *ClientTrace) dnsDone(err error) {
+	if trace != nil && trace.DNSDone != nil {
+		trace.DNSDone(err)
+	}
+}
+
+func (trace *ClientTrace) connectStart(network, addr string) {
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart(network, addr)
+	}
+}
+
+func (trace *ClientTrace) connectDone(network, addr string, err error) {
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone(network, addr, err)
+	}
+}
+
+func (trace *ClientTrace) tlsHandshakeStart() {
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+}
+
+func (trace *ClientTrace) tlsHandshakeDone(state tls.ConnectionState, err error) {
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(state, err)
+	}
+}
+
+func (trace *ClientTrace) wroteRequest(err error) {
+	if trace != nil && trace.WroteRequest != nil {
+		trace.WroteRequest(err)
+	}
+}
+
+func (trace *ClientTrace) gotHeader(code status.Code, meta string, err error) {
+	if trace != nil && trace.GotHeader != nil {
+		trace.GotHeader(code, meta, err)
+	}
+}
+
+// firstByteReader calls GotFirstResponseByte hook on the first read byte.
+type firstByteReader struct {
+	net.Conn
+	trace *ClientTrace
+	done  bool
+}
+
+func (conn *firstByteReader) Read(data []byte) (int, error) {
+	var n, errRead = conn.Conn.Read(data)
+	if n > 0 && !conn.done {
+		conn.done = true
+		conn.trace.GotFirstResponseByte()
+	}
+	return n, errRead
+}
+
+// dialTrace reports net.Dialer steps to ClientTrace hooks.
+type dialTrace struct {
+	trace *ClientTrace
+	// resolve reports whether the dialed host is a name, which must be resolved
+	resolve bool
+	once    sync.Once
+}
+
+// control is used as net.Dialer.ControlContext, which is called before each connection attempt.
+func (dt *dialTrace) control(_ context.Context, network, addr string, _ syscall.RawConn) error {
+	dt.resolved(nil)
+	dt.trace.connectStart(network, addr)
+	return nil
+}
+
+// resolved calls DNSDone hook once.
+func (dt *dialTrace) resolved(err error) {
+	if dt.resolve {
+		dt.once.Do(func() { dt.trace.dnsDone(err) })
+	}
+}
+
+// ServerTrace is a set of hooks to run at various stages of a served connection.
+// Any particular hook may be nil. Hooks are called synchronously from connection goroutines,
+// so they must be safe for concurrent use. See Server.Trace.
+type ServerTrace struct {
+	// Accepted is called for each accepted connection.
+	Accepted func(conn net.Conn)
+	// HandshakeDone is called after the TLS handshake. Use conn.(*tls.Conn).ConnectionState
+	// to inspect the negotiated parameters.
+	HandshakeDone func(conn net.Conn, err error)
+	// RequestParsed is called after the request line is read.
+	// Request is nil, if it's malformed.
+	RequestParsed func(conn net.Conn, req IncomingRequest, err error)
+	// WroteStatus is called after the response header is written.
+	WroteStatus func(conn net.Conn, code status.Code, meta string)
+	// Closed is called after the connection is closed.
+	Closed func(conn net.Conn)
+}
+
+func (trace *ServerTrace) accepted(conn net.Conn) {
+	if trace != nil && trace.Accepted != nil {
+		trace.Accepted(conn)
+	}
+}
+
+func (trace *ServerTrace) handshakeDone(conn net.Conn, err error) {
+	if trace != nil && trace.HandshakeDone != nil {
+		trace.HandshakeDone(conn, err)
+	}
+}
+
+func (trace *ServerTrace) requestParsed(conn net.Conn, req IncomingRequest, err error) {
+	if trace != nil && trace.RequestParsed != nil {
+		trace.RequestParsed(conn, req, err)
+	}
+}
+
+func (trace *ServerTrace) closed(conn net.Conn) {
+	if trace != nil && trace.Closed != nil {
+		trace.Closed(conn)
+	}
+}
@@ -0,0 +1,123 @@
+package gemax_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ninedraft/gemax/gemax"
+	"github.com/ninedraft/gemax/gemax/internal/testaddr"
+	"github.com/ninedraft/gemax/gemax/status"
+)
+
+func TestClientTrace(test *testing.T) {
+	test.Parallel()
+	var server = &gemax.Server{
+		Addr: testaddr.Addr(),
+		Logf: test.Logf,
+		Handler: func(_ context.Context, rw gemax.ResponseWriter, _ gemax.IncomingRequest) {
+			_, _ = io.WriteString(rw, "hello")
+		},
+	}
+	_ = startTLSServer(test, server)
+
+	var events []string
+	var peerCerts int
+	var ctx = gemax.WithClientTrace(test.Context(), &gemax.ClientTrace{
+		DNSStart: func(string) { events = append(events, "dns start") },
+		DNSDone:  func(error) { events = append(events, "dns done") },
+		ConnectDone: func(_, _ string, err error) {
+			// attempts to other resolved addresses may fail
+			if err == nil {
+				events = append(events, "connected")
+			}
+		},
+		TLSHandshakeStart: func() { events = append(events, "tls start") },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			peerCerts = len(state.PeerCertificates)
+			events = append(events, "tls done")
+		},
+		WroteRequest:         func(error) { events = append(events, "wrote request") },
+		GotFirstResponseByte: func() { events = append(events, "first byte") },
+		GotHeader: func(code status.Code, meta string, err error) {
+			if code != status.Success || meta != gemax.MIMEGemtext || err != nil {
+				test.Errorf("unexpected header %s %q: %v", code, meta, err)
+			}
+			events = append(events, "header")
+		},
+	})
+
+	var resp, errFetch = (&gemax.Client{}).Fetch(ctx, "gemini://"+server.Addr+"/")
+	if errFetch != nil {
+		test.Fatal(errFetch)
+	}
+	expectResponse(test, resp, "hello")
+	_ = resp.Close()
+
+	var expected = []string{
+		"dns start", "dns done", "connected", "tls start", "tls done",
+		"wrote request", "first byte", "header",
+	}
+	if !slices.Equal(events, expected) {
+		test.Errorf("expected events %q, got %q", expected, events)
+	}
+	if peerCerts == 0 {
+		test.Errorf("expected peer certificates in TLS handshake trace")
+	}
+}
+
+func TestServerTrace(test *testing.T) {
+	test.Parallel()
+	var listener, server = setupEchoServer(test)
+	var mu sync.Mutex
+	var events []string
+	var record = func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	var closed = make(chan struct{})
+	server.Trace = &gemax.ServerTrace{
+		Accepted:      func(net.Conn) { record("accepted") },
+		HandshakeDone: func(net.Conn, error) { record("handshake") },
+		RequestParsed: func(_ net.Conn, req gemax.IncomingRequest, err error) {
+			if err != nil || req.URL().Path != "/path" {
+				test.Errorf("unexpected request %v: %v", req, err)
+			}
+			record("request")
+		},
+		WroteStatus: func(_ net.Conn, code status.Code, meta string) {
+			record("status " + code.String() + " " + meta)
+		},
+		Closed: func(net.Conn) {
+			record("closed")
+			close(closed)
+		},
+	}
+	var ctx = test.Context()
+	runTask(test, func() {
+		_ = server.Serve(ctx, listener)
+	})
+	test.Cleanup(func() { _ = listener.Close() })
+
+	var resp = dialAndWrite(test, ctx, listener, "gemini://example.com/path\r\n")
+	expectResponse(test, strings.NewReader(resp), "20 text/gemini\r\ngemini://example.com/path")
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		test.Fatal("connection close is not traced")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	var expected = []string{"accepted", "handshake", "request", "status " + status.Success.String() + " text/gemini", "closed"}
+	if !slices.Equal(events, expected) {
+		test.Errorf("expected events %q, got %q", expected, events)
+	}
+}